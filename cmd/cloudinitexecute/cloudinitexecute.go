@@ -14,6 +14,7 @@ import (
 	"github.com/rancher/os/config/cloudinit/system"
 	"github.com/rancher/os/pkg/docker"
 	"github.com/rancher/os/pkg/log"
+	"github.com/rancher/os/pkg/mounts"
 	"github.com/rancher/os/pkg/util"
 
 	"golang.org/x/net/context"
@@ -58,6 +59,8 @@ func Main() {
 }
 
 func ApplyConsole(cfg *rancherConfig.CloudConfig) {
+	applyUsersAndGroups(cfg)
+
 	if len(cfg.SSHAuthorizedKeys) > 0 {
 		if err := authorizeSSHKeys("rancher", cfg.SSHAuthorizedKeys, sshKeyName); err != nil {
 			log.Error(err)
@@ -69,45 +72,11 @@ func ApplyConsole(cfg *rancherConfig.CloudConfig) {
 
 	WriteFiles(cfg, "console")
 
-	for _, mount := range cfg.Mounts {
-		if len(mount) != 4 {
-			log.Errorf("Unable to mount %s: must specify exactly four arguments", mount[1])
-		}
-
-		if mount[2] == "nfs" || mount[2] == "nfs4" {
-			if err := os.MkdirAll(mount[1], 0755); err != nil {
-				log.Errorf("Unable to create mount point %s: %v", mount[1], err)
-				continue
-			}
-			cmdArgs := []string{mount[0], mount[1], "-t", mount[2]}
-			if mount[3] != "" {
-				cmdArgs = append(cmdArgs, "-o", mount[3])
-			}
-			cmd := exec.Command("mount", cmdArgs...)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			if err := cmd.Run(); err != nil {
-				log.Errorf("Failed to mount %s: %v", mount[1], err)
-			}
-			continue
-		}
+	applyDiskSetup(cfg)
 
-		device := util.ResolveDevice(mount[0])
-
-		if mount[2] == "swap" {
-			cmd := exec.Command("swapon", device)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			err := cmd.Run()
-			if err != nil {
-				log.Errorf("Unable to swapon %s: %v", device, err)
-			}
-			continue
-		}
-
-		if err := util.Mount(device, mount[1], mount[2], mount[3]); err != nil {
-			log.Errorf("Failed to mount %s: %v", mount[1], err)
-		}
+	// the mounts before services were mounted by init
+	if err := mounts.Apply(cfg, func(m mounts.Mount) bool { return !mounts.BeforeServices(m) }); err != nil {
+		log.Error(err)
 	}
 
 	err := util.RunCommandSequence(cfg.Runcmd)
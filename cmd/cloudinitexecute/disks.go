@@ -0,0 +1,356 @@
+package cloudinitexecute
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	rancherConfig "github.com/rancher/os/config"
+	"github.com/rancher/os/pkg/log"
+	"github.com/rancher/os/pkg/util"
+)
+
+const (
+	// disks and filesystems that were set up are stamped here, so
+	// that they are not set up again when they are overwritten
+	diskSetupDir = "/var/lib/rancher/disk-setup"
+)
+
+var (
+	sysClassBlock = "/sys/class/block"
+	procMounts    = "/proc/mounts"
+)
+
+// partitionFlags are the parted flags of the partition types of a layout,
+// either mbr ids or gdisk codes
+var partitionFlags = map[string]string{
+	"83":   "",
+	"8300": "",
+	"82":   "swap",
+	"8200": "swap",
+	"8e":   "lvm",
+	"8e00": "lvm",
+	"fd":   "raid",
+	"fd00": "raid",
+	"ef":   "boot",
+	"ef00": "boot",
+}
+
+// applyDiskSetup partitions the disks of disk_setup and then creates the
+// filesystems of fs_setup, so that they can be mounted by mounts
+func applyDiskSetup(cfg *rancherConfig.CloudConfig) {
+	devices := []string{}
+	for device := range cfg.DiskSetup {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+
+	for _, device := range devices {
+		if err := setupDisk(device, cfg.DiskSetup[device]); err != nil {
+			log.Errorf("Failed to partition %s: %v", device, err)
+		}
+	}
+
+	for _, fs := range cfg.FsSetup {
+		if err := setupFilesystem(fs); err != nil {
+			log.Errorf("Failed to create %s filesystem on %s: %v", fs.Filesystem, fs.Device, err)
+		}
+	}
+}
+
+func setupDisk(device string, disk rancherConfig.DiskSetup) error {
+	if len(disk.Layout) == 0 {
+		return nil
+	}
+
+	stamp := diskSetupStamp("disk", device, "")
+	if _, err := os.Stat(stamp); err == nil {
+		log.Debugf("Skipped partitioning %s because %s exists", device, stamp)
+		return nil
+	}
+
+	dev, err := resolveDisk(device)
+	if err != nil {
+		return err
+	}
+	if mounted(dev) {
+		return fmt.Errorf("%s is in use", dev)
+	}
+	if !disk.Overwrite {
+		if len(partitions(dev)) > 0 {
+			log.Infof("Skipped partitioning %s because it already has a partition table", dev)
+			return writeDiskSetupStamp(stamp)
+		}
+		if fsType, err := util.GetFsType(dev); err == nil && fsType != "" {
+			log.Infof("Skipped partitioning %s because it already has a %s filesystem", dev, fsType)
+			return writeDiskSetupStamp(stamp)
+		}
+	}
+
+	args, err := partedArgs(dev, disk)
+	if err != nil {
+		return err
+	}
+	log.Infof("Partitioning %s", dev)
+	if err := runCommand("parted", args...); err != nil {
+		return err
+	}
+	if err := runCommand("partprobe", dev); err != nil {
+		return err
+	}
+	if err := runCommand("udevadm", "settle"); err != nil {
+		log.Errorf("Failed to wait for the partitions of %s: %v", dev, err)
+	}
+	return writeDiskSetupStamp(stamp)
+}
+
+func setupFilesystem(fs rancherConfig.FsSetup) error {
+	if fs.Device == "" || fs.Filesystem == "" {
+		return fmt.Errorf("device and filesystem are required")
+	}
+
+	stamp := diskSetupStamp("fs", fs.Device, fs.Partition)
+	if _, err := os.Stat(stamp); err == nil {
+		log.Debugf("Skipped creating the filesystem on %s because %s exists", fs.Device, stamp)
+		return nil
+	}
+
+	if fs.Label != "" && !fs.Overwrite {
+		if dev, _, err := util.Blkid(fs.Label); err == nil && dev != "" {
+			log.Infof("Skipped creating the filesystem %s because it already exists on %s", fs.Label, dev)
+			return writeDiskSetupStamp(stamp)
+		}
+	}
+
+	dev, err := resolveDisk(fs.Device)
+	if err != nil {
+		return err
+	}
+	target, err := filesystemTarget(dev, fs.Partition)
+	if err != nil {
+		return err
+	}
+	if mounted(target) {
+		return fmt.Errorf("%s is in use", target)
+	}
+	if !fs.Overwrite {
+		if fsType, err := util.GetFsType(target); err == nil && fsType != "" {
+			log.Infof("Skipped creating the filesystem on %s because it already has a %s filesystem", target, fsType)
+			return writeDiskSetupStamp(stamp)
+		}
+	}
+
+	name, args := mkfsArgs(fs, target)
+	log.Infof("Creating %s filesystem on %s", fs.Filesystem, target)
+	if err := runCommand(name, args...); err != nil {
+		return err
+	}
+	// so that the label can be resolved by mounts
+	if err := runCommand("udevadm", "settle"); err != nil {
+		log.Errorf("Failed to wait for the filesystem on %s: %v", target, err)
+	}
+	return writeDiskSetupStamp(stamp)
+}
+
+// partedArgs partitions the whole disk, in the order of the layout
+func partedArgs(device string, disk rancherConfig.DiskSetup) ([]string, error) {
+	label := "gpt"
+	switch disk.TableType {
+	case "", "gpt":
+	case "mbr":
+		label = "msdos"
+		if len(disk.Layout) > 4 {
+			return nil, fmt.Errorf("mbr supports at most 4 partitions, got %d", len(disk.Layout))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported table type %s", disk.TableType)
+	}
+
+	args := []string{"-s", "-a", "optimal", device, "mklabel", label}
+	start := 0
+	for i, p := range disk.Layout {
+		end := start + p.Size
+		if end > 100 {
+			return nil, fmt.Errorf("partitions exceed 100%% of the disk")
+		}
+		args = append(args, "mkpart", "primary", fmt.Sprintf("%d%%", start), fmt.Sprintf("%d%%", end))
+
+		flag, ok := partitionFlags[strings.ToLower(p.Type)]
+		if !ok && p.Type != "" {
+			return nil, fmt.Errorf("unsupported partition type %s", p.Type)
+		}
+		if flag != "" {
+			args = append(args, "set", strconv.Itoa(i+1), flag, "on")
+		}
+		start = end
+	}
+	return args, nil
+}
+
+func mkfsArgs(fs rancherConfig.FsSetup, device string) (string, []string) {
+	name := "mkfs." + fs.Filesystem
+	labelFlag := "-L"
+	forceFlag := ""
+
+	switch fs.Filesystem {
+	case "swap":
+		name = "mkswap"
+		forceFlag = "-f"
+	case "vfat", "fat", "fat32":
+		name = "mkfs.vfat"
+		labelFlag = "-n"
+	case "ext2", "ext3", "ext4":
+		forceFlag = "-F"
+	case "xfs", "btrfs":
+		forceFlag = "-f"
+	}
+
+	args := []string{}
+	if fs.Overwrite && forceFlag != "" {
+		args = append(args, forceFlag)
+	}
+	if fs.Label != "" {
+		args = append(args, labelFlag, fs.Label)
+	}
+	args = append(args, fs.ExtraOpts...)
+	return name, append(args, device)
+}
+
+// filesystemTarget is the device to create the filesystem on: the
+// partition with the given number, the first one for "auto", the first one
+// without a filesystem for "any", and the device itself otherwise
+func filesystemTarget(device, partition string) (string, error) {
+	switch partition {
+	case "", "none":
+		return device, nil
+	case "auto", "any":
+		parts := partitions(device)
+		if len(parts) == 0 {
+			return "", fmt.Errorf("%s has no partitions", device)
+		}
+		if partition == "auto" {
+			return parts[0], nil
+		}
+		for _, part := range parts {
+			if fsType, err := util.GetFsType(part); err != nil || fsType == "" {
+				return part, nil
+			}
+		}
+		return "", fmt.Errorf("all the partitions of %s have a filesystem", device)
+	}
+
+	n, err := strconv.Atoi(partition)
+	if err != nil || n <= 0 {
+		return "", fmt.Errorf("invalid partition %s", partition)
+	}
+	return partitionDevice(device, n), nil
+}
+
+// partitionDevice is the device of the nth partition of a disk, which like
+// nvme0n1p1 has a p when the disk ends with a number
+func partitionDevice(device string, n int) string {
+	if last := device[len(device)-1]; last >= '0' && last <= '9' {
+		return fmt.Sprintf("%sp%d", device, n)
+	}
+	return fmt.Sprintf("%s%d", device, n)
+}
+
+// partitions are the partitions of a disk in sysfs, in order
+func partitions(device string) []string {
+	name := path.Base(device)
+	files, err := ioutil.ReadDir(path.Join(sysClassBlock, name))
+	if err != nil {
+		return nil
+	}
+
+	numbers := map[string]int{}
+	parts := []string{}
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), name) {
+			continue
+		}
+		data, err := ioutil.ReadFile(path.Join(sysClassBlock, name, file.Name(), "partition"))
+		if err != nil {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			continue
+		}
+		part := path.Join("/dev", file.Name())
+		numbers[part] = n
+		parts = append(parts, part)
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return numbers[parts[i]] < numbers[parts[j]]
+	})
+	return parts
+}
+
+// resolveDisk resolves LABEL= and UUID= specs and the links of
+// /dev/disk, so that the partitions can be found
+func resolveDisk(device string) (string, error) {
+	dev := device
+	if strings.Contains(device, "=") {
+		dev = util.ResolveDevice(device)
+		if dev == "" {
+			return "", fmt.Errorf("%s not found", device)
+		}
+	}
+	dev, err := filepath.EvalSymlinks(dev)
+	if err != nil {
+		return "", err
+	}
+	return dev, nil
+}
+
+// mounted returns whether the device or one of its partitions is mounted
+func mounted(device string) bool {
+	f, err := os.Open(procMounts)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	parts := partitions(device)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == device || util.Contains(parts, fields[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+func diskSetupStamp(kind, device, partition string) string {
+	name := strings.Trim(strings.Replace(device, "/", "_", -1), "_")
+	if partition != "" {
+		name += "-" + partition
+	}
+	return path.Join(diskSetupDir, fmt.Sprintf("%s-%s.done", kind, name))
+}
+
+func writeDiskSetupStamp(stamp string) error {
+	if err := os.MkdirAll(path.Dir(stamp), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(stamp, []byte{}, 0644)
+}
+
+func runCommand(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
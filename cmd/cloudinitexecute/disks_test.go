@@ -0,0 +1,91 @@
+package cloudinitexecute
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	rancherConfig "github.com/rancher/os/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartedArgs(t *testing.T) {
+	assert := require.New(t)
+
+	args, err := partedArgs("/dev/nvme1n1", rancherConfig.DiskSetup{
+		Layout: rancherConfig.DiskLayout{{Size: 100}},
+	})
+	assert.NoError(err)
+	assert.Equal([]string{"-s", "-a", "optimal", "/dev/nvme1n1", "mklabel", "gpt", "mkpart", "primary", "0%", "100%"}, args)
+
+	args, err = partedArgs("/dev/sdb", rancherConfig.DiskSetup{
+		TableType: "mbr",
+		Layout:    rancherConfig.DiskLayout{{Size: 25, Type: "82"}, {Size: 75, Type: "83"}},
+	})
+	assert.NoError(err)
+	assert.Equal([]string{"-s", "-a", "optimal", "/dev/sdb", "mklabel", "msdos",
+		"mkpart", "primary", "0%", "25%", "set", "1", "swap", "on",
+		"mkpart", "primary", "25%", "100%"}, args)
+
+	_, err = partedArgs("/dev/sdb", rancherConfig.DiskSetup{
+		Layout: rancherConfig.DiskLayout{{Size: 60}, {Size: 60}},
+	})
+	assert.Error(err)
+	_, err = partedArgs("/dev/sdb", rancherConfig.DiskSetup{
+		Layout: rancherConfig.DiskLayout{{Size: 100, Type: "a5"}},
+	})
+	assert.Error(err)
+}
+
+func TestMkfsArgs(t *testing.T) {
+	assert := require.New(t)
+
+	name, args := mkfsArgs(rancherConfig.FsSetup{Filesystem: "ext4", Label: "docker", Overwrite: true, ExtraOpts: []string{"-m", "0"}}, "/dev/nvme1n1p1")
+	assert.Equal("mkfs.ext4", name)
+	assert.Equal([]string{"-F", "-L", "docker", "-m", "0", "/dev/nvme1n1p1"}, args)
+
+	name, args = mkfsArgs(rancherConfig.FsSetup{Filesystem: "swap", Label: "swap"}, "/dev/sdb1")
+	assert.Equal("mkswap", name)
+	assert.Equal([]string{"-L", "swap", "/dev/sdb1"}, args)
+
+	name, args = mkfsArgs(rancherConfig.FsSetup{Filesystem: "vfat", Label: "DATA"}, "/dev/sdc")
+	assert.Equal("mkfs.vfat", name)
+	assert.Equal([]string{"-n", "DATA", "/dev/sdc"}, args)
+}
+
+func TestFilesystemTarget(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "sysfs")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	defer func(orig string) { sysClassBlock = orig }(sysClassBlock)
+	sysClassBlock = dir
+
+	for _, part := range []struct{ name, number string }{{"nvme1n1p2", "2"}, {"nvme1n1p1", "1"}} {
+		assert.NoError(os.MkdirAll(path.Join(dir, "nvme1n1", part.name), 0755))
+		assert.NoError(ioutil.WriteFile(path.Join(dir, "nvme1n1", part.name, "partition"), []byte(part.number+"\n"), 0644))
+	}
+	assert.NoError(os.MkdirAll(path.Join(dir, "nvme1n1", "queue"), 0755))
+
+	assert.Equal([]string{"/dev/nvme1n1p1", "/dev/nvme1n1p2"}, partitions("/dev/nvme1n1"))
+	assert.Nil(partitions("/dev/sdb"))
+
+	target, err := filesystemTarget("/dev/nvme1n1", "auto")
+	assert.NoError(err)
+	assert.Equal("/dev/nvme1n1p1", target)
+	target, err = filesystemTarget("/dev/nvme1n1", "2")
+	assert.NoError(err)
+	assert.Equal("/dev/nvme1n1p2", target)
+	target, err = filesystemTarget("/dev/sdb", "1")
+	assert.NoError(err)
+	assert.Equal("/dev/sdb1", target)
+	target, err = filesystemTarget("/dev/sdb", "none")
+	assert.NoError(err)
+	assert.Equal("/dev/sdb", target)
+	_, err = filesystemTarget("/dev/sdb", "auto")
+	assert.Error(err)
+	_, err = filesystemTarget("/dev/sdb", "first")
+	assert.Error(err)
+}
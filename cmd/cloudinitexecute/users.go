@@ -0,0 +1,279 @@
+package cloudinitexecute
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	rancherConfig "github.com/rancher/os/config"
+	"github.com/rancher/os/config/cloudinit/system"
+	"github.com/rancher/os/pkg/log"
+	"github.com/rancher/os/pkg/util"
+)
+
+const (
+	passwdFile  = "/etc/passwd"
+	groupFile   = "/etc/group"
+	sudoersFile = "/etc/sudoers"
+	sudoersDir  = "/etc/sudoers.d"
+	// users' sudoers files are named with this prefix, so the ones of
+	// users that are not configured anymore can be removed
+	sudoersPrefix = "cloud-config-"
+)
+
+// applyUsersAndGroups creates the configured groups and users in the
+// console, it is run on every boot as the console's /etc is not persistent
+func applyUsersAndGroups(cfg *rancherConfig.CloudConfig) {
+	for _, group := range cfg.Groups {
+		if err := createGroup(group.Name); err != nil {
+			log.Errorf("Failed to create group %s: %v", group.Name, err)
+		}
+	}
+
+	for _, u := range cfg.Users {
+		if err := applyUser(u); err != nil {
+			log.Errorf("Failed to apply user %s: %v", u.Name, err)
+		}
+	}
+
+	// members can be users created above
+	for _, group := range cfg.Groups {
+		for _, member := range group.Members {
+			if err := addToGroup(member, group.Name); err != nil {
+				log.Errorf("Failed to add %s to group %s: %v", member, group.Name, err)
+			}
+		}
+	}
+
+	if err := writeSudoers(cfg.Users); err != nil {
+		log.Errorf("Failed to write sudoers: %v", err)
+	}
+}
+
+func applyUser(u rancherConfig.User) error {
+	if u.Name == "" {
+		return fmt.Errorf("no name")
+	}
+
+	created := false
+	if !entryExists(passwdFile, u.Name) {
+		log.Infof("Creating user %s", u.Name)
+		created = true
+		coreosUser := u.User
+		if hasCommand("useradd") {
+			if err := system.CreateUser(&coreosUser); err != nil {
+				return err
+			}
+		} else {
+			if output, err := exec.Command("adduser", adduserArgs(u)...).CombinedOutput(); err != nil {
+				return fmt.Errorf("adduser: %v: %s", err, output)
+			}
+		}
+	}
+
+	for _, group := range u.Groups {
+		if err := addToGroup(u.Name, group); err != nil {
+			log.Errorf("Failed to add %s to group %s: %v", u.Name, group, err)
+		}
+	}
+
+	// leave the password of existing users like rancher alone, unless asked
+	if created || u.PasswordHash != "" || u.LockPasswd != nil {
+		if err := system.SetUserPassword(u.Name, passwordHash(u)); err != nil {
+			return err
+		}
+	}
+
+	if len(u.SSHAuthorizedKeys) > 0 {
+		if err := authorizeSSHKeys(u.Name, u.SSHAuthorizedKeys, sshKeyName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// passwordHash returns the hash to set for u, "*" disables password logins
+// without locking the account out of ssh
+func passwordHash(u rancherConfig.User) string {
+	if u.LockPasswd != nil && !*u.LockPasswd && u.PasswordHash != "" {
+		return u.PasswordHash
+	}
+	if u.PasswordHash != "" {
+		log.Warnf("Ignoring the password of %s, as lock_passwd is not false", u.Name)
+	}
+	return "*"
+}
+
+// adduserArgs are the arguments of busybox's adduser, for consoles without useradd
+func adduserArgs(u rancherConfig.User) []string {
+	args := []string{"-D"}
+	if u.GECOS != "" {
+		args = append(args, "-g", u.GECOS)
+	}
+	if u.Homedir != "" {
+		args = append(args, "-h", u.Homedir)
+	}
+	if u.NoCreateHome {
+		args = append(args, "-H")
+	}
+	if u.PrimaryGroup != "" {
+		args = append(args, "-G", u.PrimaryGroup)
+	}
+	if u.System {
+		args = append(args, "-S")
+	}
+	if u.Shell != "" {
+		args = append(args, "-s", u.Shell)
+	}
+	return append(args, u.Name)
+}
+
+func createGroup(name string) error {
+	if entryExists(groupFile, name) {
+		return nil
+	}
+	log.Infof("Creating group %s", name)
+	var cmd *exec.Cmd
+	if hasCommand("groupadd") {
+		cmd = exec.Command("groupadd", name)
+	} else {
+		cmd = exec.Command("addgroup", name)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	return nil
+}
+
+func addToGroup(user, group string) error {
+	members, err := groupMembers(groupFile, group)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		if member == user {
+			return nil
+		}
+	}
+	var cmd *exec.Cmd
+	if hasCommand("usermod") {
+		cmd = exec.Command("usermod", "-a", "-G", group, user)
+	} else {
+		cmd = exec.Command("addgroup", user, group)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	return nil
+}
+
+// writeSudoers writes a sudoers file for each user with a sudo rule, and
+// removes the ones of users that don't have one anymore
+func writeSudoers(users []rancherConfig.User) error {
+	if err := os.MkdirAll(sudoersDir, 0750); err != nil {
+		return err
+	}
+
+	rules := sudoersRules(users)
+	files, err := ioutil.ReadDir(sudoersDir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), sudoersPrefix) && rules[f.Name()] == "" {
+			if err := os.Remove(path.Join(sudoersDir, f.Name())); err != nil {
+				log.Errorf("Failed to remove %s: %v", f.Name(), err)
+			}
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	for name, rule := range rules {
+		if err := util.WriteFileAtomic(path.Join(sudoersDir, name), []byte(rule), 0440); err != nil {
+			return err
+		}
+	}
+	return includeSudoersDir(sudoersFile)
+}
+
+// sudoersRules returns the sudoers file of each user that has sudo rules
+func sudoersRules(users []rancherConfig.User) map[string]string {
+	rules := map[string]string{}
+	for _, u := range users {
+		lines := []string{}
+		for _, rule := range u.Sudo {
+			if rule != "" && rule != "false" {
+				lines = append(lines, fmt.Sprintf("%s %s\n", u.Name, rule))
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		// sudo ignores files with a dot in their name
+		name := sudoersPrefix + strings.Replace(u.Name, ".", "_", -1)
+		rules[name] = strings.Join(lines, "")
+	}
+	return rules
+}
+
+// includeSudoersDir makes sure sudo reads sudoersDir, which the default
+// console's sudoers doesn't
+func includeSudoersDir(filename string) error {
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(bytes), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "#includedir "+sudoersDir || line == "@includedir "+sudoersDir {
+			return nil
+		}
+	}
+	if len(bytes) > 0 && bytes[len(bytes)-1] != '\n' {
+		bytes = append(bytes, '\n')
+	}
+	bytes = append(bytes, []byte("#includedir "+sudoersDir+"\n")...)
+	return util.WriteFileAtomic(filename, bytes, 0440)
+}
+
+func hasCommand(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// entryExists is whether filename, /etc/passwd or /etc/group, has an entry for name
+func entryExists(filename, name string) bool {
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(bytes), "\n") {
+		if strings.HasPrefix(line, name+":") {
+			return true
+		}
+	}
+	return false
+}
+
+func groupMembers(filename, group string) ([]string, error) {
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(bytes), "\n") {
+		split := strings.Split(line, ":")
+		if len(split) < 4 || split[0] != group {
+			continue
+		}
+		if split[3] == "" {
+			return []string{}, nil
+		}
+		return strings.Split(split[3], ","), nil
+	}
+	return nil, fmt.Errorf("group %s not found", group)
+}
@@ -0,0 +1,111 @@
+package cloudinitexecute
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	rancherConfig "github.com/rancher/os/config"
+	"github.com/rancher/os/config/cloudinit/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordHash(t *testing.T) {
+	assert := require.New(t)
+
+	locked, unlocked := true, false
+	for _, tc := range []struct {
+		user rancherConfig.User
+		hash string
+	}{
+		{rancherConfig.User{}, "*"},
+		{rancherConfig.User{User: config.User{PasswordHash: "$6$salt$hash"}}, "*"},
+		{rancherConfig.User{User: config.User{PasswordHash: "$6$salt$hash"}, LockPasswd: &locked}, "*"},
+		{rancherConfig.User{User: config.User{PasswordHash: "$6$salt$hash"}, LockPasswd: &unlocked}, "$6$salt$hash"},
+		{rancherConfig.User{LockPasswd: &unlocked}, "*"},
+	} {
+		assert.Equal(tc.hash, passwordHash(tc.user), "%+v", tc.user)
+	}
+}
+
+func TestAdduserArgs(t *testing.T) {
+	assert := require.New(t)
+
+	for _, tc := range []struct {
+		user config.User
+		args []string
+	}{
+		{config.User{Name: "jane"}, []string{"-D", "jane"}},
+		{config.User{
+			Name:         "jane",
+			GECOS:        "Jane Doe",
+			Homedir:      "/home/jdoe",
+			PrimaryGroup: "staff",
+			Shell:        "/bin/bash",
+		}, []string{"-D", "-g", "Jane Doe", "-h", "/home/jdoe", "-G", "staff", "-s", "/bin/bash", "jane"}},
+		{config.User{Name: "backup", NoCreateHome: true, System: true}, []string{"-D", "-H", "-S", "backup"}},
+	} {
+		assert.Equal(tc.args, adduserArgs(rancherConfig.User{User: tc.user}))
+	}
+}
+
+func TestSudoersRules(t *testing.T) {
+	assert := require.New(t)
+
+	for _, tc := range []struct {
+		users []rancherConfig.User
+		rules map[string]string
+	}{
+		{nil, map[string]string{}},
+		{[]rancherConfig.User{
+			{User: config.User{Name: "jane"}, Sudo: rancherConfig.SudoRules{"ALL=(ALL) NOPASSWD:ALL"}},
+			{User: config.User{Name: "john"}},
+			{User: config.User{Name: "joe"}, Sudo: rancherConfig.SudoRules{"false"}},
+		}, map[string]string{
+			"cloud-config-jane": "jane ALL=(ALL) NOPASSWD:ALL\n",
+		}},
+		{[]rancherConfig.User{
+			{User: config.User{Name: "jane.doe"}, Sudo: rancherConfig.SudoRules{"ALL=(ALL) /usr/bin/ros", "ALL=(ALL) NOPASSWD:/usr/bin/system-docker"}},
+		}, map[string]string{
+			"cloud-config-jane_doe": "jane.doe ALL=(ALL) /usr/bin/ros\njane.doe ALL=(ALL) NOPASSWD:/usr/bin/system-docker\n",
+		}},
+	} {
+		assert.Equal(tc.rules, sudoersRules(tc.users))
+	}
+}
+
+func TestGroupMembers(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "users")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "group")
+	assert.NoError(ioutil.WriteFile(file, []byte(`root:x:0:
+docker:x:999:rancher,jane
+wheel:x:10:rancher
+`), 0644))
+
+	for _, tc := range []struct {
+		group   string
+		members []string
+		err     bool
+	}{
+		{"root", []string{}, false},
+		{"docker", []string{"rancher", "jane"}, false},
+		{"wheel", []string{"rancher"}, false},
+		{"whee", nil, true},
+	} {
+		members, err := groupMembers(file, tc.group)
+		if tc.err {
+			assert.Error(err)
+			continue
+		}
+		assert.NoError(err)
+		assert.Equal(tc.members, members)
+	}
+
+	_, err = groupMembers(path.Join(dir, "missing"), "root")
+	assert.Error(err)
+}
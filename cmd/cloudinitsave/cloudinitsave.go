@@ -38,7 +38,9 @@ import (
 	"github.com/rancher/os/config/cloudinit/datasource/metadata/ec2"
 	"github.com/rancher/os/config/cloudinit/datasource/metadata/exoscale"
 	"github.com/rancher/os/config/cloudinit/datasource/metadata/gce"
+	"github.com/rancher/os/config/cloudinit/datasource/metadata/openstack"
 	"github.com/rancher/os/config/cloudinit/datasource/metadata/packet"
+	"github.com/rancher/os/config/cloudinit/datasource/nocloud"
 	"github.com/rancher/os/config/cloudinit/datasource/proccmdline"
 	"github.com/rancher/os/config/cloudinit/datasource/proxmox"
 	"github.com/rancher/os/config/cloudinit/datasource/tftp"
@@ -84,7 +86,7 @@ func saveCloudConfig() error {
 	network.ApplyNetworkConfig(cfg)
 
 	log.Infof("datasources that will be considered: %#v", cfg.Rancher.CloudInit.Datasources)
-	dss := getDatasources(cfg.Rancher.CloudInit.Datasources)
+	dss := getDatasources(cfg.Rancher.CloudInit.Datasources, cfg.Rancher.CloudInit.IMDSv1Fallback)
 	if len(dss) == 0 {
 		log.Errorf("currentDatasource - none found")
 		return nil
@@ -174,11 +176,6 @@ func fetchAndSave(ds datasource.Datasource) error {
 		log.Errorf("Failed fetching user-data from datasource: %v", err)
 		return err
 	}
-	userDataBytes, err = decompressIfGzip(userDataBytes)
-	if err != nil {
-		log.Errorf("Failed decompressing user-data from datasource: %v", err)
-		return err
-	}
 	log.Infof("Fetching meta-data from datasource of type %v", ds.Type())
 	metadata, err = ds.FetchMetadata()
 	if err != nil {
@@ -187,24 +184,10 @@ func fetchAndSave(ds datasource.Datasource) error {
 	}
 
 	userData := string(userDataBytes)
-	scriptBytes := []byte{}
-
-	if config.IsScript(userData) {
-		scriptBytes = userDataBytes
-		userDataBytes = []byte{}
-	} else if isCompose(userData) {
-		if userDataBytes, err = composeToCloudConfig(userDataBytes); err != nil {
-			log.Errorf("Failed to convert compose to cloud-config syntax: %v", err)
-			return err
-		}
-	} else if config.IsCloudConfig(userData) {
-		if _, err := rancherConfig.ReadConfig(userDataBytes, false); err != nil {
-			log.WithFields(log.Fields{"cloud-config": userData, "err": err}).Warn("Failed to parse cloud-config, not saving.")
-			userDataBytes = []byte{}
-		}
-	} else {
-		log.Errorf("Unrecognized user-data\n(%s)", userData)
-		userDataBytes = []byte{}
+	userDataBytes, scriptBytes, err := processUserData(userDataBytes)
+	if err != nil {
+		log.Errorf("Failed to process user-data: %v", err)
+		return err
 	}
 
 	if _, err := rancherConfig.ReadConfig(userDataBytes, false); err != nil {
@@ -216,8 +199,9 @@ func fetchAndSave(ds datasource.Datasource) error {
 }
 
 // getDatasources creates a slice of possible Datasources for cloudinit based
-// on the different source command-line flags.
-func getDatasources(datasources []string) []datasource.Datasource {
+// on the different source command-line flags. imdsV1Fallback lets metadata
+// services requiring session tokens be queried without when they give none.
+func getDatasources(datasources []string, imdsV1Fallback bool) []datasource.Datasource {
 	dss := make([]datasource.Datasource, 0, 5)
 
 	for _, ds := range datasources {
@@ -230,7 +214,7 @@ func getDatasources(datasources []string) []datasource.Datasource {
 
 		switch parts[0] {
 		case "*":
-			dss = append(dss, getDatasources([]string{"configdrive", "vmware", "ec2", "digitalocean", "packet", "gce", "cloudstack", "exoscale", "proxmox"})...)
+			dss = append(dss, getDatasources([]string{"configdrive", "vmware", "ec2", "digitalocean", "packet", "gce", "cloudstack", "exoscale", "proxmox", "nocloud"}, imdsV1Fallback)...)
 		case "proxmox":
 			if root == "" {
 				root = "/media/pve-config"
@@ -243,7 +227,7 @@ func getDatasources(datasources []string) []datasource.Datasource {
 				dss = append(dss, source)
 			}
 		case "ec2":
-			dss = append(dss, ec2.NewDatasource(root))
+			dss = append(dss, ec2.NewDatasource(root, imdsV1Fallback))
 		case "file":
 			if root != "" {
 				dss = append(dss, file.NewDatasource(root))
@@ -263,6 +247,10 @@ func getDatasources(datasources []string) []datasource.Datasource {
 				root = "/media/config-2"
 			}
 			dss = append(dss, configdrive.NewDatasource(root))
+		case "openstack":
+			dss = append(dss, openstack.NewDatasource(root))
+		case "nocloud":
+			dss = append(dss, nocloud.NewDatasource(root))
 		case "digitalocean":
 			// TODO: should we enableDoLinkLocal() - to avoid the need for the other kernel/oem options?
 			dss = append(dss, digitalocean.NewDatasource(root))
@@ -320,21 +308,25 @@ func selectDatasource(sources []datasource.Datasource) datasource.Datasource {
 		go func(s datasource.Datasource) {
 			defer wg.Done()
 
+			start := time.Now()
 			duration := datasourceInterval
 			for {
 				log.Infof("cloud-init: Checking availability of %q", s.Type())
 				if s.IsAvailable() {
 					log.Infof("cloud-init: Datasource available: %s", s)
+					rancherConfig.RecordBootEvent(rancherConfig.BootEventDatasource, s.Type(), "available", start, nil)
 					ds <- s
 					return
 				}
 				if !s.AvailabilityChanges() {
 					log.Infof("cloud-init: Datasource unavailable, skipping: %s", s)
+					rancherConfig.RecordBootEvent(rancherConfig.BootEventDatasource, s.Type(), "unavailable", start, nil)
 					return
 				}
 				log.Errorf("cloud-init: Datasource not ready, will retry: %s", s)
 				select {
 				case <-stop:
+					rancherConfig.RecordBootEvent(rancherConfig.BootEventDatasource, s.Type(), "not ready, gave up", start, nil)
 					return
 				case <-time.After(duration):
 					duration = pkg.ExpBackoff(duration, datasourceMaxInterval)
@@ -352,10 +344,12 @@ func selectDatasource(sources []datasource.Datasource) datasource.Datasource {
 	var s datasource.Datasource
 	select {
 	case s = <-ds:
+		start := time.Now()
 		err := fetchAndSave(s)
 		if err != nil {
 			log.Errorf("Error fetching cloud-init datasource(%s): %s", s, err)
 		}
+		rancherConfig.RecordBootEvent(rancherConfig.BootEventDatasource, s.Type(), "fetched user-data and meta-data", start, err)
 	case <-done:
 	case <-time.After(datasourceTimeout):
 	}
@@ -0,0 +1,286 @@
+package cloudinitsave
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"os/exec"
+	"strings"
+
+	rancherConfig "github.com/rancher/os/config"
+	"github.com/rancher/os/config/cloudinit/config"
+	"github.com/rancher/os/config/cloudinit/pkg"
+	"github.com/rancher/os/pkg/log"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+)
+
+// includes can include more user-data, up to this depth
+const maxIncludeDepth = 5
+
+var fetchURL = func(url string) ([]byte, error) {
+	return pkg.NewHTTPClient().GetRetry(url)
+}
+
+// userData collects the parts of the user-data, in order
+type userData struct {
+	cloudConfigs [][]byte
+	scripts      [][]byte
+	bootHooks    [][]byte
+}
+
+// processUserData splits user-data into its cloud-config, with all the
+// cloud-config parts merged in order, and its script, which runs all the
+// script parts in sequence. Boothooks are run right away.
+func processUserData(data []byte) ([]byte, []byte, error) {
+	u := &userData{}
+	if err := u.add(data, "", 0); err != nil {
+		return nil, nil, err
+	}
+
+	for i, hook := range u.bootHooks {
+		log.Infof("Running boothook %d", i+1)
+		if err := runBootHook(hook); err != nil {
+			log.Errorf("Failed to run boothook %d: %v", i+1, err)
+		}
+	}
+
+	cloudConfig, err := u.cloudConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	return cloudConfig, u.script(), nil
+}
+
+func (u *userData) add(data []byte, contentType string, depth int) error {
+	data, err := decompressIfGzip(data)
+	if err != nil {
+		return err
+	}
+	content := string(data)
+
+	switch {
+	case strings.HasPrefix(contentType, "multipart/") || (contentType == "" && isMultipart(content)):
+		return u.addMultipart(data, depth)
+	case contentType == "text/cloud-config" || (contentType == "" && config.IsCloudConfig(content)):
+		if _, err := rancherConfig.ReadConfig(data, false); err != nil {
+			log.WithFields(log.Fields{"cloud-config": content, "err": err}).Warn("Failed to parse cloud-config, not saving.")
+			return nil
+		}
+		u.cloudConfigs = append(u.cloudConfigs, data)
+	case strings.HasPrefix(contentType, "text/x-shellscript") || (contentType == "" && config.IsScript(content)):
+		u.scripts = append(u.scripts, data)
+	case contentType == "text/cloud-boothook" || (contentType == "" && strings.HasPrefix(content, "#cloud-boothook")):
+		u.bootHooks = append(u.bootHooks, data)
+	case strings.HasPrefix(contentType, "text/x-include") || (contentType == "" && strings.HasPrefix(content, "#include")):
+		return u.addIncludes(content, depth)
+	case contentType == "" && isCompose(content):
+		cloudConfig, err := composeToCloudConfig(data)
+		if err != nil {
+			log.Errorf("Failed to convert compose to cloud-config syntax: %v", err)
+			return err
+		}
+		u.cloudConfigs = append(u.cloudConfigs, cloudConfig)
+	case contentType != "" && contentType != "text/plain":
+		log.Warnf("Ignoring user-data part of type %s", contentType)
+	case strings.TrimSpace(content) == "":
+	default:
+		log.Errorf("Unrecognized user-data\n(%s)", content)
+	}
+	return nil
+}
+
+// isMultipart is whether the user-data is a MIME message, which start with their headers
+func isMultipart(content string) bool {
+	header := strings.ToLower(strings.SplitN(content, "\n", 2)[0])
+	return strings.HasPrefix(header, "content-type:") || strings.HasPrefix(header, "mime-version:")
+}
+
+func (u *userData) addMultipart(data []byte, depth int) error {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("Failed to read multipart user-data: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("Failed to read multipart user-data: %v", err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		body, err := readBody(msg.Body, msg.Header.Get("Content-Transfer-Encoding"))
+		if err != nil {
+			return err
+		}
+		return u.add(body, mediaType, depth)
+	}
+
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for i := 1; ; i++ {
+		part, err := reader.NextPart()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("Failed to read part %d of the user-data: %v", i, err)
+		}
+
+		partType := "text/plain"
+		if header := part.Header.Get("Content-Type"); header != "" {
+			if partType, _, err = mime.ParseMediaType(header); err != nil {
+				log.Errorf("Ignoring part %d of the user-data: %v", i, err)
+				continue
+			}
+		}
+		body, err := readBody(part, part.Header.Get("Content-Transfer-Encoding"))
+		if err != nil {
+			log.Errorf("Ignoring part %d of the user-data: %v", i, err)
+			continue
+		}
+		log.Infof("User-data part %d: %s %s", i, partType, part.FileName())
+
+		if partType == "text/plain" || partType == "application/octet-stream" ||
+			partType == "application/x-gzip" || partType == "application/gzip" {
+			// detected from the content, once decompressed
+			partType = ""
+		}
+		if err := u.add(body, partType, depth); err != nil {
+			log.Errorf("Ignoring part %d of the user-data: %v", i, err)
+		}
+	}
+}
+
+func readBody(r io.Reader, encoding string) ([]byte, error) {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	// quoted-printable is decoded by the multipart reader already
+	if strings.ToLower(strings.TrimSpace(encoding)) == "base64" {
+		decoded := make([]byte, base64.StdEncoding.DecodedLen(len(body)))
+		n, err := base64.StdEncoding.Decode(decoded, bytes.Join(bytes.Fields(body), nil))
+		if err != nil {
+			return nil, err
+		}
+		return decoded[:n], nil
+	}
+	return body, nil
+}
+
+// addIncludes fetches the urls, one per line, and adds what they return
+func (u *userData) addIncludes(content string, depth int) error {
+	if depth >= maxIncludeDepth {
+		return fmt.Errorf("Not including more than %d levels of user-data", maxIncludeDepth)
+	}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		log.Infof("Including user-data from %s", line)
+		data, err := fetchURL(line)
+		if err != nil {
+			log.Errorf("Failed to include %s: %v", line, err)
+			continue
+		}
+		if err := u.add(data, "", depth+1); err != nil {
+			log.Errorf("Failed to include %s: %v", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// cloudConfig returns the cloud-config parts merged in order: maps are
+// merged and lists appended, so that each part can add runcmds or files
+func (u *userData) cloudConfig() ([]byte, error) {
+	switch len(u.cloudConfigs) {
+	case 0:
+		return []byte{}, nil
+	case 1:
+		return u.cloudConfigs[0], nil
+	}
+
+	merged := map[interface{}]interface{}{}
+	for _, cloudConfig := range u.cloudConfigs {
+		right := map[interface{}]interface{}{}
+		if err := yaml.Unmarshal(cloudConfig, &right); err != nil {
+			return nil, err
+		}
+		merged = mergeAppend(merged, right)
+	}
+	bytes, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("#cloud-config\n"), bytes...), nil
+}
+
+func mergeAppend(left, right map[interface{}]interface{}) map[interface{}]interface{} {
+	result := map[interface{}]interface{}{}
+	for k, v := range left {
+		result[k] = v
+	}
+	for k, r := range right {
+		switch l := result[k].(type) {
+		case map[interface{}]interface{}:
+			if r, ok := r.(map[interface{}]interface{}); ok {
+				result[k] = mergeAppend(l, r)
+				continue
+			}
+		case []interface{}:
+			if r, ok := r.([]interface{}); ok {
+				result[k] = append(append([]interface{}{}, l...), r...)
+				continue
+			}
+		}
+		result[k] = r
+	}
+	return result
+}
+
+// script returns the script to run, scripts of several parts are run in
+// order by a generated one, carrying on when one fails
+func (u *userData) script() []byte {
+	switch len(u.scripts) {
+	case 0:
+		return []byte{}
+	case 1:
+		return u.scripts[0]
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("#!/bin/sh\n")
+	buf.WriteString("# runs the scripts of the multipart user-data in order\n")
+	buf.WriteString("dir=$(mktemp -d)\n")
+	buf.WriteString("trap 'rm -rf \"$dir\"' EXIT\n")
+	for i, script := range u.scripts {
+		file := fmt.Sprintf("\"$dir/part-%03d\"", i+1)
+		eof := fmt.Sprintf("RANCHEROS_USER_DATA_PART_%d", i+1)
+		for bytes.Contains(script, []byte(eof)) {
+			eof += "_"
+		}
+		fmt.Fprintf(&buf, "\ncat > %s <<'%s'\n", file, eof)
+		buf.Write(script)
+		if !bytes.HasSuffix(script, []byte("\n")) {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(&buf, "%s\n", eof)
+		fmt.Fprintf(&buf, "chmod 700 %s\n", file)
+		fmt.Fprintf(&buf, "%s || echo \"user-data script %d failed with $?\" >&2\n", file, i+1)
+	}
+	return buf.Bytes()
+}
+
+func runBootHook(hook []byte) error {
+	// the #cloud-boothook header is a comment to sh
+	cmd := exec.Command("sh", "-c", string(hook))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,116 @@
+package cloudinitsave
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+	"github.com/stretchr/testify/require"
+)
+
+const multipartUserData = `Content-Type: multipart/mixed; boundary="MIMEBOUNDARY"
+MIME-Version: 1.0
+
+--MIMEBOUNDARY
+Content-Transfer-Encoding: 7bit
+Content-Type: text/cloud-config
+Mime-Version: 1.0
+
+#cloud-config
+hostname: first
+runcmd:
+- echo first
+rancher:
+  environment:
+    A: a
+
+--MIMEBOUNDARY
+Content-Transfer-Encoding: 7bit
+Content-Type: text/x-shellscript
+Mime-Version: 1.0
+
+#!/bin/sh
+echo one
+
+--MIMEBOUNDARY
+Content-Transfer-Encoding: base64
+Content-Type: text/cloud-config
+Mime-Version: 1.0
+
+%s
+--MIMEBOUNDARY
+Content-Type: text/x-include-url
+
+#include
+http://example.com/script.sh
+
+--MIMEBOUNDARY--
+`
+
+func TestProcessMultipartUserData(t *testing.T) {
+	assert := require.New(t)
+
+	defer func(f func(string) ([]byte, error)) { fetchURL = f }(fetchURL)
+	fetchURL = func(url string) ([]byte, error) {
+		assert.Equal("http://example.com/script.sh", url)
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write([]byte("#!/bin/bash\necho two\n"))
+		w.Close()
+		return buf.Bytes(), nil
+	}
+
+	second := base64.StdEncoding.EncodeToString([]byte("#cloud-config\nhostname: second\nruncmd:\n- echo second\nrancher:\n  environment:\n    B: b\n"))
+	cloudConfig, script, err := processUserData([]byte(fmt.Sprintf(multipartUserData, second)))
+	assert.NoError(err)
+
+	assert.True(bytes.HasPrefix(cloudConfig, []byte("#cloud-config\n")))
+	merged := map[string]interface{}{}
+	assert.NoError(yaml.Unmarshal(cloudConfig, &merged))
+	assert.Equal("second", merged["hostname"])
+	assert.Equal([]interface{}{"echo first", "echo second"}, merged["runcmd"])
+	assert.Equal(map[interface{}]interface{}{
+		"environment": map[interface{}]interface{}{"A": "a", "B": "b"},
+	}, merged["rancher"])
+
+	assert.True(bytes.HasPrefix(script, []byte("#!/bin/sh\n")))
+	assert.Contains(string(script), "cat > \"$dir/part-001\" <<'RANCHEROS_USER_DATA_PART_1'\n#!/bin/sh\necho one\nRANCHEROS_USER_DATA_PART_1\n")
+	assert.Contains(string(script), "cat > \"$dir/part-002\" <<'RANCHEROS_USER_DATA_PART_2'\n#!/bin/bash\necho two\nRANCHEROS_USER_DATA_PART_2\n")
+}
+
+func TestProcessSingleUserData(t *testing.T) {
+	assert := require.New(t)
+
+	userData := []byte("#cloud-config\n# kept as is\nhostname: test\n")
+	cloudConfig, script, err := processUserData(userData)
+	assert.NoError(err)
+	assert.Equal(userData, cloudConfig)
+	assert.Empty(script)
+
+	userData = []byte("#!/bin/sh\necho hi\n")
+	cloudConfig, script, err = processUserData(userData)
+	assert.NoError(err)
+	assert.Empty(cloudConfig)
+	assert.Equal(userData, script)
+
+	cloudConfig, _, err = processUserData([]byte("#compose\nnginx:\n  image: nginx\n"))
+	assert.NoError(err)
+	assert.Contains(string(cloudConfig), "image: nginx")
+}
+
+func TestIncludeDepth(t *testing.T) {
+	assert := require.New(t)
+
+	defer func(f func(string) ([]byte, error)) { fetchURL = f }(fetchURL)
+	fetched := 0
+	fetchURL = func(url string) ([]byte, error) {
+		fetched++
+		return []byte("#include\n" + url), nil
+	}
+	_, _, err := processUserData([]byte("#include\nhttp://example.com/loop"))
+	assert.NoError(err)
+	assert.Equal(maxIncludeDepth, fetched)
+}
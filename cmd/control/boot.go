@@ -0,0 +1,283 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/pkg/log"
+
+	"github.com/codegangsta/cli"
+)
+
+const (
+	bootKernel       = "kernel"
+	bootInit         = "init"
+	bootHook         = "hook"
+	bootSystemDocker = "system-docker"
+
+	// events that end this close to the start of another are taken to be
+	// what it was waiting for
+	criticalChainSlack = 100 * time.Millisecond
+)
+
+type bootEntry struct {
+	Kind     string        `json:"kind"`
+	Name     string        `json:"name"`
+	Detail   string        `json:"detail,omitempty"`
+	Offset   time.Duration `json:"offset"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	start    time.Time
+	end      time.Time
+}
+
+type bootTimeline struct {
+	Version       string        `json:"version,omitempty"`
+	Kernel        time.Duration `json:"kernel"`
+	Init          time.Duration `json:"init"`
+	SystemDocker  time.Duration `json:"system_docker"`
+	Services      time.Duration `json:"services"`
+	Total         time.Duration `json:"total"`
+	Entries       []bootEntry   `json:"entries"`
+	CriticalChain []bootEntry   `json:"critical_chain"`
+}
+
+func bootSubcommands() []cli.Command {
+	formatFlag := cli.StringFlag{
+		Name:  "format",
+		Usage: "table or json",
+		Value: "table",
+	}
+	return []cli.Command{
+		{
+			Name:   "report",
+			Usage:  "show the timeline and critical chain of the last boot",
+			Action: bootReport,
+			Flags:  []cli.Flag{formatFlag},
+		},
+		{
+			Name:   "blame",
+			Usage:  "list init stages, services and datasources by how long they took",
+			Action: bootBlame,
+			Flags:  []cli.Flag{formatFlag},
+		},
+	}
+}
+
+func loadBootTimeline() (*bootTimeline, error) {
+	report, err := config.ReadInitReport(config.InitReportFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	events, err := config.ReadBootEvents(config.BootEventsFile, config.BootID())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if report == nil && len(events) == 0 {
+		return nil, fmt.Errorf("no boot timing recorded in %s or %s", config.InitReportFile, config.BootEventsFile)
+	}
+	return buildBootTimeline(report, events), nil
+}
+
+func buildBootTimeline(report *config.InitReport, events []config.BootEvent) *bootTimeline {
+	timeline := &bootTimeline{}
+	entries := []bootEntry{}
+	add := func(kind, name, detail string, start, end time.Time, err string) {
+		entries = append(entries, bootEntry{
+			Kind:     kind,
+			Name:     name,
+			Detail:   detail,
+			Duration: end.Sub(start),
+			Error:    err,
+			start:    start,
+			end:      end,
+		})
+	}
+
+	var firstService time.Time
+	for _, event := range events {
+		add(event.Kind, event.Name, event.Detail, event.Start, event.End, event.Error)
+		if event.Kind == config.BootEventService && (firstService.IsZero() || event.Start.Before(firstService)) {
+			firstService = event.Start
+		}
+	}
+
+	if report != nil {
+		timeline.Version = report.Version
+		if report.Uptime > 0 {
+			add(bootKernel, "kernel", "", report.Start.Add(-report.Uptime), report.Start, "")
+			timeline.Kernel = report.Uptime
+		}
+		for _, stage := range report.Stages {
+			if stage.Hook != "" {
+				add(bootHook, stage.Name, stage.Hook, stage.Start, stage.End, stage.Error)
+			} else {
+				add(bootInit, stage.Name, "", stage.Start, stage.End, stage.Error)
+			}
+		}
+		timeline.Init = report.End.Sub(report.Start)
+		// there is no record of when System Docker is ready, the first
+		// system service it starts is the closest to it
+		if !firstService.IsZero() && firstService.After(report.End) {
+			add(bootSystemDocker, "system-docker", "until the first service started", report.End, firstService, "")
+			timeline.SystemDocker = firstService.Sub(report.End)
+		}
+	}
+
+	if len(entries) == 0 {
+		timeline.Entries = entries
+		timeline.CriticalChain = entries
+		return timeline
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].start.Before(entries[j].start)
+	})
+	origin := entries[0].start
+	last := entries[0].end
+	for i := range entries {
+		entries[i].Offset = entries[i].start.Sub(origin)
+		if entries[i].end.After(last) {
+			last = entries[i].end
+		}
+	}
+	timeline.Total = last.Sub(origin)
+	timeline.Services = timeline.Total - timeline.Kernel - timeline.Init - timeline.SystemDocker
+	if timeline.Services < 0 {
+		timeline.Services = 0
+	}
+	timeline.Entries = entries
+	timeline.CriticalChain = criticalChain(entries)
+	return timeline
+}
+
+// criticalChain walks back from whatever finished last, each time to the
+// entry that finished last before it started, like systemd-analyze
+// critical-chain. Entries are expected sorted by start.
+func criticalChain(entries []bootEntry) []bootEntry {
+	if len(entries) == 0 {
+		return []bootEntry{}
+	}
+
+	current := 0
+	for i, entry := range entries {
+		if entry.end.After(entries[current].end) {
+			current = i
+		}
+	}
+
+	chain := []bootEntry{entries[current]}
+	for {
+		next := -1
+		for i, entry := range entries {
+			if i == current || entry.end.After(entries[current].start.Add(criticalChainSlack)) {
+				continue
+			}
+			if !entry.start.Before(entries[current].start) {
+				continue
+			}
+			if next < 0 || entry.end.After(entries[next].end) {
+				next = i
+			}
+		}
+		if next < 0 {
+			break
+		}
+		current = next
+		chain = append(chain, entries[current])
+	}
+
+	// in boot order
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
+
+func bootFormat(c *cli.Context) string {
+	format := c.String("format")
+	if format != "table" && format != "json" {
+		log.Fatalf("Unknown format %q, expected table or json", format)
+	}
+	return format
+}
+
+func bootReport(c *cli.Context) error {
+	format := bootFormat(c)
+	timeline, err := loadBootTimeline()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if format == "json" {
+		return printBootJSON(timeline)
+	}
+	printBootSummary(os.Stdout, timeline)
+	fmt.Println()
+	printBootEntries(os.Stdout, timeline.Entries)
+	fmt.Println()
+	fmt.Println("Critical chain:")
+	printBootEntries(os.Stdout, timeline.CriticalChain)
+	return nil
+}
+
+func bootBlame(c *cli.Context) error {
+	format := bootFormat(c)
+	timeline, err := loadBootTimeline()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	entries := append([]bootEntry{}, timeline.Entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Duration > entries[j].Duration
+	})
+
+	if format == "json" {
+		return printBootJSON(entries)
+	}
+	printBootEntries(os.Stdout, entries)
+	return nil
+}
+
+func printBootJSON(v interface{}) error {
+	bytes, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(bytes))
+	return nil
+}
+
+func printBootSummary(out io.Writer, timeline *bootTimeline) {
+	if timeline.Version != "" {
+		fmt.Fprintf(out, "RancherOS %s\n", timeline.Version)
+	}
+	fmt.Fprintf(out, "Startup finished in %s (kernel) + %s (init) + %s (system-docker) + %s (services) = %s\n",
+		bootSeconds(timeline.Kernel), bootSeconds(timeline.Init), bootSeconds(timeline.SystemDocker),
+		bootSeconds(timeline.Services), bootSeconds(timeline.Total))
+}
+
+func printBootEntries(out io.Writer, entries []bootEntry) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "OFFSET\tDURATION\tKIND\tNAME\tDETAIL")
+	for _, entry := range entries {
+		detail := entry.Detail
+		if entry.Error != "" {
+			detail = strings.TrimSpace(fmt.Sprintf("%s (error: %s)", detail, entry.Error))
+		}
+		fmt.Fprintf(w, "+%s\t%s\t%s\t%s\t%s\n", bootSeconds(entry.Offset), bootSeconds(entry.Duration), entry.Kind, entry.Name, detail)
+	}
+	w.Flush()
+}
+
+func bootSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3fs", d.Seconds())
+}
@@ -0,0 +1,85 @@
+package control
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rancher/os/config"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildBootTimeline(t *testing.T) {
+	assert := require.New(t)
+
+	start := time.Date(2018, 1, 1, 0, 0, 10, 0, time.UTC)
+	at := func(seconds float64) time.Time {
+		return start.Add(time.Duration(seconds * float64(time.Second)))
+	}
+
+	report := &config.InitReport{
+		Version: "v1.5.0",
+		Uptime:  2 * time.Second,
+		Start:   start,
+		End:     at(4),
+		Stages: []config.InitStageRecord{
+			{Name: "preparefs", Start: at(0), End: at(1)},
+			{Name: "cloud-init", Start: at(1), End: at(3)},
+			{Name: "/usr/share/ros/oem/hook.sh", Hook: "after_cloud_init", Start: at(3), End: at(3.5)},
+			{Name: "sysinit", Start: at(3.5), End: at(4)},
+		},
+	}
+	events := []config.BootEvent{
+		{Kind: config.BootEventDatasource, Name: "ec2", Start: at(1.1), End: at(2.5)},
+		{Kind: config.BootEventService, Name: "syslog", Start: at(5), End: at(6)},
+		{Kind: config.BootEventService, Name: "network", Start: at(6), End: at(8)},
+		{Kind: config.BootEventService, Name: "ntp", Start: at(5.5), End: at(6.5)},
+		{Kind: config.BootEventService, Name: "console", Start: at(8), End: at(20), Detail: "waited 10.0s for network"},
+	}
+
+	timeline := buildBootTimeline(report, events)
+	assert.Equal(2*time.Second, timeline.Kernel)
+	assert.Equal(4*time.Second, timeline.Init)
+	assert.Equal(time.Second, timeline.SystemDocker)
+	assert.Equal(22*time.Second, timeline.Total)
+	assert.Equal(15*time.Second, timeline.Services)
+
+	assert.Equal(bootKernel, timeline.Entries[0].Kind)
+	assert.Equal(time.Duration(0), timeline.Entries[0].Offset)
+	assert.Equal(bootHook, timeline.Entries[4].Kind)
+	assert.Equal(bootSystemDocker, timeline.Entries[6].Kind)
+	assert.Equal(6*time.Second, timeline.Entries[6].Offset)
+
+	names := []string{}
+	for _, entry := range timeline.CriticalChain {
+		names = append(names, entry.Name)
+	}
+	assert.Equal([]string{
+		"kernel",
+		"preparefs",
+		"cloud-init",
+		"/usr/share/ros/oem/hook.sh",
+		"sysinit",
+		"system-docker",
+		"syslog",
+		"network",
+		"console",
+	}, names)
+}
+
+func TestBuildBootTimelineWithoutReport(t *testing.T) {
+	assert := require.New(t)
+
+	start := time.Date(2018, 1, 1, 0, 0, 10, 0, time.UTC)
+	timeline := buildBootTimeline(nil, []config.BootEvent{
+		{Kind: config.BootEventService, Name: "console", Start: start.Add(time.Second), End: start.Add(3 * time.Second)},
+		{Kind: config.BootEventService, Name: "syslog", Start: start, End: start.Add(time.Second)},
+	})
+	assert.Equal(3*time.Second, timeline.Total)
+	assert.Equal("syslog", timeline.Entries[0].Name)
+	assert.Len(timeline.CriticalChain, 2)
+
+	timeline = buildBootTimeline(nil, nil)
+	assert.Empty(timeline.Entries)
+	assert.Empty(timeline.CriticalChain)
+}
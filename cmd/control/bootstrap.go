@@ -1,6 +1,7 @@
 package control
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -10,7 +11,9 @@ import (
 	"time"
 
 	"github.com/rancher/os/config"
+	"github.com/rancher/os/config/cloudinit/pkg"
 	"github.com/rancher/os/pkg/log"
+	"github.com/rancher/os/pkg/netconf"
 	"github.com/rancher/os/pkg/util"
 )
 
@@ -39,9 +42,9 @@ func BootstrapMain() {
 		}
 	}
 
-	log.Debugf("bootstrapAction: cryptsetup(%v)", cfg.Rancher.State.Cryptsetup)
-	if cfg.Rancher.State.Cryptsetup {
-		if err := cryptsetup(); err != nil {
+	log.Debugf("bootstrapAction: cryptsetup(%v, %+v)", cfg.Rancher.State.Cryptsetup, cfg.Rancher.State.Encryption)
+	if cfg.Rancher.State.Cryptsetup || cfg.Rancher.State.Encryption != (config.StateEncryption{}) {
+		if err := cryptsetup(cfg); err != nil {
 			log.Errorf("Failed to run cryptsetup: %v", err)
 		}
 	}
@@ -98,31 +101,38 @@ func vgchange() error {
 	return cmd.Run()
 }
 
-func cryptsetup() error {
+func cryptsetup(cfg *config.CloudConfig) error {
 	devices, err := util.BlkidType("crypto_LUKS")
 	if err != nil {
 		return err
 	}
 
-	for _, cryptdevice := range devices {
-		fdRead, err := os.Open("/dev/console")
-		if err != nil {
-			return err
+	encryption := cfg.Rancher.State.Encryption
+	if encryption.KeyURL != "" && len(devices) > 0 {
+		// the bootstrap runs before the network is configured
+		if _, err := netconf.ApplyNetworkConfigs(&cfg.Rancher.Network, false, false); err != nil {
+			log.Errorf("Failed to apply the network config to fetch the state key: %v", err)
 		}
-		defer fdRead.Close()
+	}
+	keys := stateKeys(encryption, config.OemDir, config.KeyringKeyFile)
 
-		fdWrite, err := os.OpenFile("/dev/console", os.O_WRONLY|os.O_APPEND, 0)
-		if err != nil {
-			return err
+	for _, cryptdevice := range devices {
+		name := fmt.Sprintf("luks-%s", filepath.Base(cryptdevice))
+		if _, err := os.Stat(filepath.Join("/dev/mapper", name)); err == nil {
+			log.Infof("%s is already unlocked", cryptdevice)
+			continue
 		}
-		defer fdWrite.Close()
 
-		cmd := exec.Command("cryptsetup", "luksOpen", cryptdevice, fmt.Sprintf("luks-%s", filepath.Base(cryptdevice)))
-		cmd.Stdout = fdWrite
-		cmd.Stderr = fdWrite
-		cmd.Stdin = fdRead
+		if err := luksOpenWithKeys(cryptdevice, name, keys); err == nil {
+			continue
+		} else if len(keys) > 0 {
+			log.Errorf("Failed to unlock %s unattended: %v", cryptdevice, err)
+		}
 
-		if err := cmd.Run(); err != nil {
+		if !cfg.Rancher.State.Cryptsetup {
+			continue
+		}
+		if err := luksOpenInteractive(cryptdevice, name); err != nil {
 			log.Errorf("Failed to run cryptsetup for %s: %v", cryptdevice, err)
 		}
 	}
@@ -130,6 +140,95 @@ func cryptsetup() error {
 	return nil
 }
 
+// stateKey is a source of the key of the state device
+type stateKey struct {
+	source string
+	read   func() ([]byte, error)
+}
+
+// stateKeys returns the sources of the state key. The keyring key is read
+// from keyringKeyFile, where init put it, as this container has its own
+// session keyring.
+func stateKeys(encryption config.StateEncryption, oemDir, keyringKeyFile string) []stateKey {
+	keys := []stateKey{}
+	if keyfile := encryption.Keyfile; keyfile != "" {
+		if !filepath.IsAbs(keyfile) {
+			keyfile = filepath.Join(oemDir, keyfile)
+		}
+		keys = append(keys, stateKey{
+			source: "keyfile " + keyfile,
+			read: func() ([]byte, error) {
+				return ioutil.ReadFile(keyfile)
+			},
+		})
+	}
+	if keyURL := encryption.KeyURL; keyURL != "" {
+		keys = append(keys, stateKey{
+			source: "url " + keyURL,
+			read: func() ([]byte, error) {
+				return pkg.NewHTTPClient().GetRetry(keyURL)
+			},
+		})
+	}
+	if description := encryption.KeyringKey; description != "" {
+		keys = append(keys, stateKey{
+			source: "keyring key " + description,
+			read: func() ([]byte, error) {
+				return ioutil.ReadFile(keyringKeyFile)
+			},
+		})
+	}
+	return keys
+}
+
+func luksOpenWithKeys(cryptdevice, name string, keys []stateKey) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("no keys")
+	}
+	for _, key := range keys {
+		data, err := key.read()
+		if err == nil && len(data) == 0 {
+			err = fmt.Errorf("empty key")
+		}
+		if err != nil {
+			log.Errorf("Failed to read the key of %s from %s: %v", cryptdevice, key.source, err)
+			continue
+		}
+
+		cmd := exec.Command("cryptsetup", "luksOpen", "--key-file", "-", cryptdevice, name)
+		cmd.Stdin = bytes.NewReader(data)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			log.Errorf("Failed to unlock %s with the key from %s: %v", cryptdevice, key.source, err)
+			continue
+		}
+		log.Infof("Unlocked %s with the key from %s", cryptdevice, key.source)
+		return nil
+	}
+	return fmt.Errorf("none of the keys unlocked it")
+}
+
+func luksOpenInteractive(cryptdevice, name string) error {
+	fdRead, err := os.Open("/dev/console")
+	if err != nil {
+		return err
+	}
+	defer fdRead.Close()
+
+	fdWrite, err := os.OpenFile("/dev/console", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	defer fdWrite.Close()
+
+	cmd := exec.Command("cryptsetup", "luksOpen", cryptdevice, name)
+	cmd.Stdout = fdWrite
+	cmd.Stderr = fdWrite
+	cmd.Stdin = fdRead
+	return cmd.Run()
+}
+
 func runRngd() error {
 	// use /dev/urandom as random number input for rngd
 	// this is a really bad idea
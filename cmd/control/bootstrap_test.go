@@ -0,0 +1,62 @@
+package control
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/os/config"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateKeys(t *testing.T) {
+	assert := require.New(t)
+
+	oemDir, err := ioutil.TempDir("", "oem")
+	assert.NoError(err)
+	defer os.RemoveAll(oemDir)
+	assert.NoError(ioutil.WriteFile(filepath.Join(oemDir, "state.key"), []byte("oem-key"), 0600))
+	keyringKeyFile := filepath.Join(oemDir, "state-keyring.key")
+	assert.NoError(ioutil.WriteFile(keyringKeyFile, []byte("keyring-key"), 0400))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/keys/node1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("url-key"))
+	}))
+	defer server.Close()
+
+	keys := stateKeys(config.StateEncryption{
+		Keyfile:    "state.key",
+		KeyURL:     server.URL + "/keys/node1",
+		KeyringKey: "rancher:state",
+	}, oemDir, keyringKeyFile)
+	assert.Len(keys, 3)
+
+	assert.Equal("keyfile "+filepath.Join(oemDir, "state.key"), keys[0].source)
+	key, err := keys[0].read()
+	assert.NoError(err)
+	assert.Equal("oem-key", string(key))
+
+	key, err = keys[1].read()
+	assert.NoError(err)
+	assert.Equal("url-key", string(key))
+
+	assert.Equal("keyring key rancher:state", keys[2].source)
+	key, err = keys[2].read()
+	assert.NoError(err)
+	assert.Equal("keyring-key", string(key))
+
+	keys = stateKeys(config.StateEncryption{Keyfile: "/etc/state.key"}, oemDir, keyringKeyFile)
+	assert.Len(keys, 1)
+	assert.Equal("keyfile /etc/state.key", keys[0].source)
+
+	assert.Empty(stateKeys(config.StateEncryption{}, oemDir, keyringKeyFile))
+	assert.Error(luksOpenWithKeys("/dev/sdb2", "luks-sdb2", nil))
+}
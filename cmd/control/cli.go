@@ -33,6 +33,12 @@ func Main() {
 	}
 
 	app.Commands = []cli.Command{
+		{
+			Name:        "boot",
+			Usage:       "show where the time during boot went",
+			HideHelp:    true,
+			Subcommands: bootSubcommands(),
+		},
 		{
 			Name:        "config",
 			ShortName:   "c",
@@ -87,6 +93,18 @@ func Main() {
 			SkipFlagParsing: true,
 			Action:          envAction,
 		},
+		{
+			Name:        "mount",
+			Usage:       "show the configured mounts",
+			HideHelp:    true,
+			Subcommands: mountSubcommands(),
+		},
+		{
+			Name:        "network",
+			Usage:       "apply the network config",
+			HideHelp:    true,
+			Subcommands: networkSubcommands(),
+		},
 		service.Commands(),
 		{
 			Name:        "os",
@@ -108,6 +126,23 @@ func Main() {
 			SkipFlagParsing: true,
 			Action:          recoveryInitAction,
 		},
+		{
+			Name:        "respawn",
+			Usage:       "show the processes run by respawn",
+			HideHelp:    true,
+			Subcommands: respawnSubcommands(),
+		},
+		{
+			Name:   "support-bundle",
+			Usage:  "collect the boot logs, config and system state into a tarball, without the private keys and registry credentials",
+			Action: supportBundleAction,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "output, o",
+					Usage: "File to write the tarball to, by default in /var/lib/rancher",
+				},
+			},
+		},
 		{
 			Name:            "switch-console",
 			Hidden:          true,
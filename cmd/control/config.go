@@ -3,16 +3,19 @@ package control
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 
 	"github.com/rancher/os/config"
+	"github.com/rancher/os/config/secret"
 	"github.com/rancher/os/pkg/log"
 	"github.com/rancher/os/pkg/util"
 
@@ -33,6 +36,51 @@ func configSubcommands() []cli.Command {
 			Usage:  "set a value",
 			Action: configSet,
 		},
+		{
+			Name:      "explain",
+			Usage:     "show the value of a key in each config layer, and which layer won",
+			ArgsUsage: "<key>",
+			Action:    configExplain,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "private, p",
+					Usage: "Include the generated private keys",
+				},
+			},
+		},
+		{
+			Name:   "history",
+			Usage:  "list the snapshots of cloud-config.yml, with the changes of each",
+			Action: configHistory,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "private, p",
+					Usage: "Include the generated private keys in the changes",
+				},
+			},
+		},
+		{
+			Name:      "rollback",
+			Usage:     "restore a snapshot of cloud-config.yml listed by history",
+			ArgsUsage: "<n>",
+			Action:    configRollback,
+		},
+		{
+			Name:      "encrypt",
+			Usage:     "encrypt a value from the args or stdin to an ENC[...] value for the config",
+			ArgsUsage: "[value]",
+			Action:    configEncrypt,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "public-key, k",
+					Usage: "Encrypt to the base64 public key in this file instead of the host key",
+				},
+				cli.BoolFlag{
+					Name:  "print-public-key",
+					Usage: "Print the public key of the host key, to encrypt values elsewhere",
+				},
+			},
+		},
 		{
 			Name:   "images",
 			Usage:  "List Docker images for a configuration from a file",
@@ -94,6 +142,10 @@ func configSubcommands() []cli.Command {
 					Name:  "input, i",
 					Usage: "File from which to read",
 				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "Print the validation errors as json, exiting 1 when the config is invalid",
+				},
 			},
 		},
 	}
@@ -233,6 +285,142 @@ func configGet(c *cli.Context) error {
 	return nil
 }
 
+func configExplain(c *cli.Context) error {
+	key := c.Args().Get(0)
+	if key == "" {
+		return nil
+	}
+
+	explanation, err := config.Explain(key, c.Bool("private"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(explanation.Layers) == 0 {
+		fmt.Printf("%s is not set by any layer\n", key)
+		return nil
+	}
+
+	for _, layer := range explanation.Layers {
+		marker := " "
+		if layer.Layer == explanation.Winner {
+			marker = "*"
+		}
+		fmt.Printf("%s %s:\n%s", marker, layer.Layer, formatExplainValue(layer.Value))
+	}
+
+	if explanation.Winner == "" {
+		fmt.Printf("\n%s is merged from the layers above:\n%s", key, formatExplainValue(explanation.Value))
+	} else {
+		fmt.Printf("\n%s is set by %s\n", key, explanation.Winner)
+	}
+
+	return nil
+}
+
+func formatExplainValue(value interface{}) string {
+	text := fmt.Sprintln(value)
+	switch value.(type) {
+	case []interface{}, map[interface{}]interface{}:
+		if bytes, err := yaml.Marshal(value); err == nil {
+			text = string(bytes)
+		}
+	}
+
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = "    " + line
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func configHistory(c *cli.Context) error {
+	snapshots, err := config.Snapshots("")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for i, snapshot := range snapshots {
+		good := ""
+		if snapshot.Good {
+			good = " (booted cleanly)"
+		}
+		fmt.Printf("%d  %s%s\n", i, snapshot.Time.Local().Format("2006-01-02 15:04:05"), good)
+
+		older := ""
+		if i+1 < len(snapshots) {
+			older = snapshots[i+1].Name
+		}
+		diff, err := config.SnapshotDiff("", older, snapshot.Name, c.Bool("private"))
+		if err != nil {
+			log.Errorf("Failed to diff %s: %v", snapshot.Name, err)
+			continue
+		}
+		fmt.Println(diff)
+	}
+
+	return nil
+}
+
+func configRollback(c *cli.Context) error {
+	n, err := strconv.Atoi(c.Args().Get(0))
+	if err != nil {
+		log.Fatalf("Invalid snapshot number %q, see ros config history", c.Args().Get(0))
+	}
+
+	if err := config.Rollback(n); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Restored snapshot %d, reboot to apply it\n", n)
+
+	return nil
+}
+
+func configEncrypt(c *cli.Context) error {
+	if c.Bool("print-public-key") {
+		key, err := config.HostKey(true)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(string(secret.MarshalPublicKey(&key.PublicKey)))
+		return nil
+	}
+
+	var value []byte
+	if c.NArg() > 0 {
+		value = []byte(c.Args().Get(0))
+	} else {
+		input, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		value = bytes.TrimRight(input, "\n")
+	}
+
+	encrypt := config.Encrypt
+	if publicKeyFile := c.String("public-key"); publicKeyFile != "" {
+		data, err := ioutil.ReadFile(publicKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pub, err := secret.ParsePublicKey(data)
+		if err != nil {
+			log.Fatalf("Failed to parse %s: %v", publicKeyFile, err)
+		}
+		encrypt = func(value []byte) (string, error) {
+			return secret.Encrypt(pub, value)
+		}
+	}
+
+	encrypted, err := encrypt(value)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(encrypted)
+
+	return nil
+}
+
 func merge(c *cli.Context) error {
 	bytes, err := inputBytes(c)
 	if err != nil {
@@ -282,12 +470,39 @@ func validate(c *cli.Context) error {
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	result := validationResult{Valid: validationErrors.Valid(), Errors: []string{}}
 	for _, validationError := range validationErrors.Errors() {
-		log.Error(validationError)
+		result.Errors = append(result.Errors, validationError.String())
+	}
+	if result.Valid {
+		result.Errors = config.ValidateMounts(bytes)
+		result.Valid = len(result.Errors) == 0
+	}
+	if !c.Bool("json") {
+		for _, validationError := range result.Errors {
+			log.Error(validationError)
+		}
+		return nil
+	}
+
+	// Only --json exits 1 on an invalid config, the plain output keeps exiting
+	// 0 for the scripts that already call it
+	if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
+		log.Fatal(err)
+	}
+	if !result.Valid {
+		os.Exit(1)
 	}
 	return nil
 }
 
+// validationResult is the output of ros config validate --json
+type validationResult struct {
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors"`
+}
+
 func inputBytes(c *cli.Context) ([]byte, error) {
 	input := os.Stdin
 	inputFile := c.String("input")
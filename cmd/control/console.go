@@ -164,7 +164,7 @@ func availableConsoles(cfg *config.CloudConfig, update bool) []string {
 		}
 
 	}
-	consoles, err := network.GetConsoles(cfg.Rancher.Repositories.ToArray())
+	consoles, err := network.GetConsoles(cfg.Rancher.Repositories)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -13,10 +13,14 @@ import (
 	"text/template"
 
 	"github.com/rancher/os/cmd/cloudinitexecute"
+	"github.com/rancher/os/cmd/control/install"
+	"github.com/rancher/os/cmd/respawn"
 	"github.com/rancher/os/config"
 	"github.com/rancher/os/config/cmdline"
 	"github.com/rancher/os/pkg/compose"
+	"github.com/rancher/os/pkg/dfs"
 	"github.com/rancher/os/pkg/log"
+	"github.com/rancher/os/pkg/timesync"
 	"github.com/rancher/os/pkg/util"
 
 	"github.com/codegangsta/cli"
@@ -91,6 +95,9 @@ func consoleInitFunc() error {
 	}
 
 	password := cmdline.GetCmdline("rancher.password")
+	if s, ok := password.(string); ok {
+		password = config.DecryptString(s)
+	}
 	if !ignorePassword && password != "" {
 		cmd := exec.Command("chpasswd")
 		cmd.Stdin = strings.NewReader(fmt.Sprint("rancher:", password))
@@ -164,11 +171,18 @@ func consoleInitFunc() error {
 		}
 	}
 
-	// mount systemd cgroups
-	if err := os.MkdirAll("/sys/fs/cgroup/systemd", 0555); err != nil {
-		log.Error(err)
+	// mount systemd cgroups, which are part of the cgroup2 hierarchy in
+	// the unified mode
+	if !dfs.UnifiedCgroup() {
+		if err := os.MkdirAll("/sys/fs/cgroup/systemd", 0555); err != nil {
+			log.Error(err)
+		}
+		if err := unix.Mount("cgroup", "/sys/fs/cgroup/systemd", "cgroup", 0, "none,name=systemd"); err != nil {
+			log.Error(err)
+		}
 	}
-	if err := unix.Mount("cgroup", "/sys/fs/cgroup/systemd", "cgroup", 0, "none,name=systemd"); err != nil {
+
+	if err := timesync.SetTimezone(cfg.Rancher.Time.Timezone); err != nil {
 		log.Error(err)
 	}
 
@@ -229,6 +243,18 @@ func consoleInitFunc() error {
 		log.Error(err)
 	}
 
+	// system-docker and the console are up, so an upgrade on trial and the
+	// config it booted with are good to keep
+	if err := install.MarkBootGood("/proc/1/root"+config.BootDir, "rancheros-"+config.Version); err != nil {
+		log.Errorf("Failed to mark this boot as good: %v", err)
+	}
+	if err := config.MarkConfigGood(""); err != nil {
+		log.Errorf("Failed to mark the config as good: %v", err)
+	}
+	if err := config.MarkBooted(config.BootingFile); err != nil {
+		log.Errorf("Failed to remove %s: %v", config.BootingFile, err)
+	}
+
 	if err := util.RunScript("/etc/rc.local"); err != nil {
 		log.Error(err)
 	}
@@ -240,7 +266,7 @@ func consoleInitFunc() error {
 		return err
 	}
 
-	return syscall.Exec(respawnBinPath, []string{"respawn", "-f", "/etc/respawn.conf"}, os.Environ())
+	return syscall.Exec(respawnBinPath, []string{"respawn", "-f", "/etc/respawn.conf", "-d", "/etc/respawn.conf.d"}, os.Environ())
 }
 
 func generateRespawnConf(cmdline, user string, sshd, recovery bool) string {
@@ -303,24 +329,28 @@ func writeRespawn(user string, sshd, recovery bool) error {
 		return err
 	}
 
-	respawn := generateRespawnConf(string(cmdline), user, sshd, recovery)
+	respawnConf := generateRespawnConf(string(cmdline), user, sshd, recovery)
 
 	files, err := ioutil.ReadDir("/etc/respawn.conf.d")
 	if err == nil {
 		for _, f := range files {
+			if respawn.IsYAMLConfig(f.Name()) {
+				// loaded by respawn itself
+				continue
+			}
 			p := path.Join("/etc/respawn.conf.d", f.Name())
 			content, err := ioutil.ReadFile(p)
 			if err != nil {
 				log.Errorf("Failed to read %s: %v", p, err)
 				continue
 			}
-			respawn += fmt.Sprintf("\n%s", string(content))
+			respawnConf += fmt.Sprintf("\n%s", string(content))
 		}
 	} else if !os.IsNotExist(err) {
 		log.Error(err)
 	}
 
-	return ioutil.WriteFile("/etc/respawn.conf", []byte(respawn), 0644)
+	return ioutil.WriteFile("/etc/respawn.conf", []byte(respawnConf), 0644)
 }
 
 func modifySshdConfig(cfg *config.CloudConfig) error {
@@ -336,7 +336,7 @@ func availableEngines(cfg *config.CloudConfig, update bool) []string {
 		}
 
 	}
-	engines, err := network.GetEngines(cfg.Rancher.Repositories.ToArray())
+	engines, err := network.GetEngines(cfg.Rancher.Repositories)
 	if err != nil {
 		log.Fatal(err)
 	}
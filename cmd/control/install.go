@@ -24,6 +24,10 @@ import (
 	"github.com/pkg/errors"
 )
 
+// encryptedBootSize is the end of the RANCHER_BOOT partition in MB, when
+// the state is encrypted
+const encryptedBootSize = "513"
+
 var installCommand = cli.Command{
 	Name:     "install",
 	Usage:    "install RancherOS to disk",
@@ -76,6 +80,11 @@ var installCommand = cli.Command{
 			Usage:  "rollback version",
 			Hidden: true,
 		},
+		cli.BoolFlag{
+			Name:   "no-rollback",
+			Usage:  "upgrade only: make the new version the default without booting it once first",
+			Hidden: true,
+		},
 		cli.BoolFlag{
 			Name:   "isoinstallerloaded",
 			Usage:  "INTERNAL use only: mount the iso to get kernel and initrd",
@@ -93,6 +102,14 @@ var installCommand = cli.Command{
 			Name:  "debug",
 			Usage: "Run installer with debug output",
 		},
+		cli.BoolFlag{
+			Name:  "encrypt",
+			Usage: "install the state to a LUKS partition, and boot from a separate RANCHER_BOOT partition",
+		},
+		cli.StringFlag{
+			Name:  "encrypt-key",
+			Usage: "keyfile of the LUKS partition, set rancher.state.encryption to unlock it unattended",
+		},
 	},
 }
 
@@ -118,6 +135,7 @@ func installAction(c *cli.Context) error {
 	force := c.Bool("force")
 	kexec := c.Bool("kexec")
 	reboot := !c.Bool("no-reboot")
+	rollback := !c.Bool("no-rollback")
 	isoinstallerloaded := c.Bool("isoinstallerloaded")
 
 	image := c.String("image")
@@ -179,13 +197,39 @@ func installAction(c *cli.Context) error {
 		cloudConfig = uc
 	}
 
+	encryptKey := ""
+	if c.Bool("encrypt") {
+		if installType != "generic" && installType != "syslinux" && installType != "gptsyslinux" {
+			log.Fatalf("--encrypt is not supported by the install type %s", installType)
+		}
+		if partition != "" {
+			log.Fatal("--encrypt partitions the device and can not be used with --partition")
+		}
+		if c.String("encrypt-key") == "" {
+			log.Fatal("--encrypt requires --encrypt-key")
+		}
+		// like the cloud-config, it is read by the installer container
+		os.MkdirAll("/opt", 0755)
+		encryptKey = "/opt/state.key"
+		if c.String("encrypt-key") != encryptKey {
+			key, err := ioutil.ReadFile(c.String("encrypt-key"))
+			if err != nil {
+				log.WithFields(log.Fields{"encryptKey": c.String("encrypt-key"), "error": err}).Fatal("Failed to read the encryption key")
+			}
+			if err := ioutil.WriteFile(encryptKey, key, 0600); err != nil {
+				log.WithFields(log.Fields{"encryptKey": encryptKey, "error": err}).Fatal("Failed to copy the encryption key")
+			}
+			defer os.Remove(encryptKey)
+		}
+	}
+
 	savedImages := []string{}
 	if c.Bool("save") && cloudConfig != "" && installType != "upgrade" {
 		savedImages = install.GetCacheImageList(cloudConfig, cfg)
 		log.Debugf("Will cache these images: %s", savedImages)
 	}
 
-	if err := runInstall(image, installType, cloudConfig, device, partition, statedir, kappend, force, kexec, isoinstallerloaded, debug, savedImages); err != nil {
+	if err := runInstall(image, installType, cloudConfig, device, partition, statedir, kappend, encryptKey, force, kexec, rollback, isoinstallerloaded, debug, savedImages); err != nil {
 		log.WithFields(log.Fields{"err": err}).Fatal("Failed to run install")
 		return err
 	}
@@ -198,7 +242,7 @@ func installAction(c *cli.Context) error {
 	return nil
 }
 
-func runInstall(image, installType, cloudConfig, device, partition, statedir, kappend string, force, kexec, isoinstallerloaded, debug bool, savedImages []string) error {
+func runInstall(image, installType, cloudConfig, device, partition, statedir, kappend, encryptKey string, force, kexec, rollback, isoinstallerloaded, debug bool, savedImages []string) error {
 	fmt.Printf("Installing from %s\n", image)
 
 	if !force {
@@ -286,6 +330,9 @@ func runInstall(image, installType, cloudConfig, device, partition, statedir, ka
 			if len(savedImages) > 0 {
 				installerCmd = append(installerCmd, "--save")
 			}
+			if encryptKey != "" {
+				installerCmd = append(installerCmd, "--encrypt", "--encrypt-key", encryptKey)
+			}
 
 			// TODO: mount at /mnt for shared mount?
 			if useIso {
@@ -310,7 +357,7 @@ func runInstall(image, installType, cloudConfig, device, partition, statedir, ka
 				diskType = "gpt"
 			}
 			log.Debugf("running setDiskpartitions")
-			err := setDiskpartitions(device, diskType)
+			err := setDiskpartitions(device, diskType, encryptKey != "")
 			if err != nil {
 				log.Errorf("error setDiskpartitions %s", err)
 				return err
@@ -342,7 +389,7 @@ func runInstall(image, installType, cloudConfig, device, partition, statedir, ka
 		}
 	}
 
-	err := layDownOS(image, installType, cloudConfig, device, partition, statedir, kappend, kexec)
+	err := layDownOS(image, installType, cloudConfig, device, partition, statedir, kappend, encryptKey, kexec, rollback)
 	if err != nil {
 		log.Errorf("error layDownOS %s", err)
 		return err
@@ -413,7 +460,7 @@ func mountBootIso(deviceName, deviceType string) error {
 	return nil
 }
 
-func layDownOS(image, installType, cloudConfig, device, partition, statedir, kappend string, kexec bool) error {
+func layDownOS(image, installType, cloudConfig, device, partition, statedir, kappend, encryptKey string, kexec, rollback bool) error {
 	// ENV == installType
 	//[[ "$ARCH" == "arm" && "$ENV" != "upgrade" ]] && ENV=arm
 
@@ -447,7 +494,17 @@ func layDownOS(image, installType, cloudConfig, device, partition, statedir, kap
 	case "generic":
 		log.Debugf("formatAndMount")
 		var err error
-		device, _, err = formatAndMount(baseName, device, partition)
+		if encryptKey != "" {
+			var closeEncrypted func()
+			closeEncrypted, err = formatAndMountEncrypted(baseName, partition, encryptKey)
+			if closeEncrypted != nil {
+				defer closeEncrypted()
+			}
+			// the keys of rancher.state.encryption are tried first
+			kernelArgs = kernelArgs + " rancher.state.cryptsetup"
+		} else {
+			device, _, err = formatAndMount(baseName, device, partition)
+		}
 		if err != nil {
 			log.Errorf("formatAndMount %s", err)
 			return err
@@ -566,6 +623,12 @@ func layDownOS(image, installType, cloudConfig, device, partition, statedir, kap
 	}
 	log.Debugf("installRancher done")
 
+	if installType == "upgrade" && rollback {
+		if err := install.SetTrialBoot(filepath.Join(baseName, config.BootDir)); err != nil {
+			log.Errorf("Failed to set up rollback, %s will be the default without a trial boot: %v", VERSION, err)
+		}
+	}
+
 	if kexec {
 		power.Kexec(false, filepath.Join(baseName, config.BootDir), kernelArgs+" "+kappend)
 	}
@@ -631,7 +694,7 @@ func seedData(baseName, cloudData string, files []string) error {
 }
 
 // set-disk-partitions is called with device ==  **/dev/sda**
-func setDiskpartitions(device, diskType string) error {
+func setDiskpartitions(device, diskType string, encrypt bool) error {
 	log.Debugf("setDiskpartitions")
 
 	d := strings.Split(device, "/")
@@ -732,6 +795,13 @@ func setDiskpartitions(device, diskType string) error {
 	cmd = exec.Command("parted", "-s", "-a", "optimal", device,
 		"mklabel "+diskType, "--",
 		"mkpart primary ext4 1 -1")
+	if encrypt {
+		log.Debugf("making RANCHER_BOOT and encrypted RANCHER_STATE partitions, device: %s", device)
+		cmd = exec.Command("parted", "-s", "-a", "optimal", device,
+			"mklabel "+diskType, "--",
+			"mkpart primary ext4 1 "+encryptedBootSize,
+			"mkpart primary ext4 "+encryptedBootSize+" -1")
+	}
 	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
 	if err := cmd.Run(); err != nil {
 		log.Errorf("Failed to parted device %s: %v", device, err)
@@ -790,6 +860,56 @@ func formatAndMount(baseName, device, partition string) (string, string, error)
 	return device, partition, nil
 }
 
+// formatAndMountEncrypted formats the boot partition as RANCHER_BOOT, and
+// the next one as a LUKS container of RANCHER_STATE. The state is mounted on
+// baseName and the boot on its /boot, until the returned func is called.
+func formatAndMountEncrypted(baseName, bootPartition, keyfile string) (func(), error) {
+	log.Debugf("formatAndMountEncrypted %s", bootPartition)
+
+	statePartition := strings.TrimSuffix(bootPartition, "1") + "2"
+	name := "luks-" + filepath.Base(statePartition)
+	mapped := filepath.Join("/dev/mapper", name)
+
+	cmd := exec.Command("cryptsetup", "luksFormat", "--batch-mode", "--key-file", keyfile, statePartition)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "Failed to create the LUKS container on %s", statePartition)
+	}
+	cmd = exec.Command("cryptsetup", "luksOpen", "--key-file", keyfile, statePartition, name)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "Failed to open the LUKS container on %s", statePartition)
+	}
+
+	bootDir := filepath.Join(baseName, config.BootDir)
+	closeEncrypted := func() {
+		util.Unmount(bootDir)
+		util.Unmount(baseName)
+		cmd := exec.Command("cryptsetup", "luksClose", name)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			log.Errorf("Failed to close %s: %v", name, err)
+		}
+	}
+
+	if err := formatdevice(statePartition, mapped); err != nil {
+		return closeEncrypted, err
+	}
+	cmd = exec.Command("mkfs.ext4", "-F", "-i", "4096", "-O", "^64bit", "-L", "RANCHER_BOOT", bootPartition)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return closeEncrypted, errors.Wrapf(err, "Failed to format %s", bootPartition)
+	}
+
+	if err := util.Mount(mapped, baseName, "ext4", ""); err != nil {
+		return closeEncrypted, err
+	}
+	if err := util.Mount(bootPartition, bootDir, "ext4", ""); err != nil {
+		return closeEncrypted, err
+	}
+	return closeEncrypted, nil
+}
+
 func setBootable(device, diskType string) error {
 	// TODO make conditional - if there is a bootable device already, don't break it
 	// TODO: make RANCHER_BOOT bootable - it might not be device 1
@@ -995,12 +1115,12 @@ func installRancher(baseName, VERSION, DIST, kappend string) (string, error) {
 	log.Debugf("installRancher")
 
 	// detect if there already is a linux-current.cfg, if so, move it to linux-previous.cfg,
-	currentCfg := filepath.Join(baseName, config.BootDir, "linux-current.cfg")
+	currentCfg := filepath.Join(baseName, config.BootDir, install.CurrentCfg)
 	if _, err := os.Stat(currentCfg); !os.IsNotExist(err) {
-		existingCfg := filepath.Join(DIST, "linux-current.cfg")
+		existingCfg := filepath.Join(DIST, install.CurrentCfg)
 		// only remove previous if there is a change to the current
 		if different(currentCfg, existingCfg) {
-			previousCfg := filepath.Join(baseName, config.BootDir, "linux-previous.cfg")
+			previousCfg := filepath.Join(baseName, config.BootDir, install.PreviousCfg)
 			if _, err := os.Stat(previousCfg); !os.IsNotExist(err) {
 				if err := os.Remove(previousCfg); err != nil {
 					return currentCfg, err
@@ -3,15 +3,23 @@ package install
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"html/template"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 
 	"github.com/rancher/os/pkg/log"
 )
 
+const (
+	CurrentCfg  = "linux-current.cfg"
+	PreviousCfg = "linux-previous.cfg"
+	RollbackCfg = "rollback.cfg"
+)
+
 func syslinuxConfig(menu BootVars) error {
 	log.Debugf("syslinuxConfig")
 
@@ -92,3 +100,105 @@ func ReadSyslinuxCfg(currentCfg string) (string, string, error) {
 	}
 	return vmlinuzFile, initrdFile, err
 }
+
+// ReadSyslinuxDefault returns the label named by the last DEFAULT line of a
+// syslinux cfg file, e.g. `rancheros-v1.5.0` for linux-current.cfg.
+func ReadSyslinuxDefault(cfgFile string) (string, error) {
+	label := ""
+	buf, err := ioutil.ReadFile(cfgFile)
+	if err != nil {
+		return label, err
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(buf))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "DEFAULT") {
+			label = strings.TrimSpace(strings.TrimPrefix(line, "DEFAULT"))
+		}
+	}
+	return label, nil
+}
+
+// SetTrialBoot makes the next boot (only) use linux-current.cfg, while the
+// syslinux DEFAULT stays on linux-previous.cfg until MarkBootGood is called.
+// If the new version fails before that, the next reboot falls back to the
+// previous one.
+func SetTrialBoot(bootDir string) error {
+	currentLabel, err := ReadSyslinuxDefault(filepath.Join(bootDir, CurrentCfg))
+	if err != nil {
+		return err
+	}
+	previousLabel, err := ReadSyslinuxDefault(filepath.Join(bootDir, PreviousCfg))
+	if os.IsNotExist(err) {
+		log.Infof("No %s, not setting up a rollback to the previous version", PreviousCfg)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if currentLabel == "" || previousLabel == "" || currentLabel == previousLabel {
+		log.Infof("Nothing to roll back to (current %q, previous %q)", currentLabel, previousLabel)
+		return nil
+	}
+
+	rollbackCfg := filepath.Join(bootDir, RollbackCfg)
+	content := fmt.Sprintf("# %s is booted once by extlinux --once, falling back to %s until it has booted successfully\nDEFAULT %s\n",
+		currentLabel, previousLabel, previousLabel)
+	if err := ioutil.WriteFile(rollbackCfg, []byte(content), 0644); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("extlinux", "--once="+currentLabel, filepath.Join(bootDir, "syslinux"))
+	log.Debugf("Run(%v)", cmd)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		// without the once flag the new version would never be booted
+		os.Remove(rollbackCfg)
+		return fmt.Errorf("extlinux --once=%s: %v: %s", currentLabel, err, out)
+	}
+	log.Infof("Next boot will try %s once, and fall back to %s if it fails", currentLabel, previousLabel)
+	return nil
+}
+
+// MarkBootGood is called once the running version has booted far enough to be
+// trusted. It makes a trial boot permanent, or if the trial failed and the
+// previous version was booted instead, swaps the slots so the known-good
+// version becomes linux-current.cfg again.
+func MarkBootGood(bootDir, runningLabel string) error {
+	rollbackCfg := filepath.Join(bootDir, RollbackCfg)
+	if _, err := os.Stat(rollbackCfg); os.IsNotExist(err) {
+		return nil
+	}
+
+	currentCfg := filepath.Join(bootDir, CurrentCfg)
+	previousCfg := filepath.Join(bootDir, PreviousCfg)
+	currentLabel, err := ReadSyslinuxDefault(currentCfg)
+	if err != nil {
+		return err
+	}
+	previousLabel, err := ReadSyslinuxDefault(previousCfg)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	switch runningLabel {
+	case currentLabel:
+		log.Infof("%s booted successfully, making it the default", currentLabel)
+	case previousLabel:
+		log.Errorf("%s failed to boot, rolled back to %s", currentLabel, previousLabel)
+		swapCfg := currentCfg + ".swap"
+		if err := os.Rename(currentCfg, swapCfg); err != nil {
+			return err
+		}
+		if err := os.Rename(previousCfg, currentCfg); err != nil {
+			return err
+		}
+		if err := os.Rename(swapCfg, previousCfg); err != nil {
+			return err
+		}
+	default:
+		log.Warnf("Running %s is neither %s nor %s, leaving %s in place", runningLabel, currentLabel, previousLabel, rollbackCfg)
+		return nil
+	}
+
+	return os.Remove(rollbackCfg)
+}
@@ -0,0 +1,73 @@
+package install
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeSlot(t *testing.T, dir, name, label string) {
+	content := "DEFAULT " + label + "\nLABEL " + label + "\n    KERNEL ../vmlinuz-4.14\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadSyslinuxDefault(t *testing.T) {
+	assert := require.New(t)
+	dir, err := ioutil.TempDir("", "syslinux")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	writeSlot(t, dir, CurrentCfg, "rancheros-v1.5.0")
+	label, err := ReadSyslinuxDefault(filepath.Join(dir, CurrentCfg))
+	assert.NoError(err)
+	assert.Equal("rancheros-v1.5.0", label)
+
+	_, err = ReadSyslinuxDefault(filepath.Join(dir, PreviousCfg))
+	assert.True(os.IsNotExist(err))
+}
+
+func TestMarkBootGood(t *testing.T) {
+	assert := require.New(t)
+
+	for _, tc := range []struct {
+		running, current, previous string
+		rollbackRemoved            bool
+	}{
+		// the new version came up
+		{"rancheros-v1.5.0", "rancheros-v1.5.0", "rancheros-v1.4.0", true},
+		// the new version failed, syslinux fell back to the previous one
+		{"rancheros-v1.4.0", "rancheros-v1.4.0", "rancheros-v1.5.0", true},
+		// booted something else by hand, leave the trial alone
+		{"rancheros-v1.3.0", "rancheros-v1.5.0", "rancheros-v1.4.0", false},
+	} {
+		dir, err := ioutil.TempDir("", "syslinux")
+		assert.NoError(err)
+		defer os.RemoveAll(dir)
+
+		writeSlot(t, dir, CurrentCfg, "rancheros-v1.5.0")
+		writeSlot(t, dir, PreviousCfg, "rancheros-v1.4.0")
+		writeSlot(t, dir, RollbackCfg, "rancheros-v1.4.0")
+
+		assert.NoError(MarkBootGood(dir, tc.running))
+
+		current, err := ReadSyslinuxDefault(filepath.Join(dir, CurrentCfg))
+		assert.NoError(err)
+		assert.Equal(tc.current, current, tc.running)
+		previous, err := ReadSyslinuxDefault(filepath.Join(dir, PreviousCfg))
+		assert.NoError(err)
+		assert.Equal(tc.previous, previous, tc.running)
+		_, err = os.Stat(filepath.Join(dir, RollbackCfg))
+		assert.Equal(tc.rollbackRemoved, os.IsNotExist(err), tc.running)
+	}
+
+	// no trial in progress
+	dir, err := ioutil.TempDir("", "syslinux")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	assert.NoError(MarkBootGood(dir, "rancheros-v1.5.0"))
+}
@@ -0,0 +1,62 @@
+package control
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/pkg/mounts"
+
+	"github.com/codegangsta/cli"
+)
+
+func mountSubcommands() []cli.Command {
+	return []cli.Command{
+		{
+			Name:   "list",
+			Usage:  "list the rancher.mounts and cloud-config mounts, and whether they are mounted",
+			Action: mountList,
+		},
+	}
+}
+
+func mountList(c *cli.Context) error {
+	cfg := config.LoadConfig()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tWHAT\tWHERE\tTYPE\tOPTIONS\tFLAGS\tSTATE")
+	for _, m := range mounts.List(cfg) {
+		state := "not mounted"
+		if mounts.Mounted(m) {
+			state = "mounted"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", m.Name, m.What, m.Where, orDash(m.Type), orDash(m.Options), mountFlags(m), state)
+	}
+	return w.Flush()
+}
+
+func mountFlags(m mounts.Mount) string {
+	flags := []string{}
+	if m.Required {
+		flags = append(flags, "required")
+	}
+	if m.WaitForNetwork {
+		flags = append(flags, "wait_for_network")
+	}
+	if mounts.BeforeServices(m) {
+		flags = append(flags, "before_services")
+	}
+	if m.Timeout != 0 {
+		flags = append(flags, fmt.Sprintf("timeout=%ds", m.Timeout))
+	}
+	return orDash(strings.Join(flags, ","))
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
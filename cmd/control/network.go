@@ -0,0 +1,77 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/pkg/docker"
+	"github.com/rancher/os/pkg/log"
+	"github.com/rancher/os/pkg/netconf"
+
+	"github.com/codegangsta/cli"
+	"golang.org/x/net/context"
+)
+
+func networkSubcommands() []cli.Command {
+	return []cli.Command{
+		{
+			Name:   "apply",
+			Usage:  "make the network match rancher.network, removing what is not configured anymore",
+			Action: networkApply,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "only show the changes that would be made",
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Usage: "text or json, for --dry-run",
+					Value: "text",
+				},
+			},
+		},
+	}
+}
+
+func networkApply(c *cli.Context) error {
+	if !c.Bool("dry-run") {
+		// the network service applies the config when it starts
+		client, err := docker.NewSystemClient()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := client.ContainerRestart(context.Background(), "network", 10); err != nil {
+			log.Fatalf("Failed to restart the network service: %v", err)
+		}
+		return nil
+	}
+
+	cfg := config.LoadConfig()
+	if err := netconf.MergeNetplan(&cfg.Rancher.Network); err != nil {
+		log.Fatalf("Failed to translate rancher.network.netplan: %v", err)
+	}
+	changes, err := netconf.Plan(&cfg.Rancher.Network)
+	if err != nil {
+		log.Fatalf("Failed to plan the network changes: %v", err)
+	}
+
+	switch c.String("format") {
+	case "json":
+		bytes, err := json.MarshalIndent(changes, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(bytes))
+	case "text":
+		if len(changes) == 0 {
+			fmt.Println("No changes")
+		}
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+	default:
+		log.Fatalf("Unknown format %q, expected text or json", c.String("format"))
+	}
+	return nil
+}
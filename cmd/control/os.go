@@ -68,6 +68,19 @@ func osSubcommands() []cli.Command {
 					Name:  "debug",
 					Usage: "Run installer with debug output",
 				},
+				cli.BoolFlag{
+					Name:  "no-rollback",
+					Usage: "do not fall back to the running version if the new one fails to boot, like rancher.upgrade.trial_boot=false",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "only pull the image and report whether the upgrade can go ahead",
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Value: "table",
+					Usage: "dry-run report format, table or json",
+				},
 			},
 		},
 		{
@@ -96,6 +109,7 @@ func getImages(update bool) (*Images, error) {
 	}
 
 	var body []byte
+	cfg := config.LoadConfig()
 
 	if strings.HasPrefix(upgradeURL, "/") {
 		body, err = ioutil.ReadFile(upgradeURL)
@@ -129,12 +143,15 @@ func getImages(update bool) (*Images, error) {
 		}
 	}
 
+	if err := network.VerifyResource(upgradeURL, body, true, cfg.Rancher.Upgrade.PublicKeys); err != nil {
+		return nil, err
+	}
+
 	images, err := parseBody(body)
 	if err != nil {
 		return nil, err
 	}
 
-	cfg := config.LoadConfig()
 	images.Current = formatImage(images.Current, cfg)
 	for i := len(images.Available) - 1; i >= 0; i-- {
 		images.Available[i] = formatImage(images.Available[i], cfg)
@@ -217,6 +234,10 @@ func osUpgrade(c *cli.Context) error {
 	if c.Args().Present() {
 		log.Fatalf("invalid arguments %v", c.Args())
 	}
+	if c.Bool("dry-run") {
+		return osUpgradePreflight(image, c.String("format"))
+	}
+	rollback := !c.Bool("no-rollback") && config.LoadConfig().Rancher.Upgrade.TrialBoot
 	if err := startUpgradeContainer(
 		image,
 		c.Bool("stage"),
@@ -225,6 +246,7 @@ func osUpgrade(c *cli.Context) error {
 		c.Bool("kexec"),
 		c.Bool("upgrade-console"),
 		c.Bool("debug"),
+		rollback,
 		c.String("append"),
 	); err != nil {
 		log.Fatal(err)
@@ -238,7 +260,7 @@ func osVersion(c *cli.Context) error {
 	return nil
 }
 
-func startUpgradeContainer(image string, stage, force, reboot, kexec, upgradeConsole, debug bool, kernelArgs string) error {
+func startUpgradeContainer(image string, stage, force, reboot, kexec, upgradeConsole, debug, rollback bool, kernelArgs string) error {
 	command := []string{
 		"-t", "rancher-upgrade",
 		"-r", config.Version,
@@ -250,6 +272,9 @@ func startUpgradeContainer(image string, stage, force, reboot, kexec, upgradeCon
 	if debug {
 		command = append(command, "--debug")
 	}
+	if !rollback {
+		command = append(command, "--no-rollback")
+	}
 
 	kernelArgs = strings.TrimSpace(kernelArgs)
 	if kernelArgs != "" {
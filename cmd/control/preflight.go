@@ -0,0 +1,405 @@
+package control
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/rancher/os/cmd/control/install"
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/pkg/docker"
+	"github.com/rancher/os/pkg/log"
+	"github.com/rancher/os/pkg/util/network"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+	composeConfig "github.com/docker/libcompose/config"
+	"golang.org/x/net/context"
+)
+
+const (
+	preflightOK   = "ok"
+	preflightWarn = "warn"
+	preflightFail = "fail"
+	preflightSkip = "skip"
+
+	// used when the size of the installed kernel and initrd can't be read
+	defaultBootSpace int64 = 64 << 20
+	// room to pull the installer image and restage services
+	defaultStateSpace int64 = 512 << 20
+)
+
+type preflightCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type imageChange struct {
+	Service string `json:"service"`
+	Kind    string `json:"kind"`
+	Current string `json:"current"`
+	Target  string `json:"target"`
+}
+
+type preflightReport struct {
+	CurrentVersion string           `json:"current_version"`
+	TargetImage    string           `json:"target_image"`
+	TargetVersion  string           `json:"target_version"`
+	Checks         []preflightCheck `json:"checks"`
+	ImageChanges   []imageChange    `json:"image_changes"`
+	OK             bool             `json:"ok"`
+}
+
+func (r *preflightReport) add(name, status, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, preflightCheck{
+		Name:   name,
+		Status: status,
+		Detail: fmt.Sprintf(format, args...),
+	})
+	if status == preflightFail {
+		r.OK = false
+	}
+}
+
+func osUpgradePreflight(image, format string) error {
+	if format != "table" && format != "json" {
+		return fmt.Errorf("unknown format %q, expected table or json", format)
+	}
+
+	report := runPreflight(image, config.LoadConfig())
+
+	if format == "json" {
+		bytes, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(bytes))
+	} else {
+		printPreflight(os.Stdout, report)
+	}
+
+	if !report.OK {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func runPreflight(image string, cfg *config.CloudConfig) *preflightReport {
+	report := &preflightReport{
+		CurrentVersion: config.Version,
+		TargetImage:    image,
+		TargetVersion:  imageVersion(image),
+		Checks:         []preflightCheck{},
+		ImageChanges:   []imageChange{},
+		OK:             true,
+	}
+
+	checkBootSpace(report)
+	checkStateSpace(report)
+
+	pulled := checkTargetImage(report, image)
+	if pulled {
+		checkTargetSchema(report, image)
+	} else {
+		report.add("config schema", preflightSkip, "target image is not available")
+	}
+
+	report.ImageChanges = append(report.ImageChanges, systemImageChanges(cfg, report.TargetVersion)...)
+	report.ImageChanges = append(report.ImageChanges, repositoryImageChanges(cfg, report.TargetVersion)...)
+
+	return report
+}
+
+func printPreflight(out io.Writer, report *preflightReport) {
+	fmt.Fprintf(out, "Current version: %s\n", report.CurrentVersion)
+	fmt.Fprintf(out, "Target image:    %s\n\n", report.TargetImage)
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tSTATUS\tDETAIL")
+	for _, check := range report.Checks {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", check.Name, check.Status, check.Detail)
+	}
+	w.Flush()
+
+	fmt.Fprintln(out)
+	if len(report.ImageChanges) == 0 {
+		fmt.Fprintln(out, "No service, console or engine images change")
+	} else {
+		w = tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "SERVICE\tKIND\tCURRENT IMAGE\tTARGET IMAGE")
+		for _, change := range report.ImageChanges {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", change.Service, change.Kind, change.Current, change.Target)
+		}
+		w.Flush()
+	}
+
+	fmt.Fprintln(out)
+	if report.OK {
+		fmt.Fprintln(out, "Preflight passed")
+	} else {
+		fmt.Fprintln(out, "Preflight failed")
+	}
+}
+
+func freeSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
+
+func checkSpace(report *preflightReport, name, path string, needed int64) {
+	free, err := freeSpace(path)
+	if err != nil {
+		report.add(name, preflightWarn, "could not stat %s: %v", path, err)
+		return
+	}
+	status := preflightOK
+	if free < needed {
+		status = preflightFail
+	}
+	report.add(name, status, "%s free on %s, %s needed", humanSize(free), path, humanSize(needed))
+}
+
+func checkBootSpace(report *preflightReport) {
+	bootDir := "/proc/1/root" + config.BootDir
+
+	// the new kernel and initrd are written next to the current ones,
+	// so expect them to need about as much space again
+	needed := int64(0)
+	vmlinuz, initrd, err := install.ReadSyslinuxCfg(filepath.Join(bootDir, install.CurrentCfg))
+	if err == nil {
+		for _, file := range []string{vmlinuz, initrd} {
+			if info, err := os.Stat(file); err == nil {
+				needed += info.Size()
+			}
+		}
+	}
+	if needed == 0 {
+		needed = defaultBootSpace
+	}
+
+	checkSpace(report, "boot space", bootDir, needed)
+}
+
+func checkStateSpace(report *preflightReport) {
+	checkSpace(report, "state space", config.VarRancherDir, defaultStateSpace)
+}
+
+func checkTargetImage(report *preflightReport, image string) bool {
+	client, err := docker.NewSystemClient()
+	if err != nil {
+		report.add("image", preflightFail, "%v", err)
+		return false
+	}
+
+	if _, _, err := client.ImageInspectWithRaw(context.Background(), image, false); err == nil {
+		report.add("image", preflightOK, "%s is available locally", image)
+		return true
+	}
+
+	for _, check := range report.Checks {
+		if check.Status == preflightFail {
+			report.add("image", preflightSkip, "not pulling %s, not enough space", image)
+			return false
+		}
+	}
+
+	cmd := exec.Command("system-docker", "pull", image)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		report.add("image", preflightFail, "failed to pull %s: %v: %s", image, err, strings.TrimSpace(string(out)))
+		return false
+	}
+	report.add("image", preflightOK, "pulled %s", image)
+	return true
+}
+
+// checkTargetSchema validates the current config with the ros binary of the target image
+func checkTargetSchema(report *preflightReport, image string) {
+	current, err := config.Export(true, false)
+	if err != nil {
+		report.add("config schema", preflightFail, "failed to export the current config: %v", err)
+		return
+	}
+
+	cmd := exec.Command("system-docker", "run", "--rm", "-i", "--entrypoint", "/bin/ros", image, "config", "validate", "--json")
+	cmd.Stdin = strings.NewReader(current)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+
+	result, err := parseValidation(stdout.Bytes(), runErr)
+	if err != nil {
+		// the target ros may predate validate --json, its logs are not schema errors
+		report.add("config schema", preflightWarn, "could not validate with %s: %v: %s", image, err, strings.TrimSpace(stderr.String()))
+		return
+	}
+	if !result.Valid {
+		report.add("config schema", preflightFail, "%d error(s): %s", len(result.Errors), strings.Join(result.Errors, "; "))
+		return
+	}
+	report.add("config schema", preflightOK, "current config is valid for %s", report.TargetVersion)
+}
+
+// parseValidation reads the output of ros config validate --json. The exit
+// status decides whether the config is valid, the output only lists why not.
+func parseValidation(stdout []byte, runErr error) (*validationResult, error) {
+	if runErr != nil {
+		if _, ok := runErr.(*exec.ExitError); !ok {
+			return nil, runErr
+		}
+	}
+
+	result := &validationResult{}
+	if err := json.Unmarshal(stdout, result); err != nil {
+		if runErr != nil {
+			return nil, runErr
+		}
+		return nil, fmt.Errorf("unexpected output: %v", err)
+	}
+	if (runErr == nil) != result.Valid {
+		return nil, fmt.Errorf("exit status does not match the output (%v)", runErr)
+	}
+	if !result.Valid && len(result.Errors) == 0 {
+		result.Errors = []string{"invalid config"}
+	}
+	return result, nil
+}
+
+// imageVersion returns the tag of an os image, without the arch suffix
+func imageVersion(image string) string {
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return ""
+	}
+	return strings.TrimSuffix(image[i+1:], config.Suffix)
+}
+
+// upgradedImage returns the image a service will use after upgrading to version,
+// for the images that are tagged with the running os version
+func upgradedImage(image, version string) string {
+	current := ":" + config.Version + config.Suffix
+	if version == "" || !strings.HasSuffix(image, current) {
+		return image
+	}
+	return strings.TrimSuffix(image, current) + ":" + version + config.Suffix
+}
+
+func systemImageChanges(cfg *config.CloudConfig, version string) []imageChange {
+	changes := []imageChange{}
+	for kind, services := range map[string]map[string]*composeConfig.ServiceConfigV1{
+		"system":     cfg.Rancher.Services,
+		"bootstrap":  cfg.Rancher.BootstrapContainers,
+		"cloud-init": cfg.Rancher.CloudInitServices,
+	} {
+		for name, service := range services {
+			if service == nil || service.Image == "" {
+				continue
+			}
+			current := formatImage(service.Image, cfg)
+			target := formatImage(upgradedImage(service.Image, version), cfg)
+			if current != target {
+				changes = append(changes, imageChange{name, kind, current, target})
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Kind != changes[j].Kind {
+			return changes[i].Kind < changes[j].Kind
+		}
+		return changes[i].Service < changes[j].Service
+	})
+	return changes
+}
+
+// upgradedRepositories points the repositories that are pinned to the running
+// os version at the target version instead
+func upgradedRepositories(repos config.Repositories, version string) config.Repositories {
+	result := config.Repositories{}
+	for name, repo := range repos {
+		if version != "" {
+			repo.URL = strings.Replace(repo.URL, config.Version, version, -1)
+		}
+		result[name] = repo
+	}
+	return result
+}
+
+func repositoryImageChanges(cfg *config.CloudConfig, version string) []imageChange {
+	targetCfg := *cfg
+	targetCfg.Rancher.Repositories = upgradedRepositories(cfg.Rancher.Repositories, version)
+
+	type repoService struct {
+		name, kind, key string
+	}
+	services := []repoService{}
+	for name, enabled := range cfg.Rancher.ServicesInclude {
+		if enabled {
+			services = append(services, repoService{name, "service", name})
+		}
+	}
+	if cfg.Rancher.Console != "" && cfg.Rancher.Console != "default" {
+		services = append(services, repoService{cfg.Rancher.Console, "console", "console"})
+	}
+	if cfg.Rancher.Docker.Engine != "" {
+		services = append(services, repoService{cfg.Rancher.Docker.Engine, "engine", "docker"})
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].name < services[j].name
+	})
+
+	changes := []imageChange{}
+	for _, s := range services {
+		current, err := repositoryImage(s.name, s.key, cfg)
+		if err != nil {
+			log.Debugf("Failed to load %s from the current repositories: %v", s.name, err)
+			continue
+		}
+		target, err := repositoryImage(s.name, s.key, &targetCfg)
+		if err != nil {
+			log.Debugf("Failed to load %s from the target repositories: %v", s.name, err)
+			continue
+		}
+		if current != target {
+			changes = append(changes, imageChange{s.name, s.kind, current, target})
+		}
+	}
+	return changes
+}
+
+func repositoryImage(name, key string, cfg *config.CloudConfig) (string, error) {
+	bytes, err := network.LoadServiceResource(name, true, cfg)
+	if err != nil {
+		return "", err
+	}
+	imageConfig := map[interface{}]install.ImageConfig{}
+	if err = yaml.Unmarshal(bytes, &imageConfig); err != nil {
+		return "", err
+	}
+	image := strings.Replace(imageConfig[key].Image, "${SUFFIX}", config.Suffix, -1)
+	image = strings.Replace(image, "${REGISTRY_DOMAIN}", cfg.Rancher.Environment["REGISTRY_DOMAIN"], -1)
+	return image, nil
+}
+
+func humanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,72 @@
+package control
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/rancher/os/config"
+
+	composeConfig "github.com/docker/libcompose/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageVersion(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("v1.5.0", imageVersion("rancher/os:v1.5.0"+config.Suffix))
+	assert.Equal("v1.5.0", imageVersion("registry.local:5000/rancher/os:v1.5.0"+config.Suffix))
+	assert.Equal("", imageVersion("registry.local:5000/rancher/os"))
+}
+
+func TestUpgradeImageChanges(t *testing.T) {
+	assert := require.New(t)
+
+	running := ":" + config.Version + config.Suffix
+	target := ":v9.9.9" + config.Suffix
+
+	cfg := &config.CloudConfig{}
+	cfg.Rancher.Services = map[string]*composeConfig.ServiceConfigV1{
+		"console": {Image: "rancher/os-console" + running},
+		"ntp":     {Image: "rancher/os-ntp:v0.1"},
+	}
+	cfg.Rancher.BootstrapContainers = map[string]*composeConfig.ServiceConfigV1{
+		"udev-bootstrap": {Image: "rancher/os-base" + running},
+	}
+
+	assert.Equal([]imageChange{
+		{"udev-bootstrap", "bootstrap", "rancher/os-base" + running, "rancher/os-base" + target},
+		{"console", "system", "rancher/os-console" + running, "rancher/os-console" + target},
+	}, systemImageChanges(cfg, "v9.9.9"))
+
+	repos := upgradedRepositories(config.Repositories{
+		"core":  {URL: "https://raw.githubusercontent.com/rancher/os-services/" + config.Version},
+		"extra": {URL: "https://example.com/services/master"},
+	}, "v9.9.9")
+	assert.Equal("https://raw.githubusercontent.com/rancher/os-services/v9.9.9", repos["core"].URL)
+	assert.Equal("https://example.com/services/master", repos["extra"].URL)
+}
+
+func TestParseValidation(t *testing.T) {
+	assert := require.New(t)
+
+	result, err := parseValidation([]byte(`{"valid":true,"errors":[]}`+"\n"), nil)
+	assert.NoError(err)
+	assert.True(result.Valid)
+
+	exitErr := exec.Command("false").Run()
+	result, err = parseValidation([]byte(`{"valid":false,"errors":["rancher: Additional property bad_key is not allowed"]}`), exitErr)
+	assert.NoError(err)
+	assert.False(result.Valid)
+	assert.Equal([]string{"rancher: Additional property bad_key is not allowed"}, result.Errors)
+
+	// logs of a ros without validate --json are not schema errors
+	_, err = parseValidation([]byte("Incorrect Usage.\n"), exitErr)
+	assert.Equal(exitErr, err)
+	_, err = parseValidation(nil, nil)
+	assert.Error(err)
+
+	_, err = parseValidation([]byte(`{"valid":true,"errors":[]}`), exitErr)
+	assert.Error(err)
+	_, err = parseValidation(nil, exec.ErrNotFound)
+	assert.Equal(exec.ErrNotFound, err)
+}
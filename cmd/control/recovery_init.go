@@ -20,5 +20,5 @@ func recoveryInitAction(c *cli.Context) error {
 		return err
 	}
 
-	return syscall.Exec(respawnBinPath, []string{"respawn", "-f", "/etc/respawn.conf"}, os.Environ())
+	return syscall.Exec(respawnBinPath, []string{"respawn", "-f", "/etc/respawn.conf", "-d", "/etc/respawn.conf.d"}, os.Environ())
 }
@@ -0,0 +1,67 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rancher/os/cmd/respawn"
+	"github.com/rancher/os/pkg/log"
+
+	"github.com/codegangsta/cli"
+)
+
+func respawnSubcommands() []cli.Command {
+	return []cli.Command{
+		{
+			Name:   "status",
+			Usage:  "show the state of the processes run by respawn",
+			Action: respawnStatus,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "format",
+					Usage: "table or json",
+					Value: "table",
+				},
+				cli.StringFlag{
+					Name:  "socket",
+					Usage: "respawn control socket",
+					Value: respawn.ControlSocket,
+				},
+			},
+		},
+	}
+}
+
+func respawnStatus(c *cli.Context) error {
+	statuses, err := respawn.Status(c.String("socket"))
+	if err != nil {
+		log.Fatalf("Failed to get the respawn status: %v", err)
+	}
+
+	switch c.String("format") {
+	case "json":
+		bytes, err := json.MarshalIndent(statuses, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(bytes))
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSTATE\tPID\tRESTARTS\tUPTIME\tLAST EXIT\tCOMMAND")
+		for _, s := range statuses {
+			pid, uptime := "-", "-"
+			if s.Pid != 0 {
+				pid = fmt.Sprint(s.Pid)
+				uptime = fmt.Sprintf("%ds", int(time.Since(s.Started).Seconds()))
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", s.Name, s.State, pid, s.Restarts, uptime, s.LastExit, s.Command)
+		}
+		w.Flush()
+	default:
+		log.Fatalf("Unknown format %q, expected table or json", c.String("format"))
+	}
+	return nil
+}
@@ -247,7 +247,7 @@ func availableService(cfg *config.CloudConfig, update bool) []string {
 		}
 
 	}
-	services, err := network.GetServices(cfg.Rancher.Repositories.ToArray())
+	services, err := network.GetServices(cfg.Rancher.Repositories)
 	if err != nil {
 		log.Fatalf("Failed to get services: %v", err)
 	}
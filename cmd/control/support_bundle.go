@@ -0,0 +1,298 @@
+package control
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/pkg/docker"
+	"github.com/rancher/os/pkg/log"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+	"github.com/codegangsta/cli"
+	"github.com/docker/engine-api/types"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/context"
+)
+
+const (
+	bootLogDir = "/var/log/boot"
+	// the recovery console only has the host's /var/log through the
+	// root of its init, as it shares its pid namespace
+	hostRoot = "/proc/1/root"
+	redacted = "REDACTED"
+)
+
+// registryCredentials are the keys of rancher.registry_auths and
+// rancher.registries that are redacted from the bundle
+var registryCredentials = []string{"auth", "password", "identitytoken", "registrytoken", "client_key"}
+
+type bundleFile struct {
+	name    string
+	collect func() ([]byte, error)
+}
+
+func supportBundleAction(c *cli.Context) error {
+	output := c.String("output")
+	if output == "" {
+		output = path.Join(config.VarRancherDir, fmt.Sprintf("support-bundle-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z")))
+	}
+
+	files := []bundleFile{
+		{"version.txt", collectVersion},
+		{"config/export.yml", collectConfig},
+		{"config/metadata", readHostFile(config.MetaDataFile)},
+		{"system-docker/ps.txt", collectContainers},
+		{"system-docker/inspect.json", collectInspect},
+		{"network/links.txt", collectLinks},
+		{"network/routes.txt", collectRoutes},
+		{"dmesg.txt", runCollector("dmesg")},
+		{"mounts.txt", readHostFile("/proc/1/mounts")},
+	}
+	files = append(files, bootLogFiles()...)
+
+	if err := writeBundle(output, files); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(output)
+
+	return nil
+}
+
+// writeBundle writes the files to a gzipped tarball. The files that can not
+// be collected are replaced by a .error file with the error.
+func writeBundle(output string, files []bundleFile) error {
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, file := range files {
+		name := file.name
+		content, err := file.collect()
+		if err != nil {
+			log.Warnf("Failed to collect %s: %v", name, err)
+			name += ".error"
+			content = []byte(err.Error() + "\n")
+		}
+
+		header := &tar.Header{
+			Name:    path.Join("support-bundle", name),
+			Mode:    0600,
+			Size:    int64(len(content)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// hostPath returns the path on the host, through the root of init when it
+// is not mounted in the recovery console
+func hostPath(p string) string {
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		if _, err := os.Stat(path.Join(hostRoot, p)); err == nil {
+			return path.Join(hostRoot, p)
+		}
+	}
+	return p
+}
+
+func readHostFile(p string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		return ioutil.ReadFile(hostPath(p))
+	}
+}
+
+func runCollector(name string, args ...string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		return exec.Command(name, args...).CombinedOutput()
+	}
+}
+
+func bootLogFiles() []bundleFile {
+	files, err := ioutil.ReadDir(hostPath(bootLogDir))
+	if err != nil {
+		return []bundleFile{{"boot", func() ([]byte, error) { return nil, err }}}
+	}
+
+	bootFiles := []bundleFile{}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		bootFiles = append(bootFiles, bundleFile{
+			name:    path.Join("boot", file.Name()),
+			collect: readHostFile(path.Join(bootLogDir, file.Name())),
+		})
+	}
+	return bootFiles
+}
+
+func collectVersion() ([]byte, error) {
+	return []byte(fmt.Sprintf("version: %s\nkernel: %s\n", config.Version, config.GetKernelVersion())), nil
+}
+
+// collectConfig exports the full config without the private keys, and
+// redacts the registry credentials
+func collectConfig() ([]byte, error) {
+	export, err := config.Export(false, true)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal([]byte(export), &data); err != nil {
+		return nil, err
+	}
+	redactRegistryCredentials(data)
+	return yaml.Marshal(data)
+}
+
+func redactRegistryCredentials(data map[interface{}]interface{}) {
+	rancher, _ := data["rancher"].(map[interface{}]interface{})
+	for _, key := range []string{"registry_auths", "registries"} {
+		registries, _ := rancher[key].(map[interface{}]interface{})
+		for _, registry := range registries {
+			registry, ok := registry.(map[interface{}]interface{})
+			if !ok {
+				continue
+			}
+			for _, credential := range registryCredentials {
+				if _, ok := registry[credential]; ok {
+					registry[credential] = redacted
+				}
+			}
+		}
+	}
+}
+
+func collectContainers() ([]byte, error) {
+	client, err := docker.NewSystemClient()
+	if err != nil {
+		return nil, err
+	}
+	containers, err := client.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	w := tabwriter.NewWriter(buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tIMAGE\tSTATE\tSTATUS\tNAMES")
+	for _, c := range containers {
+		id := c.ID
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%v\n", id, c.Image, c.State, c.Status, c.Names)
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func collectInspect() ([]byte, error) {
+	client, err := docker.NewSystemClient()
+	if err != nil {
+		return nil, err
+	}
+	containers, err := client.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []types.ContainerJSON{}
+	for _, c := range containers {
+		info, err := client.ContainerInspect(context.Background(), c.ID)
+		if err != nil {
+			log.Warnf("Failed to inspect %s: %v", c.ID, err)
+			continue
+		}
+		redactEnv(&info)
+		infos = append(infos, info)
+	}
+	return json.MarshalIndent(infos, "", "  ")
+}
+
+// redactEnv keeps the names of the container environment variables, their
+// values often are credentials
+func redactEnv(info *types.ContainerJSON) {
+	if info.Config == nil {
+		return
+	}
+	for i, env := range info.Config.Env {
+		if eq := strings.Index(env, "="); eq >= 0 {
+			info.Config.Env[i] = env[:eq+1] + redacted
+		}
+	}
+}
+
+func collectLinks() ([]byte, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	for _, link := range links {
+		attrs := link.Attrs()
+		fmt.Fprintf(buf, "%d: %s: <%s> mtu %d state %s type %s\n", attrs.Index, attrs.Name, attrs.Flags, attrs.MTU, attrs.OperState, link.Type())
+		if len(attrs.HardwareAddr) > 0 {
+			fmt.Fprintf(buf, "    link %s\n", attrs.HardwareAddr)
+		}
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil {
+			fmt.Fprintf(buf, "    addrs: %v\n", err)
+			continue
+		}
+		for _, addr := range addrs {
+			fmt.Fprintf(buf, "    addr %s\n", addr.IPNet)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func collectRoutes() ([]byte, error) {
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[int]string{}
+	if links, err := netlink.LinkList(); err == nil {
+		for _, link := range links {
+			names[link.Attrs().Index] = link.Attrs().Name
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	for _, route := range routes {
+		fmt.Fprintf(buf, "%s dev %s\n", route, names[route.LinkIndex])
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,101 @@
+package control
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/container"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactRegistryCredentials(t *testing.T) {
+	assert := require.New(t)
+
+	data := map[interface{}]interface{}{
+		"rancher": map[interface{}]interface{}{
+			"registry_auths": map[interface{}]interface{}{
+				"harbor.example.com": map[interface{}]interface{}{
+					"username": "admin",
+					"password": "hunter2",
+					"auth":     "YWRtaW46aHVudGVyMg==",
+				},
+			},
+			"registries": map[interface{}]interface{}{
+				"harbor.example.com": map[interface{}]interface{}{
+					"ca":         "ca",
+					"client_key": "key",
+				},
+			},
+		},
+	}
+	redactRegistryCredentials(data)
+
+	rancher := data["rancher"].(map[interface{}]interface{})
+	assert.Equal(map[interface{}]interface{}{
+		"username": "admin",
+		"password": redacted,
+		"auth":     redacted,
+	}, rancher["registry_auths"].(map[interface{}]interface{})["harbor.example.com"])
+	assert.Equal(map[interface{}]interface{}{
+		"ca":         "ca",
+		"client_key": redacted,
+	}, rancher["registries"].(map[interface{}]interface{})["harbor.example.com"])
+
+	redactRegistryCredentials(map[interface{}]interface{}{})
+}
+
+func TestRedactEnv(t *testing.T) {
+	assert := require.New(t)
+
+	info := types.ContainerJSON{
+		Config: &container.Config{
+			Env: []string{"PATH=/usr/bin:/bin", "AWS_SECRET_ACCESS_KEY=hunter2", "EMPTY=", "UNSET"},
+		},
+	}
+	redactEnv(&info)
+	assert.Equal([]string{"PATH=" + redacted, "AWS_SECRET_ACCESS_KEY=" + redacted, "EMPTY=" + redacted, "UNSET"}, info.Config.Env)
+
+	redactEnv(&types.ContainerJSON{})
+}
+
+func TestWriteBundle(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "bundle")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	output := path.Join(dir, "bundle.tar.gz")
+	assert.NoError(writeBundle(output, []bundleFile{
+		{"version.txt", func() ([]byte, error) { return []byte("v1.5.0\n"), nil }},
+		{"dmesg.txt", func() ([]byte, error) { return nil, errors.New("dmesg not found") }},
+	}))
+
+	f, err := os.Open(output)
+	assert.NoError(err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	assert.NoError(err)
+
+	files := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		content, err := ioutil.ReadAll(tr)
+		assert.NoError(err)
+		files[header.Name] = string(content)
+	}
+	assert.Equal(map[string]string{
+		"support-bundle/version.txt":     "v1.5.0\n",
+		"support-bundle/dmesg.txt.error": "dmesg not found\n",
+	}, files)
+}
@@ -52,6 +52,7 @@ func RunInit() error {
 		{"recovery console", recovery.LoadRecoveryConsole},
 		{"b2d env", b2d.B2D},
 		{"mount STATE and bootstrap", fsmount.MountStateAndBootstrap},
+		{"restore config", fsmount.RestoreConfig},
 		{"cloud-init", cloudinit.CloudInit},
 		{"read cfg and log files", configfiles.ReadConfigFiles},
 		{"switchroot", switchroot.SwitchRoot},
@@ -65,11 +66,14 @@ func RunInit() error {
 		{"set proxy env", env.Proxy},
 		{"init SELinux", selinux.Initialize},
 		{"setupSharedRoot", sharedroot.Setup},
+		{"mount before services", fsmount.MountBeforeServices},
 		{"sysinit", sysinit.RunSysInit},
 	}
 
-	cfg, err := config.ChainCfgFuncs(nil, initFuncs)
+	report := config.NewInitReport()
+	cfg, err := config.ChainCfgFuncsWithReport(nil, initFuncs, report)
 	if err != nil {
+		writeInitReport(report)
 		recovery.Recovery(err)
 	}
 
@@ -77,6 +81,14 @@ func RunInit() error {
 	launchConfig.Fork = !cfg.Rancher.SystemDocker.Exec
 	//launchConfig.NoLog = true
 
+	writeInitReport(report)
+	if err := config.PruneBootEvents(config.BootEventsFile, config.BootID()); err != nil {
+		log.Errorf("Failed to prune %s: %v", config.BootEventsFile, err)
+	}
+	if err := config.MarkBooting(config.BootingFile); err != nil {
+		log.Errorf("Failed to write %s: %v", config.BootingFile, err)
+	}
+
 	log.Info("Launching System Docker")
 	_, err = dfs.LaunchDocker(launchConfig, config.SystemDockerBin, args...)
 	if err != nil {
@@ -88,3 +100,9 @@ func RunInit() error {
 
 	return one.PidOne()
 }
+
+func writeInitReport(report *config.InitReport) {
+	if err := report.Write(config.InitReportFile); err != nil {
+		log.Errorf("Failed to write %s: %v", config.InitReportFile, err)
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/rancher/os/pkg/hostname"
 	"github.com/rancher/os/pkg/log"
 	"github.com/rancher/os/pkg/netconf"
+	"github.com/rancher/os/pkg/timesync"
 
 	"github.com/docker/libnetwork/resolvconf"
 	"golang.org/x/net/context"
@@ -32,6 +33,10 @@ func Main() {
 	cfg := config.LoadConfig()
 	ApplyNetworkConfig(cfg)
 
+	// fix the clock before anything checks TLS certificates, here rather
+	// than in ApplyNetworkConfig which cloud-init-save also calls twice
+	timesync.SyncClock(cfg.Rancher.Time)
+
 	log.Infof("Restart syslog")
 	client, err := docker.NewSystemClient()
 	if err != nil {
@@ -52,7 +57,11 @@ func Main() {
 
 func ApplyNetworkConfig(cfg *config.CloudConfig) {
 	log.Infof("Apply Network Config")
-	userSetDNS := len(cfg.Rancher.Network.DNS.Nameservers) > 0 || len(cfg.Rancher.Network.DNS.Search) > 0
+	if err := netconf.MergeNetplan(&cfg.Rancher.Network); err != nil {
+		log.Errorf("Failed to translate rancher.network.netplan: %v", err)
+	}
+	nameservers := mergeNameservers(cfg.Rancher.Network.DNS.Nameservers, netconf.IPv6Nameservers(&cfg.Rancher.Network))
+	userSetDNS := len(nameservers) > 0 || len(cfg.Rancher.Network.DNS.Search) > 0
 
 	if err := hostname.SetHostnameFromCloudConfig(cfg); err != nil {
 		log.Errorf("Failed to set hostname from cloud config: %v", err)
@@ -90,10 +99,10 @@ func ApplyNetworkConfig(cfg *config.CloudConfig) {
 		}
 	}
 	if userSetDNS {
-		if _, err := resolvconf.Build("/etc/resolv.conf", cfg.Rancher.Network.DNS.Nameservers, cfg.Rancher.Network.DNS.Search, nil); err != nil {
+		if _, err := resolvconf.Build("/etc/resolv.conf", nameservers, cfg.Rancher.Network.DNS.Search, nil); err != nil {
 			log.Errorf("Failed to write resolv.conf (userSetDNS): %v", err)
 		} else {
-			log.Infof("writing to /etc/resolv.conf: nameservers: %v, search: %v", cfg.Rancher.Network.DNS.Nameservers, cfg.Rancher.Network.DNS.Search)
+			log.Infof("writing to /etc/resolv.conf: nameservers: %v, search: %v", nameservers, cfg.Rancher.Network.DNS.Search)
 		}
 	}
 
@@ -104,6 +113,24 @@ func ApplyNetworkConfig(cfg *config.CloudConfig) {
 	if err := hostname.SyncHostname(); err != nil {
 		log.Errorf("Failed to sync hostname: %v", err)
 	}
+
+	if err := timesync.WriteNtpConf(config.NtpConfFile, cfg.Rancher.Time); err != nil {
+		log.Errorf("Failed to write %s: %v", config.NtpConfFile, err)
+	}
+}
+
+// mergeNameservers appends the ipv6 nameservers of the interfaces to the
+// configured ones, without duplicates
+func mergeNameservers(nameservers, ipv6Nameservers []string) []string {
+	merged := []string{}
+	seen := map[string]bool{}
+	for _, ns := range append(append([]string{}, nameservers...), ipv6Nameservers...) {
+		if !seen[ns] {
+			seen[ns] = true
+			merged = append(merged, ns)
+		}
+	}
+	return merged
 }
 
 func generateDhcpcdFiles(cfg *config.CloudConfig) {
@@ -0,0 +1,201 @@
+package respawn
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rancher/os/pkg/util"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+)
+
+const (
+	RestartAlways    = "always"
+	RestartOnFailure = "on-failure"
+	RestartNever     = "never"
+
+	// a process that stays up this long is not restarting too fast anymore,
+	// so its backoff and restart count start over
+	defaultStableSeconds = 10
+)
+
+// Config is the yaml respawn config format:
+//
+//	processes:
+//	  sshd:
+//	    command: /usr/sbin/sshd -D
+//	    restart: on-failure
+//	    max_restarts: 5
+//	    backoff:
+//	      start_millis: 500
+//	      max_interval_millis: 10000
+//	      max_millis: 300000
+//	    liveness:
+//	      command: pgrep -x sshd
+//	      interval: 30
+type Config struct {
+	Processes map[string]*ProcessConfig `yaml:"processes,omitempty"`
+}
+
+type ProcessConfig struct {
+	Command       string          `yaml:"command,omitempty"`
+	Restart       string          `yaml:"restart,omitempty"`
+	MaxRestarts   int             `yaml:"max_restarts,omitempty"`
+	StableSeconds int             `yaml:"stable_seconds,omitempty"`
+	Backoff       *BackoffConfig  `yaml:"backoff,omitempty"`
+	Environment   []string        `yaml:"environment,omitempty"`
+	User          string          `yaml:"user,omitempty"`
+	Liveness      *LivenessConfig `yaml:"liveness,omitempty"`
+}
+
+type BackoffConfig struct {
+	StartMillis       int `yaml:"start_millis,omitempty"`
+	MaxIntervalMillis int `yaml:"max_interval_millis,omitempty"`
+	MaxMillis         int `yaml:"max_millis,omitempty"`
+}
+
+// LivenessConfig runs Command every Interval seconds, and restarts the
+// process once it failed Failures times in a row. Timeout is in seconds too.
+type LivenessConfig struct {
+	Command  string `yaml:"command,omitempty"`
+	Interval int    `yaml:"interval,omitempty"`
+	Timeout  int    `yaml:"timeout,omitempty"`
+	Failures int    `yaml:"failures,omitempty"`
+}
+
+func (b *BackoffConfig) backoff() *util.Backoff {
+	backoff := &util.Backoff{
+		StartMillis:       b.StartMillis,
+		MaxIntervalMillis: b.MaxIntervalMillis,
+		MaxMillis:         b.MaxMillis,
+	}
+	if backoff.MaxIntervalMillis < backoff.StartMillis {
+		backoff.MaxIntervalMillis = backoff.StartMillis
+	}
+	if backoff.MaxMillis == 0 && (backoff.StartMillis != 0 || backoff.MaxIntervalMillis != 0) {
+		backoff.MaxMillis = 300000
+	}
+	return backoff
+}
+
+func (p *ProcessConfig) validate(name string) error {
+	if strings.TrimSpace(p.Command) == "" {
+		return fmt.Errorf("%s: no command", name)
+	}
+	switch p.Restart {
+	case "":
+		p.Restart = RestartAlways
+	case RestartAlways, RestartOnFailure, RestartNever:
+	default:
+		return fmt.Errorf("%s: unknown restart policy %q", name, p.Restart)
+	}
+	if p.StableSeconds == 0 {
+		p.StableSeconds = defaultStableSeconds
+	}
+	if l := p.Liveness; l != nil {
+		if strings.TrimSpace(l.Command) == "" {
+			return fmt.Errorf("%s: liveness check has no command", name)
+		}
+		if l.Interval <= 0 {
+			l.Interval = 30
+		}
+		if l.Timeout <= 0 {
+			l.Timeout = l.Interval
+		}
+		if l.Failures <= 0 {
+			l.Failures = 3
+		}
+	}
+	return nil
+}
+
+// parseConfig reads either the yaml format or the original format of one
+// command per line, which always restarts and gives up when a command is
+// restarted more than 10 times in a second
+func parseConfig(input []byte) (map[string]*ProcessConfig, error) {
+	cfg := Config{}
+	if err := yaml.Unmarshal(input, &cfg); err == nil && len(cfg.Processes) > 0 {
+		for name, p := range cfg.Processes {
+			if p == nil {
+				return nil, fmt.Errorf("%s: no command", name)
+			}
+			if err := p.validate(name); err != nil {
+				return nil, err
+			}
+		}
+		return cfg.Processes, nil
+	}
+
+	processes := map[string]*ProcessConfig{}
+	for _, line := range strings.Split(string(input), "\n") {
+		if strings.TrimSpace(line) == "" || strings.Index(strings.TrimSpace(line), "#") == 0 {
+			continue
+		}
+		p := &ProcessConfig{
+			Command: line,
+		}
+		p.validate(line)
+		processes[lineName(line, processes)] = p
+	}
+	return processes, nil
+}
+
+// lineName names a line by its command, and its tty for gettys
+func lineName(line string, existing map[string]*ProcessConfig) string {
+	fields := strings.Fields(line)
+	name := filepath.Base(fields[0])
+	for _, field := range fields[1:] {
+		if strings.HasPrefix(field, "tty") {
+			name = name + "-" + field
+			break
+		}
+	}
+	unique := name
+	for i := 2; existing[unique] != nil; i++ {
+		unique = fmt.Sprintf("%s-%d", name, i)
+	}
+	return unique
+}
+
+// loadConfigDir reads the yaml configs in dir, other files are expected
+// to have been added to the main config already
+func loadConfigDir(dir string, processes map[string]*ProcessConfig) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for _, f := range files {
+		if IsYAMLConfig(f.Name()) {
+			names = append(names, f.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		bytes, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		cfg := Config{}
+		if err := yaml.Unmarshal(bytes, &cfg); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		for process, p := range cfg.Processes {
+			if p == nil {
+				return fmt.Errorf("%s: %s: no command", name, process)
+			}
+			if err := p.validate(process); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			processes[process] = p
+		}
+	}
+	return nil
+}
+
+func IsYAMLConfig(name string) bool {
+	return strings.HasSuffix(name, ".yml") || strings.HasSuffix(name, ".yaml")
+}
@@ -0,0 +1,83 @@
+package respawn
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLineConfig(t *testing.T) {
+	assert := require.New(t)
+
+	processes, err := parseConfig([]byte(`/sbin/agetty --noclear tty1 linux
+# comment
+/sbin/agetty --noclear tty2 linux
+
+/usr/sbin/sshd -D
+/usr/sbin/sshd -D`))
+	assert.NoError(err)
+	assert.Len(processes, 4)
+	assert.Equal("/sbin/agetty --noclear tty1 linux", processes["agetty-tty1"].Command)
+	assert.NotNil(processes["agetty-tty2"])
+	assert.NotNil(processes["sshd"])
+	assert.NotNil(processes["sshd-2"])
+	assert.Equal(RestartAlways, processes["sshd"].Restart)
+	assert.Nil(processes["sshd"].Backoff)
+}
+
+func TestParseYAMLConfig(t *testing.T) {
+	assert := require.New(t)
+
+	processes, err := parseConfig([]byte(`processes:
+  sshd:
+    command: /usr/sbin/sshd -D
+    restart: on-failure
+    max_restarts: 5
+    environment:
+    - FOO=bar
+    user: rancher
+    backoff:
+      start_millis: 500
+    liveness:
+      command: pgrep -x sshd
+`))
+	assert.NoError(err)
+	assert.Len(processes, 1)
+
+	sshd := processes["sshd"]
+	assert.Equal(RestartOnFailure, sshd.Restart)
+	assert.Equal(5, sshd.MaxRestarts)
+	assert.Equal([]string{"FOO=bar"}, sshd.Environment)
+	assert.Equal("rancher", sshd.User)
+	assert.Equal(defaultStableSeconds, sshd.StableSeconds)
+	assert.Equal(30, sshd.Liveness.Interval)
+	assert.Equal(30, sshd.Liveness.Timeout)
+	assert.Equal(3, sshd.Liveness.Failures)
+
+	backoff := sshd.Backoff.backoff()
+	assert.Equal(500, backoff.StartMillis)
+	assert.Equal(500, backoff.MaxIntervalMillis)
+	assert.Equal(300000, backoff.MaxMillis)
+
+	_, err = parseConfig([]byte("processes:\n  bad:\n    command: /bin/true\n    restart: sometimes\n"))
+	assert.Error(err)
+}
+
+func TestRestartPolicy(t *testing.T) {
+	assert := require.New(t)
+
+	p := newProcess("false", &ProcessConfig{Command: "/bin/false", Restart: RestartOnFailure, MaxRestarts: 2, Backoff: &BackoffConfig{StartMillis: 1}})
+	assert.NoError(p.config.validate(p.name))
+	p.run()
+	status := p.Status()
+	assert.Equal(StateFailed, status.State)
+	assert.Equal(2, status.Restarts)
+	assert.Equal("exit status 1, too many restarts", status.LastExit)
+
+	p = newProcess("true", &ProcessConfig{Command: "/bin/true", Restart: RestartOnFailure})
+	assert.NoError(p.config.validate(p.name))
+	p.run()
+	status = p.Status()
+	assert.Equal(StateExited, status.State)
+	assert.Equal(0, status.Restarts)
+}
@@ -0,0 +1,86 @@
+package respawn
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rancher/os/pkg/log"
+)
+
+const ControlSocket = "/var/run/respawn.sock"
+
+// serveControl answers "status" requests on the control socket with the
+// json encoded []ProcessStatus until the returned listener is closed
+func serveControl(socket string, processes []*process) (net.Listener, error) {
+	os.Remove(socket)
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if isRunning() {
+					log.Errorf("respawn control socket: %v", err)
+				}
+				return
+			}
+			go handleControl(conn, processes)
+		}
+	}()
+	return l, nil
+}
+
+func handleControl(conn net.Conn, processes []*process) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	request, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+	switch strings.TrimSpace(request) {
+	case "status":
+		statuses := []ProcessStatus{}
+		for _, p := range processes {
+			statuses = append(statuses, p.Status())
+		}
+		json.NewEncoder(conn).Encode(statuses)
+	default:
+		fmt.Fprintf(conn, "{\"error\": %q}\n", "unknown request")
+	}
+}
+
+// Status asks the respawn listening on socket for the state of its processes
+func Status(socket string) ([]ProcessStatus, error) {
+	conn, err := net.DialTimeout("unix", socket, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if _, err := fmt.Fprintln(conn, "status"); err != nil {
+		return nil, err
+	}
+	statuses := []ProcessStatus{}
+	if err := json.NewDecoder(conn).Decode(&statuses); err != nil {
+		return nil, err
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses, nil
+}
@@ -0,0 +1,51 @@
+package respawn
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeControl(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "respawn")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "respawn.sock")
+
+	processes := []*process{
+		newProcess("sshd", &ProcessConfig{Command: "/usr/sbin/sshd -D"}),
+		newProcess("agetty-tty1", &ProcessConfig{Command: "/sbin/agetty tty1"}),
+	}
+	l, err := serveControl(socket, processes)
+	assert.NoError(err)
+
+	statuses, err := Status(socket)
+	assert.NoError(err)
+	assert.Len(statuses, 2)
+	assert.Equal("agetty-tty1", statuses[0].Name)
+	assert.Equal(StateStarting, statuses[0].State)
+
+	assert.NoError(l.Close())
+	_, err = Status(socket)
+	assert.Error(err)
+}
+
+func TestTermPidsStops(t *testing.T) {
+	assert := require.New(t)
+	resetRunning()
+	defer resetRunning()
+
+	termPids()
+	termPids()
+	assert.False(isRunning())
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("stopped was not closed")
+	}
+}
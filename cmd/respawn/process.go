@@ -0,0 +1,337 @@
+package respawn
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rancher/os/pkg/log"
+	"github.com/rancher/os/pkg/util"
+)
+
+const (
+	StateStarting   = "starting"
+	StateRunning    = "running"
+	StateBackoff    = "backoff"
+	StateExited     = "exited"
+	StateFailed     = "failed"
+	StateTerminated = "terminated"
+)
+
+// ProcessStatus is what `ros respawn status` shows of each process
+type ProcessStatus struct {
+	Name             string    `json:"name"`
+	Command          string    `json:"command"`
+	State            string    `json:"state"`
+	Pid              int       `json:"pid,omitempty"`
+	Restarts         int       `json:"restarts"`
+	Started          time.Time `json:"started,omitempty"`
+	LastExit         string    `json:"last_exit,omitempty"`
+	LivenessFailures int       `json:"liveness_failures,omitempty"`
+}
+
+type process struct {
+	name   string
+	config *ProcessConfig
+
+	lock   sync.Mutex
+	status ProcessStatus
+}
+
+func newProcess(name string, config *ProcessConfig) *process {
+	return &process{
+		name:   name,
+		config: config,
+		status: ProcessStatus{
+			Name:    name,
+			Command: config.Command,
+			State:   StateStarting,
+		},
+	}
+}
+
+func (p *process) Status() ProcessStatus {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.status
+}
+
+func (p *process) update(f func(*ProcessStatus)) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	f(&p.status)
+}
+
+func (p *process) command() (*exec.Cmd, error) {
+	args := strings.Split(p.config.Command, " ")
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid: true,
+	}
+	if len(p.config.Environment) > 0 {
+		cmd.Env = append(os.Environ(), p.config.Environment...)
+	}
+	if p.config.User != "" {
+		uid, gid, home, err := lookupUser(p.config.User)
+		if err != nil {
+			return nil, err
+		}
+		cmd.SysProcAttr.Credential = &syscall.Credential{
+			Uid: uint32(uid),
+			Gid: uint32(gid),
+		}
+		cmd.Dir = home
+	}
+	return cmd, nil
+}
+
+// run starts the process and restarts it according to its restart policy,
+// until it gives up or respawn is terminated
+func (p *process) run() {
+	start := time.Now()
+	count := 0
+	restarts := 0
+
+	var backoff *util.Backoff
+	var backoffChan <-chan bool
+	resetBackoff := func() {
+		if backoff != nil {
+			stopBackoff(backoff, backoffChan)
+			backoff = nil
+		}
+		if p.config.Backoff != nil {
+			backoff = p.config.Backoff.backoff()
+			backoffChan = backoff.Start()
+			// the first value comes right away, the next one after StartMillis
+			<-backoffChan
+		}
+	}
+	resetBackoff()
+	defer func() {
+		if backoff != nil {
+			stopBackoff(backoff, backoffChan)
+		}
+	}()
+
+	for {
+		if !isRunning() {
+			log.Infof("%s : not starting, exiting", p.config.Command)
+			p.update(func(s *ProcessStatus) { s.State = StateTerminated })
+			return
+		}
+
+		started := time.Now()
+		failed := p.runOnce()
+
+		if !isRunning() {
+			log.Infof("%s : not restarting, exiting", p.config.Command)
+			p.update(func(s *ProcessStatus) { s.State = StateTerminated })
+			return
+		}
+
+		switch p.config.Restart {
+		case RestartNever:
+			p.exit(failed, "restart policy is never")
+			return
+		case RestartOnFailure:
+			if !failed {
+				p.exit(failed, "exited successfully")
+				return
+			}
+		}
+
+		if time.Now().Sub(started) >= time.Duration(p.config.StableSeconds)*time.Second {
+			restarts = 0
+			resetBackoff()
+		}
+		restarts++
+		if p.config.MaxRestarts > 0 && restarts > p.config.MaxRestarts {
+			log.Errorf("%s : restarted %d times, not executing", p.config.Command, p.config.MaxRestarts)
+			p.exit(true, "too many restarts")
+			return
+		}
+
+		if backoff == nil {
+			count++
+			if count > 10 {
+				if time.Now().Sub(start) <= (1 * time.Second) {
+					log.Errorf("%s : restarted too fast, not executing", p.config.Command)
+					p.exit(true, "restarted too fast")
+					return
+				}
+
+				count = 0
+				start = time.Now()
+			}
+		} else {
+			p.update(func(s *ProcessStatus) { s.State = StateBackoff })
+			select {
+			case ok := <-backoffChan:
+				if !ok {
+					log.Errorf("%s : backoff timed out, not executing", p.config.Command)
+					p.exit(true, "backoff timed out")
+					return
+				}
+			case <-stopped:
+				log.Infof("%s : terminated during backoff, exiting", p.config.Command)
+				p.update(func(s *ProcessStatus) { s.State = StateTerminated })
+				return
+			}
+		}
+
+		p.update(func(s *ProcessStatus) { s.Restarts++ })
+	}
+}
+
+func (p *process) exit(failed bool, reason string) {
+	p.update(func(s *ProcessStatus) {
+		s.State = StateExited
+		if failed {
+			s.State = StateFailed
+		}
+		if s.LastExit == "" {
+			s.LastExit = reason
+		} else {
+			s.LastExit = fmt.Sprintf("%s, %s", s.LastExit, reason)
+		}
+	})
+}
+
+// runOnce runs the process until it exits and returns whether it failed
+func (p *process) runOnce() bool {
+	cmd, err := p.command()
+	if err == nil {
+		err = startProcess(cmd)
+	}
+	if err != nil {
+		log.Errorf("Start cmd: %s, err: %v", p.config.Command, err)
+		p.update(func(s *ProcessStatus) {
+			s.Pid = 0
+			s.LastExit = err.Error()
+		})
+		return true
+	}
+
+	p.update(func(s *ProcessStatus) {
+		s.State = StateRunning
+		s.Pid = cmd.Process.Pid
+		s.Started = time.Now()
+		s.LivenessFailures = 0
+	})
+
+	done := make(chan struct{})
+	if p.config.Liveness != nil {
+		go p.checkLiveness(cmd.Process, done)
+	}
+
+	err = cmd.Wait()
+	close(done)
+	removeProcess(cmd.Process)
+	if err != nil {
+		log.Errorf("Wait cmd to exit: %s, err: %v", p.config.Command, err)
+	}
+
+	p.update(func(s *ProcessStatus) {
+		s.Pid = 0
+		if err != nil {
+			s.LastExit = err.Error()
+		} else {
+			s.LastExit = "exit status 0"
+		}
+	})
+	return err != nil
+}
+
+// checkLiveness terminates the process after it failed its liveness command
+// too many times in a row
+func (p *process) checkLiveness(process *os.Process, done <-chan struct{}) {
+	liveness := p.config.Liveness
+	ticker := time.NewTicker(time.Duration(liveness.Interval) * time.Second)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		if err := runLiveness(liveness.Command, time.Duration(liveness.Timeout)*time.Second); err != nil {
+			failures++
+			log.Warnf("%s : liveness check failed (%d/%d): %v", p.name, failures, liveness.Failures, err)
+		} else {
+			failures = 0
+		}
+		p.update(func(s *ProcessStatus) { s.LivenessFailures = failures })
+
+		if failures >= liveness.Failures {
+			log.Errorf("%s : not alive, sending SIGTERM to %d", p.name, process.Pid)
+			process.Signal(syscall.SIGTERM)
+			select {
+			case <-done:
+			case <-time.After(time.Duration(liveness.Timeout) * time.Second):
+				log.Errorf("%s : still running, sending SIGKILL to %d", p.name, process.Pid)
+				process.Kill()
+			}
+			return
+		}
+	}
+}
+
+func runLiveness(command string, timeout time.Duration) error {
+	cmd := exec.Command("/bin/sh", "-c", command)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- cmd.Wait()
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+}
+
+// stopBackoff closes b, which can be blocked sending to c, or sleeping for
+// as long as its interval, so it is not waited for
+func stopBackoff(b *util.Backoff, c <-chan bool) {
+	go func() {
+		for range c {
+		}
+	}()
+	go b.Close()
+}
+
+func lookupUser(name string) (uid, gid int, home string, err error) {
+	bytes, err := ioutil.ReadFile("/etc/passwd")
+	if err != nil {
+		return 0, 0, "", err
+	}
+	for _, line := range strings.Split(string(bytes), "\n") {
+		split := strings.Split(line, ":")
+		if len(split) < 6 || split[0] != name {
+			continue
+		}
+		if uid, err = strconv.Atoi(split[2]); err != nil {
+			return 0, 0, "", err
+		}
+		if gid, err = strconv.Atoi(split[3]); err != nil {
+			return 0, 0, "", err
+		}
+		return uid, gid, split[5], nil
+	}
+	return 0, 0, "", fmt.Errorf("user %s not found", name)
+}
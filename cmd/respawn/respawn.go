@@ -1,6 +1,7 @@
 package respawn
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -8,10 +9,9 @@ import (
 	"os/exec"
 	"os/signal"
 	"runtime"
-	"strings"
+	"sort"
 	"sync"
 	"syscall"
-	"time"
 
 	"github.com/rancher/os/config"
 	"github.com/rancher/os/pkg/log"
@@ -20,7 +20,10 @@ import (
 )
 
 var (
+	errTerminating = errors.New("respawn is terminating")
+
 	running     = true
+	stopped     = make(chan struct{})
 	processes   = map[int]*os.Process{}
 	processLock = sync.Mutex{}
 )
@@ -29,6 +32,15 @@ func Main() {
 	log.InitLogger()
 	runtime.GOMAXPROCS(1)
 	runtime.LockOSThread()
+	app := newApp()
+
+	log.Infof("%s, %s", app.Usage, app.Version)
+	fmt.Printf("%s, %s", app.Usage, app.Version)
+
+	app.Run(os.Args)
+}
+
+func newApp() *cli.App {
 	app := cli.NewApp()
 
 	app.Name = os.Args[0]
@@ -41,27 +53,38 @@ func Main() {
 			Name:  "file, f",
 			Usage: "Optional config file to load",
 		},
+		cli.StringFlag{
+			Name:  "dir, d",
+			Usage: "Optional directory of yaml config files to load",
+		},
+		cli.StringFlag{
+			Name:  "socket",
+			Usage: "Control socket for ros respawn status",
+			Value: ControlSocket,
+		},
 	}
 	app.Action = run
-
-	log.Infof("%s, %s", app.Usage, app.Version)
-	fmt.Printf("%s, %s", app.Usage, app.Version)
-
-	app.Run(os.Args)
+	return app
 }
 
-func setupSigterm() {
-	sigtermChan := make(chan os.Signal)
+// setupSigterm terminates the processes on SIGTERM, until the returned
+// func is called
+func setupSigterm() func() {
+	sigtermChan := make(chan os.Signal, 1)
 	signal.Notify(sigtermChan, syscall.SIGTERM)
 	go func() {
 		for range sigtermChan {
 			termPids()
 		}
 	}()
+	return func() {
+		signal.Stop(sigtermChan)
+		close(sigtermChan)
+	}
 }
 
 func run(c *cli.Context) error {
-	setupSigterm()
+	defer setupSigterm()()
 
 	var stream io.Reader = os.Stdin
 	var err error
@@ -80,28 +103,70 @@ func run(c *cli.Context) error {
 		panic(err)
 	}
 
-	lines := strings.Split(string(input), "\n")
-	doneChannel := make(chan string, len(lines))
+	configs, err := parseConfig(input)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if dir := c.String("dir"); dir != "" {
+		if err := loadConfigDir(dir, configs); err != nil && !os.IsNotExist(err) {
+			log.Errorf("Failed to load %s: %v", dir, err)
+		}
+	}
+
+	names := []string{}
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-	for _, line := range lines {
-		if strings.TrimSpace(line) == "" || strings.Index(strings.TrimSpace(line), "#") == 0 {
-			continue
+	processes := []*process{}
+	for _, name := range names {
+		processes = append(processes, newProcess(name, configs[name]))
+	}
+
+	if socket := c.String("socket"); socket != "" {
+		l, err := serveControl(socket, processes)
+		if err != nil {
+			log.Errorf("Failed to listen on %s: %v", socket, err)
+		} else {
+			defer os.Remove(socket)
+			defer l.Close()
 		}
-		go execute(line, doneChannel)
 	}
 
-	for i := 0; i < len(lines); i++ {
+	doneChannel := make(chan string, len(processes))
+	for _, p := range processes {
+		go func(p *process) {
+			defer func() { doneChannel <- p.config.Command }()
+			p.run()
+		}(p)
+	}
+
+	for range processes {
 		line := <-doneChannel
 		log.Infof("FINISHED: %s", line)
 		fmt.Printf("FINISHED: %s", line)
 	}
+
+	// keep answering status requests for the processes that gave up until
+	// we are told to stop
+	<-stopped
 	return nil
 }
 
-func addProcess(process *os.Process) {
+// startProcess starts cmd and registers it for termPids, unless respawn is
+// terminating. Both happen under processLock, so termPids can't miss it.
+func startProcess(cmd *exec.Cmd) error {
 	processLock.Lock()
 	defer processLock.Unlock()
-	processes[process.Pid] = process
+	if !running {
+		return errTerminating
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	processes[cmd.Process.Pid] = cmd.Process
+	return nil
 }
 
 func removeProcess(process *os.Process) {
@@ -110,58 +175,22 @@ func removeProcess(process *os.Process) {
 	delete(processes, process.Pid)
 }
 
+func isRunning() bool {
+	processLock.Lock()
+	defer processLock.Unlock()
+	return running
+}
+
 func termPids() {
-	running = false
 	processLock.Lock()
 	defer processLock.Unlock()
+	if running {
+		running = false
+		close(stopped)
+	}
 
 	for _, process := range processes {
 		log.Infof("sending SIGTERM to %d", process.Pid)
 		process.Signal(syscall.SIGTERM)
 	}
 }
-
-func execute(line string, doneChannel chan string) {
-	defer func() { doneChannel <- line }()
-
-	start := time.Now()
-	count := 0
-
-	args := strings.Split(line, " ")
-
-	for {
-		cmd := exec.Command(args[0], args[1:]...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmd.SysProcAttr = &syscall.SysProcAttr{
-			Setsid: true,
-		}
-
-		if err := cmd.Start(); err == nil {
-			addProcess(cmd.Process)
-			if err = cmd.Wait(); err != nil {
-				log.Errorf("Wait cmd to exit: %s, err: %v", line, err)
-			}
-			removeProcess(cmd.Process)
-		} else {
-			log.Errorf("Start cmd: %s, err: %v", line, err)
-		}
-
-		if !running {
-			log.Infof("%s : not restarting, exiting", line)
-			break
-		}
-
-		count++
-
-		if count > 10 {
-			if time.Now().Sub(start) <= (1 * time.Second) {
-				log.Errorf("%s : restarted too fast, not executing", line)
-				break
-			}
-
-			count = 0
-			start = time.Now()
-		}
-	}
-}
@@ -0,0 +1,65 @@
+package respawn
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// resetRunning undoes termPids for the next test
+func resetRunning() {
+	processLock.Lock()
+	defer processLock.Unlock()
+	running = true
+	stopped = make(chan struct{})
+}
+
+func TestSigtermDuringBackoff(t *testing.T) {
+	assert := require.New(t)
+	resetRunning()
+	defer resetRunning()
+
+	dir, err := ioutil.TempDir("", "respawn")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "respawn.conf")
+	socket := filepath.Join(dir, "respawn.sock")
+	assert.NoError(ioutil.WriteFile(file, []byte(`processes:
+  false:
+    command: /bin/false
+    backoff:
+      start_millis: 60000
+`), 0644))
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- newApp().Run([]string{"respawn", "--file", file, "--socket", socket})
+	}()
+
+	inBackoff := func() bool {
+		statuses, err := Status(socket)
+		return err == nil && len(statuses) == 1 && statuses[0].State == StateBackoff
+	}
+	for i := 0; !inBackoff(); i++ {
+		if i == 100 {
+			t.Fatal("the process did not back off")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	assert.NoError(syscall.Kill(os.Getpid(), syscall.SIGTERM))
+	select {
+	case err := <-exited:
+		assert.NoError(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("respawn did not exit after SIGTERM")
+	}
+
+	_, err = Status(socket)
+	assert.Error(err)
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rancher/os/pkg/log"
+	"github.com/rancher/os/pkg/util"
+)
+
+const (
+	BootEventService    = "service"
+	BootEventDatasource = "datasource"
+)
+
+// BootEvent is something that took time during boot outside of init itself,
+// like starting a system service or waiting for a cloud-init datasource.
+// Events are appended by whichever container they happen in, so each one
+// carries the kernel boot id to tell this boot from the previous ones.
+type BootEvent struct {
+	BootID   string        `json:"boot_id"`
+	Kind     string        `json:"kind"`
+	Name     string        `json:"name"`
+	Detail   string        `json:"detail,omitempty"`
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+func BootID() string {
+	bytes, err := ioutil.ReadFile("/proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(bytes))
+}
+
+// MarkBooting records in filename that this boot is in progress, until
+// MarkBooted, so that the services started later on are not boot events
+func MarkBooting(filename string) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(filename, []byte(BootID()), 0644)
+}
+
+// MarkBooted ends the boot started by MarkBooting
+func MarkBooted(filename string) error {
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Booting is whether this boot was marked by MarkBooting and is not over
+func Booting(filename string) bool {
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return false
+	}
+	bootID := BootID()
+	return bootID != "" && strings.TrimSpace(string(bytes)) == bootID
+}
+
+// RecordBootEvent appends an event that started at start and ended now to
+// BootEventsFile. Failing to record is never fatal to what was recorded.
+func RecordBootEvent(kind, name, detail string, start time.Time, err error) {
+	end := time.Now()
+	event := BootEvent{
+		BootID:   BootID(),
+		Kind:     kind,
+		Name:     name,
+		Detail:   detail,
+		Start:    start,
+		End:      end,
+		Duration: end.Sub(start),
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	if err := appendBootEvent(BootEventsFile, event); err != nil {
+		log.Debugf("Failed to record boot event %s %s: %v", kind, name, err)
+	}
+}
+
+func appendBootEvent(filename string, event BootEvent) error {
+	bytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// a single short write with O_APPEND doesn't interleave with other writers
+	_, err = f.Write(append(bytes, '\n'))
+	return err
+}
+
+// ReadBootEvents returns the events recorded during the boot with bootID,
+// or all of them if bootID is empty
+func ReadBootEvents(filename, bootID string) ([]BootEvent, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	events := []BootEvent{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		event := BootEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		if bootID == "" || event.BootID == bootID {
+			events = append(events, event)
+		}
+	}
+	return events, scanner.Err()
+}
+
+// PruneBootEvents drops the events of previous boots
+func PruneBootEvents(filename, bootID string) error {
+	events, err := ReadBootEvents(filename, bootID)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	lines := []string{}
+	for _, event := range events {
+		bytes, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, string(bytes)+"\n")
+	}
+	return util.WriteFileAtomic(filename, []byte(strings.Join(lines, "")), 0644)
+}
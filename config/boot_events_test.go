@@ -0,0 +1,33 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBooting(t *testing.T) {
+	assert := require.New(t)
+	if BootID() == "" {
+		t.Skip("no boot id")
+	}
+
+	dir, err := ioutil.TempDir("", "boot")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "run", "rancheros-booting")
+
+	assert.False(Booting(file))
+	assert.NoError(MarkBooting(file))
+	assert.True(Booting(file))
+	assert.NoError(MarkBooted(file))
+	assert.False(Booting(file))
+	assert.NoError(MarkBooted(file))
+
+	// a stamp left over from a previous boot
+	assert.NoError(ioutil.WriteFile(file, []byte("5a0b9a87-0c1b-4b3a-9a51-8e0b6c0f8d1e\n"), 0644))
+	assert.False(Booting(file))
+}
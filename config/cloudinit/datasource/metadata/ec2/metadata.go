@@ -19,6 +19,7 @@ import (
 	"log"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/rancher/os/config/cloudinit/datasource"
 	"github.com/rancher/os/config/cloudinit/datasource/metadata"
@@ -32,6 +33,13 @@ const (
 	userdataPath   = apiVersion + "user-data"
 	metadataPath   = apiVersion + "meta-data/"
 
+	// IMDSv2 session tokens, see
+	// https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/configuring-instance-metadata-service.html
+	tokenPath      = apiVersion + "api/token"
+	tokenHeader    = "X-aws-ec2-metadata-token"
+	tokenTTLHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+	tokenTTL       = 6 * time.Hour
+
 	defaultXVRootDisk   = "/dev/xvda"
 	defaultNVMeRootDisk = "/dev/nvme0n1"
 )
@@ -44,11 +52,15 @@ type MetadataService struct {
 	metadata.Service
 }
 
-func NewDatasource(root string) *MetadataService {
+// NewDatasource creates the EC2 datasource, which uses IMDSv2 session
+// tokens. Without v1Fallback, it fails when it can't get any.
+func NewDatasource(root string, v1Fallback bool) *MetadataService {
 	if root == "" {
 		root = DefaultAddress
 	}
-	return &MetadataService{metadata.NewDatasource(root, apiVersion, userdataPath, metadataPath, nil)}
+	service := metadata.NewDatasource(root, apiVersion, userdataPath, metadataPath, nil)
+	service.SetSession(metadata.NewTokenSession(service.Root+tokenPath, tokenHeader, tokenTTLHeader, tokenTTL, v1Fallback))
+	return &MetadataService{service}
 }
 
 func (ms MetadataService) AvailabilityChanges() bool {
@@ -17,6 +17,8 @@ package ec2
 import (
 	"fmt"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 
@@ -130,6 +132,46 @@ func TestFetchMetadata(t *testing.T) {
 	}
 }
 
+func TestIMDSv2(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "PUT" && r.URL.Path == "/latest/api/token":
+			if r.Header.Get(tokenTTLHeader) != "21600" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte("token"))
+		case r.Header.Get(tokenHeader) != "token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/latest/":
+			w.Write([]byte("meta-data\nuser-data\n"))
+		case r.URL.Path == "/latest/meta-data/hostname":
+			w.Write([]byte("host"))
+		case r.URL.Path == "/latest/user-data":
+			w.Write([]byte("#cloud-config\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	service := NewDatasource(server.URL, false)
+	if !service.IsAvailable() {
+		t.Fatalf("bad isAvailable: want true, got false")
+	}
+	userdata, err := service.FetchUserdata()
+	if err != nil || string(userdata) != "#cloud-config\n" {
+		t.Fatalf("bad userdata: want %q, got %q (%v)", "#cloud-config\n", userdata, err)
+	}
+	metadata, err := service.FetchMetadata()
+	if err != nil {
+		t.Fatalf("bad error: %v", err)
+	}
+	if metadata.Hostname != "host" {
+		t.Fatalf("bad hostname: want %q, got %q", "host", metadata.Hostname)
+	}
+}
+
 func Error(err error) string {
 	if err != nil {
 		return err.Error()
@@ -0,0 +1,398 @@
+package openstack
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/os/config/cloudinit/datasource"
+	"github.com/rancher/os/config/cloudinit/datasource/metadata"
+	"github.com/rancher/os/pkg/log"
+	"github.com/rancher/os/pkg/netconf"
+)
+
+// see https://docs.openstack.org/nova/latest/user/metadata.html
+const (
+	DefaultAddress  = "http://169.254.169.254/"
+	apiVersion      = "openstack/latest/"
+	userdataPath    = apiVersion + "user_data"
+	metadataPath    = apiVersion + "meta_data.json"
+	networkDataPath = apiVersion + "network_data.json"
+	vendorDataPath  = apiVersion + "vendor_data.json"
+)
+
+type Metadata struct {
+	Hostname   string            `json:"hostname"`
+	PublicKeys map[string]string `json:"public_keys"`
+}
+
+type NetworkData struct {
+	Links    []Link    `json:"links"`
+	Networks []Network `json:"networks"`
+	Services []Service `json:"services"`
+}
+
+type Link struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	MAC       string   `json:"ethernet_mac_address"`
+	MTU       int      `json:"mtu"`
+	BondLinks []string `json:"bond_links"`
+	VlanLink  string   `json:"vlan_link"`
+	VlanID    int      `json:"vlan_id"`
+	// the bond_ options other than bond_links, like bond_mode and bond_miimon
+	BondOpts map[string]string `json:"-"`
+}
+
+type Network struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Link      string    `json:"link"`
+	IPAddress string    `json:"ip_address"`
+	Netmask   string    `json:"netmask"`
+	Routes    []Route   `json:"routes"`
+	Services  []Service `json:"services"`
+}
+
+type Route struct {
+	Network string `json:"network"`
+	Netmask string `json:"netmask"`
+	Gateway string `json:"gateway"`
+}
+
+type Service struct {
+	Type    string `json:"type"`
+	Address string `json:"address"`
+}
+
+type MetadataService struct {
+	metadata.Service
+}
+
+func NewDatasource(root string) *MetadataService {
+	if root == "" {
+		root = DefaultAddress
+	}
+	return &MetadataService{metadata.NewDatasourceWithCheckPath(root, apiVersion, metadataPath, userdataPath, metadataPath, nil)}
+}
+
+func (ms MetadataService) FetchMetadata() (metadata datasource.Metadata, err error) {
+	var data []byte
+	var m Metadata
+
+	if data, err = ms.FetchData(ms.MetadataURL()); err != nil || len(data) == 0 {
+		return
+	}
+	if err = json.Unmarshal(data, &m); err != nil {
+		return
+	}
+	metadata.Hostname = m.Hostname
+	metadata.SSHPublicKeys = m.PublicKeys
+
+	if data, err = ms.FetchData(ms.Root + networkDataPath); err != nil || len(data) == 0 {
+		return
+	}
+	var n NetworkData
+	if n, err = parseNetworkData(data); err != nil {
+		return
+	}
+	metadata.NetworkConfig = n.NetworkConfig()
+	return
+}
+
+// FetchUserdata returns the user_data, preceded by the cloud-init part of
+// the vendor_data.json, if any, which the user_data can then override
+func (ms MetadataService) FetchUserdata() ([]byte, error) {
+	userdata, err := ms.FetchData(ms.UserdataURL())
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ms.FetchData(ms.Root + vendorDataPath)
+	if err != nil {
+		log.Errorf("Failed to fetch vendor_data.json: %v", err)
+		return userdata, nil
+	}
+	vendordata := vendorCloudInit(data)
+	if len(vendordata) == 0 {
+		return userdata, nil
+	}
+	if len(userdata) == 0 {
+		return vendordata, nil
+	}
+	return multipartUserdata(vendordata, userdata)
+}
+
+func (ms MetadataService) Type() string {
+	return "openstack-metadata-service"
+}
+
+// vendorCloudInit returns the cloud-init part of vendor_data.json, which is
+// either a string or the cloud-init key of an object
+func vendorCloudInit(data []byte) []byte {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	var vendordata interface{}
+	if err := json.Unmarshal(data, &vendordata); err != nil {
+		log.Errorf("Failed to parse vendor_data.json: %v", err)
+		return nil
+	}
+	if m, ok := vendordata.(map[string]interface{}); ok {
+		vendordata = m["cloud-init"]
+	}
+	if s, ok := vendordata.(string); ok {
+		return []byte(s)
+	}
+	return nil
+}
+
+// multipartUserdata joins the parts into a multipart MIME message, which
+// cloud-init-save merges in order
+func multipartUserdata(parts ...[]byte) ([]byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "text/plain")
+		header.Set("Content-Transfer-Encoding", "base64")
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(base64Lines(part))); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%q\n", writer.Boundary())
+	buf.WriteString("MIME-Version: 1.0\n\n")
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
+
+func parseNetworkData(data []byte) (NetworkData, error) {
+	var n NetworkData
+	if err := json.Unmarshal(data, &n); err != nil {
+		return n, err
+	}
+
+	var raw struct {
+		Links []map[string]interface{} `json:"links"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return n, err
+	}
+	for i, link := range raw.Links {
+		for key, value := range link {
+			if !strings.HasPrefix(key, "bond_") || key == "bond_links" || value == nil {
+				continue
+			}
+			if n.Links[i].BondOpts == nil {
+				n.Links[i].BondOpts = map[string]string{}
+			}
+			n.Links[i].BondOpts[strings.TrimPrefix(key, "bond_")] = fmt.Sprint(value)
+		}
+	}
+	return n, nil
+}
+
+// NetworkConfig translates the links, bonds, vlans, networks and routes
+func (n NetworkData) NetworkConfig() netconf.NetworkConfig {
+	cfg := netconf.NetworkConfig{
+		Interfaces: map[string]netconf.InterfaceConfig{},
+	}
+
+	names := map[string]string{}
+	for i, link := range n.Links {
+		names[link.ID] = linkName(link, i)
+	}
+
+	for _, link := range n.Links {
+		name := names[link.ID]
+		iface := cfg.Interfaces[name]
+		iface.MTU = link.MTU
+		switch link.Type {
+		case "bond":
+			iface.BondOpts = link.BondOpts
+			for _, slave := range link.BondLinks {
+				slaveIface := cfg.Interfaces[names[slave]]
+				slaveIface.Bond = name
+				cfg.Interfaces[names[slave]] = slaveIface
+			}
+		case "vlan":
+			parent := cfg.Interfaces[names[link.VlanLink]]
+			vlan := fmt.Sprintf("%d:%s", link.VlanID, name)
+			if parent.Vlans == "" {
+				parent.Vlans = vlan
+			} else {
+				parent.Vlans += "," + vlan
+			}
+			cfg.Interfaces[names[link.VlanLink]] = parent
+		default:
+			if link.MAC != "" {
+				iface.Match = "mac=" + link.MAC
+			}
+		}
+		cfg.Interfaces[name] = iface
+	}
+
+	for _, network := range n.Networks {
+		name, ok := names[network.Link]
+		if !ok {
+			log.Errorf("Ignoring network %s on unknown link %s", network.ID, network.Link)
+			continue
+		}
+		iface := cfg.Interfaces[name]
+		if err := applyNetwork(&iface, network); err != nil {
+			log.Errorf("Ignoring network %s: %v", network.ID, err)
+			continue
+		}
+		cfg.Interfaces[name] = iface
+
+		for _, service := range network.Services {
+			cfg.DNS.Nameservers = appendNameserver(cfg.DNS.Nameservers, service)
+		}
+	}
+
+	for _, service := range n.Services {
+		cfg.DNS.Nameservers = appendNameserver(cfg.DNS.Nameservers, service)
+	}
+	return cfg
+}
+
+func applyNetwork(iface *netconf.InterfaceConfig, network Network) error {
+	switch network.Type {
+	case "ipv4_dhcp":
+		iface.DHCP = true
+		return nil
+	case "ipv6_dhcp", "ipv6_dhcpv6-stateful":
+		iface.IPv6.Mode = netconf.IPv6DHCP
+		return nil
+	case "ipv6_slaac", "ipv6_dhcpv6-stateless":
+		iface.IPv6.Mode = netconf.IPv6SLAAC
+		return nil
+	case "ipv4", "ipv6":
+	default:
+		return fmt.Errorf("unsupported type %s", network.Type)
+	}
+
+	address, err := cidr(network.IPAddress, network.Netmask)
+	if err != nil {
+		return err
+	}
+	ipv6 := network.Type == "ipv6"
+	if ipv6 {
+		iface.IPv6.Mode = netconf.IPv6Static
+		iface.IPv6.Addresses = append(iface.IPv6.Addresses, address)
+	} else {
+		iface.Addresses = append(iface.Addresses, address)
+	}
+
+	for _, route := range network.Routes {
+		destination, err := cidr(route.Network, route.Netmask)
+		if err != nil {
+			return err
+		}
+		isDefault := strings.HasSuffix(destination, "/0")
+		switch {
+		case isDefault && ipv6:
+			iface.IPv6.Gateway = route.Gateway
+		case isDefault:
+			iface.Gateway = route.Gateway
+		case ipv6:
+			iface.IPv6.Routes = append(iface.IPv6.Routes, netconf.RouteConfig{Destination: destination, Gateway: route.Gateway})
+		default:
+			iface.Routes = append(iface.Routes, netconf.RouteConfig{Destination: destination, Gateway: route.Gateway})
+		}
+	}
+	return nil
+}
+
+// cidr joins an address and its netmask, which is either dotted, hex for
+// ipv6, or a prefix length. Addresses can also already have one.
+func cidr(address, netmask string) (string, error) {
+	if strings.Contains(address, "/") {
+		if _, _, err := net.ParseCIDR(address); err != nil {
+			return "", err
+		}
+		return address, nil
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return "", fmt.Errorf("invalid address %q", address)
+	}
+
+	ones := 0
+	if prefix, err := strconv.Atoi(strings.TrimPrefix(netmask, "/")); err == nil {
+		ones = prefix
+	} else if mask := net.ParseIP(netmask); mask != nil {
+		if ip.To4() != nil {
+			mask = mask.To4()
+		}
+		if mask == nil {
+			return "", fmt.Errorf("invalid netmask %q for %s", netmask, address)
+		}
+		ones, _ = net.IPMask(mask).Size()
+	} else {
+		return "", fmt.Errorf("invalid netmask %q", netmask)
+	}
+	return fmt.Sprintf("%s/%d", ip, ones), nil
+}
+
+// linkName is the name of the bonds and vlans to create, and the key of the
+// others in the interfaces, which are matched by mac
+func linkName(link Link, i int) string {
+	name := link.Name
+	if name == "" {
+		name = link.ID
+	}
+	if validName(name) {
+		return name
+	}
+	switch link.Type {
+	case "bond":
+		return fmt.Sprintf("bond%d", i)
+	case "vlan":
+		return fmt.Sprintf("vlan%d", link.VlanID)
+	}
+	return fmt.Sprintf("link%d", i)
+}
+
+func validName(name string) bool {
+	return name != "" && len(name) < 16 && !strings.ContainsAny(name, "/: \t\n")
+}
+
+func appendNameserver(nameservers []string, service Service) []string {
+	if service.Type != "dns" || service.Address == "" {
+		return nameservers
+	}
+	for _, nameserver := range nameservers {
+		if nameserver == service.Address {
+			return nameservers
+		}
+	}
+	return append(nameservers, service.Address)
+}
+
+func base64Lines(data []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	lines := []string{}
+	for len(encoded) > 76 {
+		lines = append(lines, encoded[:76])
+		encoded = encoded[76:]
+	}
+	lines = append(lines, encoded)
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,148 @@
+package openstack
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rancher/os/config/cloudinit/datasource/metadata"
+	"github.com/rancher/os/config/cloudinit/datasource/metadata/test"
+	"github.com/rancher/os/pkg/netconf"
+)
+
+const networkData = `{
+  "links": [
+    {"id": "tap1", "type": "phy", "ethernet_mac_address": "fa:16:3e:00:00:01", "mtu": 9000},
+    {"id": "tap2", "type": "phy", "ethernet_mac_address": "fa:16:3e:00:00:02", "mtu": 9000},
+    {"id": "tap3", "type": "ovs", "ethernet_mac_address": "fa:16:3e:00:00:03", "mtu": null},
+    {"id": "bond0", "type": "bond", "bond_links": ["tap1", "tap2"], "bond_mode": "802.3ad", "bond_miimon": 100, "bond_xmit_hash_policy": "layer3+4"},
+    {"id": "a-very-long-vlan-link-id", "type": "vlan", "vlan_link": "bond0", "vlan_id": 101}
+  ],
+  "networks": [
+    {
+      "id": "network0", "type": "ipv4", "link": "bond0",
+      "ip_address": "10.0.0.5", "netmask": "255.255.255.0",
+      "routes": [
+        {"network": "0.0.0.0", "netmask": "0.0.0.0", "gateway": "10.0.0.1"},
+        {"network": "10.1.0.0", "netmask": "255.255.0.0", "gateway": "10.0.0.254"}
+      ],
+      "services": [{"type": "dns", "address": "10.0.0.2"}]
+    },
+    {
+      "id": "network1", "type": "ipv6", "link": "a-very-long-vlan-link-id",
+      "ip_address": "2001:db8::5", "netmask": "ffff:ffff:ffff:ffff::",
+      "routes": [{"network": "::", "netmask": "::", "gateway": "2001:db8::1"}]
+    },
+    {"id": "network2", "type": "ipv4_dhcp", "link": "tap3"},
+    {"id": "network3", "type": "ipv6_slaac", "link": "tap3"}
+  ],
+  "services": [{"type": "dns", "address": "8.8.8.8"}, {"type": "dns", "address": "10.0.0.2"}]
+}`
+
+func TestType(t *testing.T) {
+	want := "openstack-metadata-service"
+	if kind := (MetadataService{}).Type(); kind != want {
+		t.Fatalf("bad type: want %q, got %q", want, kind)
+	}
+}
+
+func TestNetworkConfig(t *testing.T) {
+	n, err := parseNetworkData([]byte(networkData))
+	if err != nil {
+		t.Fatalf("bad error: %v", err)
+	}
+	expect := netconf.NetworkConfig{
+		DNS: netconf.DNSConfig{
+			Nameservers: []string{"10.0.0.2", "8.8.8.8"},
+		},
+		Interfaces: map[string]netconf.InterfaceConfig{
+			"tap1": {Match: "mac=fa:16:3e:00:00:01", MTU: 9000, Bond: "bond0"},
+			"tap2": {Match: "mac=fa:16:3e:00:00:02", MTU: 9000, Bond: "bond0"},
+			"tap3": {
+				Match: "mac=fa:16:3e:00:00:03",
+				DHCP:  true,
+				IPv6:  netconf.IPv6Config{Mode: netconf.IPv6SLAAC},
+			},
+			"bond0": {
+				BondOpts:  map[string]string{"mode": "802.3ad", "miimon": "100", "xmit_hash_policy": "layer3+4"},
+				Vlans:     "101:vlan101",
+				Addresses: []string{"10.0.0.5/24"},
+				Gateway:   "10.0.0.1",
+				Routes:    []netconf.RouteConfig{{Destination: "10.1.0.0/16", Gateway: "10.0.0.254"}},
+			},
+			"vlan101": {
+				IPv6: netconf.IPv6Config{
+					Mode:      netconf.IPv6Static,
+					Addresses: []string{"2001:db8::5/64"},
+					Gateway:   "2001:db8::1",
+				},
+			},
+		},
+	}
+	if cfg := n.NetworkConfig(); !reflect.DeepEqual(expect, cfg) {
+		t.Fatalf("bad network config: \nwant %#v, \ngot %#v", expect, cfg)
+	}
+}
+
+func TestFetchMetadata(t *testing.T) {
+	service := &MetadataService{metadata.Service{
+		Root: "/",
+		Client: &test.HTTPClient{Resources: map[string]string{
+			"/openstack/latest/meta_data.json":    `{"uuid": "1", "hostname": "host.novalocal", "public_keys": {"mykey": "ssh-rsa AAAA"}}`,
+			"/openstack/latest/network_data.json": `{"links": [{"id": "tap1", "type": "phy", "ethernet_mac_address": "fa:16:3e:00:00:01"}], "networks": [{"id": "network0", "type": "ipv4_dhcp", "link": "tap1"}]}`,
+		}},
+		MetadataPath: metadataPath,
+	}}
+	metadata, err := service.FetchMetadata()
+	if err != nil {
+		t.Fatalf("bad error: %v", err)
+	}
+	if metadata.Hostname != "host.novalocal" {
+		t.Fatalf("bad hostname: want %q, got %q", "host.novalocal", metadata.Hostname)
+	}
+	if !reflect.DeepEqual(map[string]string{"mykey": "ssh-rsa AAAA"}, metadata.SSHPublicKeys) {
+		t.Fatalf("bad keys: %v", metadata.SSHPublicKeys)
+	}
+	expect := map[string]netconf.InterfaceConfig{"tap1": {Match: "mac=fa:16:3e:00:00:01", DHCP: true}}
+	if !reflect.DeepEqual(expect, metadata.NetworkConfig.Interfaces) {
+		t.Fatalf("bad interfaces: \nwant %#v, \ngot %#v", expect, metadata.NetworkConfig.Interfaces)
+	}
+}
+
+func TestFetchUserdata(t *testing.T) {
+	for _, tt := range []struct {
+		resources map[string]string
+		expect    []string
+	}{
+		{
+			resources: map[string]string{"/openstack/latest/user_data": "#cloud-config\nhostname: a\n"},
+			expect:    []string{"#cloud-config\nhostname: a\n"},
+		},
+		{
+			resources: map[string]string{"/openstack/latest/vendor_data.json": `{"cloud-init": "#cloud-config\nhostname: v\n"}`},
+			expect:    []string{"#cloud-config\nhostname: v\n"},
+		},
+		{
+			resources: map[string]string{
+				"/openstack/latest/user_data":        "#cloud-config\nhostname: a\n",
+				"/openstack/latest/vendor_data.json": `"#cloud-config\nhostname: v\n"`,
+			},
+			expect: []string{"Content-Type: multipart/mixed", "I2Nsb3VkLWNvbmZpZwpob3N0bmFtZTogdgo=", "I2Nsb3VkLWNvbmZpZwpob3N0bmFtZTogYQo="},
+		},
+	} {
+		service := &MetadataService{metadata.Service{
+			Root:         "/",
+			Client:       &test.HTTPClient{Resources: tt.resources},
+			UserdataPath: userdataPath,
+		}}
+		userdata, err := service.FetchUserdata()
+		if err != nil {
+			t.Fatalf("bad error (%q): %v", tt.resources, err)
+		}
+		for _, expect := range tt.expect {
+			if !strings.Contains(string(userdata), expect) {
+				t.Fatalf("bad userdata (%q): want %q in %q", tt.resources, expect, userdata)
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package metadata
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/os/config/cloudinit/pkg"
+	"github.com/rancher/os/pkg/log"
+)
+
+// tokens are renewed this long before they expire, so they don't while a request is on its way
+const tokenRenewMargin = time.Minute
+
+// TokenSession gets a session token with a PUT, the way EC2's IMDSv2 does,
+// and sends it in a header of every request of the Service it is set on.
+type TokenSession struct {
+	// URL to PUT to, to get a token
+	URL string
+	// TokenHeader carries the token in the requests
+	TokenHeader string
+	// TTLHeader carries the TTL, in seconds, in the PUT
+	TTLHeader string
+	TTL       time.Duration
+	// V1Fallback makes requests without a token when the metadata service
+	// doesn't give any, rather than failing them
+	V1Fallback bool
+
+	client  *http.Client
+	now     func() time.Time
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+	v1      bool
+}
+
+func NewTokenSession(url, tokenHeader, ttlHeader string, ttl time.Duration, v1Fallback bool) *TokenSession {
+	return &TokenSession{
+		URL:         url,
+		TokenHeader: tokenHeader,
+		TTLHeader:   ttlHeader,
+		TTL:         ttl,
+		V1Fallback:  v1Fallback,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		now:         time.Now,
+	}
+}
+
+// SetSession makes all the requests of the service carry the session's credentials
+func (ms *Service) SetSession(session pkg.Session) {
+	if client, ok := ms.Client.(*pkg.HTTPClient); ok {
+		client.Session = session
+	}
+}
+
+func (s *TokenSession) Authorize(request *http.Request) error {
+	token, err := s.Token()
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		request.Header.Set(s.TokenHeader, token)
+	}
+	return nil
+}
+
+func (s *TokenSession) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = ""
+}
+
+// Token returns the current token, getting a new one when it is about to
+// expire. It is empty when falling back to requests without a token.
+func (s *TokenSession) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.v1 {
+		return "", nil
+	}
+	if s.token != "" && s.now().Add(tokenRenewMargin).Before(s.expires) {
+		return s.token, nil
+	}
+
+	expires := s.now().Add(s.TTL)
+	token, err := s.fetchToken()
+	if err != nil {
+		if !s.V1Fallback {
+			return "", err
+		}
+		switch err.(type) {
+		case pkg.ErrNetwork, pkg.ErrServer:
+			// try again with the next request
+			log.Warnf("Failed to get a metadata session token, making the request without: %v", err)
+			return "", nil
+		}
+		log.Warnf("Failed to get a metadata session token, falling back to requests without: %v", err)
+		s.v1 = true
+		return "", nil
+	}
+	s.token = token
+	s.expires = expires
+	return s.token, nil
+}
+
+func (s *TokenSession) fetchToken() (string, error) {
+	request, err := http.NewRequest("PUT", s.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set(s.TTLHeader, strconv.Itoa(int(s.TTL/time.Second)))
+
+	resp, err := s.client.Do(request)
+	if err != nil {
+		return "", pkg.ErrNetwork{Err: fmt.Errorf("Unable to get token: %s", err.Error())}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 5 {
+		return "", pkg.ErrServer{Err: fmt.Errorf("Unable to get token. HTTP status code: %d", resp.StatusCode)}
+	}
+	if resp.StatusCode/100 != pkg.HTTP2xx {
+		return "", fmt.Errorf("Unable to get token. HTTP status code: %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", pkg.ErrNetwork{Err: fmt.Errorf("Unable to get token: %s", err.Error())}
+	}
+	token := strings.TrimSpace(string(body))
+	if token == "" {
+		return "", fmt.Errorf("Unable to get token: empty response")
+	}
+	return token, nil
+}
@@ -0,0 +1,118 @@
+package metadata
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rancher/os/config/cloudinit/pkg"
+)
+
+// imds is a stand-in for a metadata service requiring session tokens
+type imds struct {
+	tokens   []string
+	puts     int
+	disabled bool
+}
+
+func (m *imds) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "PUT" && r.URL.Path == "/latest/api/token":
+		if m.disabled {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.Header.Get("X-Token-TTL") != "21600" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		m.puts++
+		w.Write([]byte(m.tokens[len(m.tokens)-1]))
+	case r.Method == "GET" && r.URL.Path == "/latest/user-data":
+		if m.disabled && r.Header.Get("X-Token") == "" {
+			w.Write([]byte("v1"))
+			return
+		}
+		if r.Header.Get("X-Token") != m.tokens[len(m.tokens)-1] {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("hello"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestService(url string, v1Fallback bool) (Service, *TokenSession) {
+	service := NewDatasource(url, "latest/", "latest/user-data", "latest/meta-data/", nil)
+	client := service.Client.(*pkg.HTTPClient)
+	client.InitialBackoff = time.Millisecond
+	client.MaxBackoff = time.Millisecond
+	client.MaxRetries = 3
+	session := NewTokenSession(service.Root+"latest/api/token", "X-Token", "X-Token-TTL", 6*time.Hour, v1Fallback)
+	service.SetSession(session)
+	return service, session
+}
+
+func TestTokenSession(t *testing.T) {
+	m := &imds{tokens: []string{"first"}}
+	server := httptest.NewServer(m)
+	defer server.Close()
+
+	service, session := newTestService(server.URL, false)
+	now := time.Now()
+	session.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		data, err := service.FetchUserdata()
+		if err != nil {
+			t.Fatalf("bad error: %v", err)
+		}
+		if string(data) != "hello" {
+			t.Fatalf("bad userdata: want %q, got %q", "hello", data)
+		}
+	}
+	if m.puts != 1 {
+		t.Fatalf("bad token requests: want 1, got %d", m.puts)
+	}
+
+	// renewed when about to expire
+	now = now.Add(6*time.Hour - time.Second)
+	m.tokens = append(m.tokens, "second")
+	if _, err := service.FetchUserdata(); err != nil {
+		t.Fatalf("bad error: %v", err)
+	}
+	if m.puts != 2 {
+		t.Fatalf("bad token requests: want 2, got %d", m.puts)
+	}
+
+	// renewed when rejected
+	m.tokens = append(m.tokens, "third")
+	if data, err := service.FetchUserdata(); err != nil || string(data) != "hello" {
+		t.Fatalf("bad userdata: want %q, got %q (%v)", "hello", data, err)
+	}
+	if m.puts != 3 {
+		t.Fatalf("bad token requests: want 3, got %d", m.puts)
+	}
+}
+
+func TestTokenSessionV1Fallback(t *testing.T) {
+	m := &imds{tokens: []string{"first"}, disabled: true}
+	server := httptest.NewServer(m)
+	defer server.Close()
+
+	service, _ := newTestService(server.URL, false)
+	if _, err := service.FetchUserdata(); err == nil {
+		t.Fatalf("expected an error without a token")
+	}
+
+	service, _ = newTestService(server.URL, true)
+	data, err := service.FetchUserdata()
+	if err != nil {
+		t.Fatalf("bad error: %v", err)
+	}
+	if string(data) != "v1" {
+		t.Fatalf("bad userdata: want %q, got %q", "v1", data)
+	}
+}
@@ -0,0 +1,321 @@
+package nocloud
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/rancher/os/config/cloudinit/datasource"
+	"github.com/rancher/os/config/cloudinit/pkg"
+	"github.com/rancher/os/pkg/log"
+	"github.com/rancher/os/pkg/netconf"
+	"github.com/rancher/os/pkg/util"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+	"github.com/docker/docker/pkg/mount"
+)
+
+const (
+	cmdlineLocation     = "/proc/cmdline"
+	serialLocation      = "/sys/class/dmi/id/product_serial"
+	configDevMountPoint = "/media/cidata"
+)
+
+var (
+	// see https://cloudinit.readthedocs.io/en/latest/topics/datasources/nocloud.html
+	configDevLabels  = []string{"cidata", "CIDATA"}
+	configDevFsTypes = []string{"vfat", "iso9660"}
+)
+
+// NoCloud reads user-data, meta-data and network-config from a volume
+// labelled cidata, from a directory or from the seed url given with
+// ds=nocloud;s=<url> on the kernel cmdline or in the SMBIOS serial.
+type NoCloud struct {
+	root                string
+	seed                Seed
+	readFile            func(filename string) ([]byte, error)
+	client              pkg.Getter
+	lastError           error
+	availabilityChanges bool
+}
+
+// Seed is what ds=nocloud sets
+type Seed struct {
+	URL      string
+	Hostname string
+}
+
+// NewDatasource creates the datasource for root, a directory or a seed
+// url. Without one, the seed is looked for on the kernel cmdline and in the
+// SMBIOS serial, and lacking that the cidata volume is used.
+func NewDatasource(root string) *NoCloud {
+	seed := Seed{URL: root}
+	if root == "" {
+		seed, _ = FindSeed()
+	}
+	nc := &NoCloud{
+		seed:                seed,
+		readFile:            ioutil.ReadFile,
+		client:              pkg.NewHTTPClient(),
+		availabilityChanges: true,
+	}
+	switch {
+	case isURL(seed.URL):
+	case strings.HasPrefix(seed.URL, "file://"):
+		nc.root = strings.TrimPrefix(seed.URL, "file://")
+	case seed.URL != "":
+		nc.root = seed.URL
+	default:
+		nc.root = configDevMountPoint
+	}
+	if nc.seed.URL != "" && !strings.HasSuffix(nc.seed.URL, "/") {
+		nc.seed.URL += "/"
+	}
+	return nc
+}
+
+// FindSeed looks for ds=nocloud on the kernel cmdline, then in the SMBIOS serial
+func FindSeed() (Seed, bool) {
+	for _, location := range []string{cmdlineLocation, serialLocation} {
+		contents, err := ioutil.ReadFile(location)
+		if err != nil {
+			continue
+		}
+		if seed, ok := parseSeed(string(contents)); ok {
+			return seed, true
+		}
+	}
+	return Seed{}, false
+}
+
+// HasConfigDrive is whether there's a volume labelled cidata
+func HasConfigDrive() bool {
+	return configDevice() != ""
+}
+
+func parseSeed(input string) (Seed, bool) {
+	for _, token := range strings.Fields(input) {
+		token = strings.Trim(token, "'\"")
+		if !strings.HasPrefix(token, "ds=") {
+			continue
+		}
+		options := strings.Split(strings.TrimPrefix(token, "ds="), ";")
+		if options[0] != "nocloud" && options[0] != "nocloud-net" {
+			continue
+		}
+
+		seed := Seed{}
+		for _, option := range options[1:] {
+			parts := strings.SplitN(option, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			switch parts[0] {
+			case "s", "seedfrom":
+				seed.URL = parts[1]
+			case "h", "local-hostname":
+				seed.Hostname = parts[1]
+			}
+		}
+		return seed, true
+	}
+	return Seed{}, false
+}
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+func (nc *NoCloud) IsAvailable() bool {
+	if isURL(nc.seed.URL) {
+		_, nc.lastError = nc.client.Get(nc.seed.URL + "meta-data")
+		return nc.lastError == nil
+	}
+
+	if nc.root == configDevMountPoint {
+		nc.lastError = MountConfigDrive()
+		if nc.lastError != nil {
+			log.Error(nc.lastError)
+			// Don't keep retrying if we can't mount
+			nc.availabilityChanges = false
+			return false
+		}
+		defer nc.Finish()
+	}
+
+	for _, name := range []string{"meta-data", "user-data"} {
+		if _, nc.lastError = os.Stat(path.Join(nc.root, name)); nc.lastError == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (nc *NoCloud) Finish() error {
+	if nc.root == configDevMountPoint && !isURL(nc.seed.URL) {
+		return UnmountConfigDrive()
+	}
+	return nil
+}
+
+func (nc *NoCloud) String() string {
+	if nc.lastError != nil {
+		return fmt.Sprintf("%s: %s (lastError: %v)", nc.Type(), nc.ConfigRoot(), nc.lastError)
+	}
+	return fmt.Sprintf("%s: %s", nc.Type(), nc.ConfigRoot())
+}
+
+func (nc *NoCloud) AvailabilityChanges() bool {
+	return nc.availabilityChanges
+}
+
+func (nc *NoCloud) ConfigRoot() string {
+	if isURL(nc.seed.URL) {
+		return nc.seed.URL
+	}
+	return nc.root
+}
+
+func (nc *NoCloud) FetchMetadata() (metadata datasource.Metadata, err error) {
+	var data []byte
+	var m struct {
+		LocalHostname string      `yaml:"local-hostname"`
+		Hostname      string      `yaml:"hostname"`
+		PublicKeys    interface{} `yaml:"public-keys"`
+	}
+
+	if data, err = nc.tryReadFile("meta-data"); err != nil {
+		return
+	}
+	if err = yaml.Unmarshal(data, &m); err != nil {
+		return
+	}
+
+	metadata.Hostname = m.LocalHostname
+	if metadata.Hostname == "" {
+		metadata.Hostname = m.Hostname
+	}
+	if nc.seed.Hostname != "" {
+		metadata.Hostname = nc.seed.Hostname
+	}
+	metadata.SSHPublicKeys = publicKeys(m.PublicKeys)
+
+	if data, err = nc.tryReadFile("network-config"); err != nil {
+		return
+	}
+	if len(data) > 0 {
+		if metadata.NetworkConfig, err = netconf.ParseNetplan(data); err != nil {
+			log.Errorf("Ignoring the network-config of %s: %v", nc, err)
+			err = nil
+		}
+	}
+	return
+}
+
+// publicKeys reads public-keys, which can be a string of keys, a list or a map
+func publicKeys(value interface{}) map[string]string {
+	keys := []string{}
+	switch value := value.(type) {
+	case string:
+		keys = strings.Split(value, "\n")
+	case []interface{}:
+		for _, key := range value {
+			if key, ok := key.(string); ok {
+				keys = append(keys, key)
+			}
+		}
+	case map[interface{}]interface{}:
+		result := map[string]string{}
+		for name, key := range value {
+			if key, ok := key.(string); ok {
+				result[fmt.Sprint(name)] = strings.TrimSpace(key)
+			}
+		}
+		return result
+	}
+
+	result := map[string]string{}
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key != "" {
+			result[strconv.Itoa(len(result))] = key
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+func (nc *NoCloud) FetchUserdata() ([]byte, error) {
+	return nc.tryReadFile("user-data")
+}
+
+func (nc *NoCloud) Type() string {
+	return "nocloud"
+}
+
+func (nc *NoCloud) tryReadFile(name string) ([]byte, error) {
+	if isURL(nc.seed.URL) {
+		data, err := nc.client.GetRetry(nc.seed.URL + name)
+		if _, ok := err.(pkg.ErrNotFound); ok {
+			return []byte{}, nil
+		}
+		return data, err
+	}
+
+	if nc.root == configDevMountPoint {
+		nc.lastError = MountConfigDrive()
+		if nc.lastError != nil {
+			log.Error(nc.lastError)
+			return nil, nc.lastError
+		}
+		defer nc.Finish()
+	}
+	filename := path.Join(nc.root, name)
+	log.Debugf("Attempting to read from %q\n", filename)
+	data, err := nc.readFile(filename)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		log.Errorf("ERROR read cloud-config file(%s) - err: %q", filename, err)
+	}
+	return data, err
+}
+
+func configDevice() string {
+	for _, label := range configDevLabels {
+		if dev := util.ResolveDevice("LABEL=" + label); dev != "" {
+			return dev
+		}
+	}
+	return ""
+}
+
+func MountConfigDrive() error {
+	configDev := configDevice()
+	if configDev == "" {
+		return fmt.Errorf("no volume labelled %s", strings.Join(configDevLabels, " or "))
+	}
+
+	fsType, err := util.GetFsType(configDev)
+	if err != nil {
+		return err
+	}
+	if !util.Contains(configDevFsTypes, fsType) {
+		return fmt.Errorf("%s is %s, not %s", configDev, fsType, strings.Join(configDevFsTypes, " or "))
+	}
+
+	if err := os.MkdirAll(configDevMountPoint, 0700); err != nil {
+		return err
+	}
+	return mount.Mount(configDev, configDevMountPoint, fsType, "ro")
+}
+
+func UnmountConfigDrive() error {
+	return syscall.Unmount(configDevMountPoint, 0)
+}
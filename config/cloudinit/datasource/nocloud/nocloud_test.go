@@ -0,0 +1,156 @@
+package nocloud
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/rancher/os/config/cloudinit/datasource/test"
+	"github.com/rancher/os/config/cloudinit/pkg"
+)
+
+func TestParseSeed(t *testing.T) {
+	for _, tt := range []struct {
+		input string
+		seed  Seed
+		ok    bool
+	}{
+		{
+			input: "console=ttyS0 rancher.autologin=ttyS0",
+		},
+		{
+			input: "console=ttyS0 ds=nocloud",
+			ok:    true,
+		},
+		{
+			input: "ds=nocloud-net;s=http://10.0.0.1:8000/;h=lab1 quiet",
+			seed:  Seed{URL: "http://10.0.0.1:8000/", Hostname: "lab1"},
+			ok:    true,
+		},
+		{
+			input: "'ds=nocloud;seedfrom=file:///var/lib/seed/'",
+			seed:  Seed{URL: "file:///var/lib/seed/"},
+			ok:    true,
+		},
+		{
+			input: "ds=ec2",
+		},
+	} {
+		seed, ok := parseSeed(tt.input)
+		if ok != tt.ok || seed != tt.seed {
+			t.Fatalf("bad seed (%q): want %+v %t, got %+v %t", tt.input, tt.seed, tt.ok, seed, ok)
+		}
+	}
+}
+
+func TestNewDatasource(t *testing.T) {
+	for _, tt := range []struct {
+		root       string
+		configRoot string
+	}{
+		{
+			root:       "/var/lib/seed",
+			configRoot: "/var/lib/seed",
+		},
+		{
+			root:       "file:///var/lib/seed/",
+			configRoot: "/var/lib/seed/",
+		},
+		{
+			root:       "http://10.0.0.1/seed",
+			configRoot: "http://10.0.0.1/seed/",
+		},
+	} {
+		if configRoot := NewDatasource(tt.root).ConfigRoot(); configRoot != tt.configRoot {
+			t.Fatalf("bad config root (%q): want %q, got %q", tt.root, tt.configRoot, configRoot)
+		}
+	}
+}
+
+func TestFetchMetadata(t *testing.T) {
+	for _, tt := range []struct {
+		metadata string
+		seed     Seed
+		hostname string
+		keys     map[string]string
+	}{
+		{
+			metadata: "instance-id: iid-1\nlocal-hostname: lab1\npublic-keys:\n  - ssh-rsa AAAA one\n  - ssh-rsa BBBB two\n",
+			hostname: "lab1",
+			keys:     map[string]string{"0": "ssh-rsa AAAA one", "1": "ssh-rsa BBBB two"},
+		},
+		{
+			metadata: "{\"instance-id\": \"iid-1\", \"hostname\": \"lab2\", \"public-keys\": \"ssh-rsa AAAA one\\n\"}",
+			hostname: "lab2",
+			keys:     map[string]string{"0": "ssh-rsa AAAA one"},
+		},
+		{
+			metadata: "local-hostname: lab1\npublic-keys:\n  admin: ssh-rsa AAAA one\n",
+			seed:     Seed{Hostname: "lab3"},
+			hostname: "lab3",
+			keys:     map[string]string{"admin": "ssh-rsa AAAA one"},
+		},
+	} {
+		nc := &NoCloud{
+			root:     "/media/seed",
+			seed:     tt.seed,
+			readFile: test.NewMockFilesystem(test.File{Path: "/media/seed/meta-data", Contents: tt.metadata}).ReadFile,
+		}
+		metadata, err := nc.FetchMetadata()
+		if err != nil {
+			t.Fatalf("bad error (%q): %v", tt.metadata, err)
+		}
+		if metadata.Hostname != tt.hostname {
+			t.Fatalf("bad hostname (%q): want %q, got %q", tt.metadata, tt.hostname, metadata.Hostname)
+		}
+		if !reflect.DeepEqual(tt.keys, metadata.SSHPublicKeys) {
+			t.Fatalf("bad keys (%q): want %v, got %v", tt.metadata, tt.keys, metadata.SSHPublicKeys)
+		}
+	}
+}
+
+func TestSeedURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/seed/meta-data":
+			w.Write([]byte("local-hostname: lab1\n"))
+		case "/seed/user-data":
+			w.Write([]byte("#cloud-config\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	nc := NewDatasource(server.URL + "/seed")
+	nc.client.(*pkg.HTTPClient).MaxRetries = 1
+	if !nc.IsAvailable() {
+		t.Fatalf("bad isAvailable: want true, got false (%v)", nc.lastError)
+	}
+	userdata, err := nc.FetchUserdata()
+	if err != nil || string(userdata) != "#cloud-config\n" {
+		t.Fatalf("bad userdata: want %q, got %q (%v)", "#cloud-config\n", userdata, err)
+	}
+	metadata, err := nc.FetchMetadata()
+	if err != nil || metadata.Hostname != "lab1" {
+		t.Fatalf("bad hostname: want %q, got %q (%v)", "lab1", metadata.Hostname, err)
+	}
+}
+
+func TestFetchNetworkConfig(t *testing.T) {
+	nc := &NoCloud{
+		root: "/media/seed",
+		readFile: test.NewMockFilesystem(
+			test.File{Path: "/media/seed/meta-data", Contents: "local-hostname: lab1\n"},
+			test.File{Path: "/media/seed/network-config", Contents: "version: 2\nethernets:\n  eth0:\n    dhcp4: true\n"},
+		).ReadFile,
+	}
+	metadata, err := nc.FetchMetadata()
+	if err != nil {
+		t.Fatalf("bad error: %v", err)
+	}
+	if iface, ok := metadata.NetworkConfig.Interfaces["eth0"]; !ok || !iface.DHCP {
+		t.Fatalf("bad network config: %#v", metadata.NetworkConfig)
+	}
+}
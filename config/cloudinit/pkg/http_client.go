@@ -66,9 +66,20 @@ type HTTPClient struct {
 	// Headers to add to the request.
 	Header http.Header
 
+	// Session adds credentials to the requests, if set.
+	Session Session
+
 	client *http.Client
 }
 
+// Session adds credentials to requests, like the session tokens of EC2's IMDSv2
+type Session interface {
+	// Authorize is called on each request before it is sent
+	Authorize(*http.Request) error
+	// Reset is called when a request was unauthorized, for the next one to get new credentials
+	Reset()
+}
+
 type Getter interface {
 	Get(string) ([]byte, error)
 	GetRetry(string) ([]byte, error)
@@ -149,13 +160,27 @@ func (h *HTTPClient) Get(dataURL string) ([]byte, error) {
 		return nil, err
 	}
 
-	request.Header = h.Header
+	request.Header = http.Header{}
+	for key, values := range h.Header {
+		request.Header[key] = values
+	}
+	if h.Session != nil {
+		if err := h.Session.Authorize(request); err != nil {
+			return nil, ErrNetwork{fmt.Errorf("Unable to authorize request: %s", err.Error())}
+		}
+	}
+
 	if resp, err := h.client.Do(request); err == nil {
 		defer resp.Body.Close()
 		switch resp.StatusCode / 100 {
 		case HTTP2xx:
 			return ioutil.ReadAll(resp.Body)
 		case HTTP4xx:
+			if resp.StatusCode == http.StatusUnauthorized && h.Session != nil {
+				// retried with new credentials
+				h.Session.Reset()
+				return nil, ErrServer{fmt.Errorf("Unauthorized. HTTP status code: %d", resp.StatusCode)}
+			}
 			return nil, ErrNotFound{fmt.Errorf("Not found. HTTP status code: %d", resp.StatusCode)}
 		default:
 			return nil, ErrServer{fmt.Errorf("Server error. HTTP status code: %d", resp.StatusCode)}
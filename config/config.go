@@ -2,6 +2,7 @@ package config
 
 import (
 	"io/ioutil"
+	"reflect"
 	"strings"
 
 	yaml "github.com/cloudfoundry-incubator/candiedyaml"
@@ -30,7 +31,7 @@ func Merge(bytes []byte) error {
 	if err != nil {
 		return err
 	}
-	return WriteToFile(util.Merge(existing, data), CloudConfigFile)
+	return writeCloudConfig(util.Merge(existing, data))
 }
 
 func Export(private, full bool) (string, error) {
@@ -71,6 +72,73 @@ func Get(key string) (interface{}, error) {
 	return v, nil
 }
 
+// LayerValue is the value of a key in a layer of the config
+type LayerValue struct {
+	Layer string
+	Value interface{}
+}
+
+// Explanation tells where the value of a key of the config comes from:
+// its value in each layer that sets it, in the order they are merged, and
+// the last layer with the merged value. Winner is empty when the value is
+// merged from several layers, like maps or the metadata ssh keys.
+type Explanation struct {
+	Key    string
+	Value  interface{}
+	Layers []LayerValue
+	Winner string
+}
+
+func Explain(key string, private bool) (*Explanation, error) {
+	rawCfg, layers, err := loadRawConfigLayers("", true, false)
+	if err != nil {
+		return nil, err
+	}
+	if !private {
+		rawCfg = filterPrivateKeys(rawCfg)
+		for i := range layers {
+			layers[i].Config = filterPrivateKeys(layers[i].Config)
+		}
+	}
+	return explain(key, rawCfg, layers), nil
+}
+
+func explain(key string, rawCfg map[interface{}]interface{}, layers []ConfigLayer) *Explanation {
+	explanation := &Explanation{Key: key}
+	explanation.Value, _ = lookupKey(rawCfg, key)
+
+	for _, layer := range layers {
+		if value, ok := lookupKey(layer.Config, key); ok {
+			explanation.Layers = append(explanation.Layers, LayerValue{Layer: layer.Name, Value: value})
+		}
+	}
+
+	for i := len(explanation.Layers) - 1; i >= 0; i-- {
+		if reflect.DeepEqual(explanation.Layers[i].Value, explanation.Value) {
+			explanation.Winner = explanation.Layers[i].Layer
+			break
+		}
+	}
+	return explanation
+}
+
+func lookupKey(data map[interface{}]interface{}, key string) (interface{}, bool) {
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		value, ok := data[part]
+		if !ok {
+			return nil, false
+		}
+		if i == len(parts)-1 {
+			return value, true
+		}
+		if data, ok = value.(map[interface{}]interface{}); !ok {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
 func Set(key string, value interface{}) error {
 	existing, err := readConfigs(nil, false, true, CloudConfigFile)
 	if err != nil {
@@ -84,7 +152,7 @@ func Set(key string, value interface{}) error {
 		return err
 	}
 
-	return WriteToFile(modified, CloudConfigFile)
+	return writeCloudConfig(modified)
 }
 
 func GetKernelVersion() string {
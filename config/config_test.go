@@ -327,3 +327,151 @@ func TestUserDocker(t *testing.T) {
 	assert.True(v.(bool))
 
 }
+
+func TestUsersAndGroups(t *testing.T) {
+	assert := require.New(t)
+
+	config := &CloudConfig{}
+	err := yaml.Unmarshal([]byte(`users:
+- name: jane
+  groups: [docker]
+  sudo: ALL=(ALL) NOPASSWD:ALL
+  lock_passwd: false
+groups:
+- wheel
+- admins: [jane, john]`), config)
+	assert.Nil(err)
+
+	assert.Equal("jane", config.Users[0].Name)
+	assert.Equal([]string{"docker"}, config.Users[0].Groups)
+	assert.Equal(SudoRules{"ALL=(ALL) NOPASSWD:ALL"}, config.Users[0].Sudo)
+	assert.False(*config.Users[0].LockPasswd)
+	assert.Equal([]Group{{Name: "wheel"}, {Name: "admins", Members: []string{"jane", "john"}}}, config.Groups)
+
+	data := map[interface{}]interface{}{}
+	assert.Nil(util.Convert(config, &data))
+	converted := &CloudConfig{}
+	assert.Nil(util.Convert(data, converted))
+	assert.Equal(config.Groups, converted.Groups)
+	assert.Equal(config.Users[0].Name, converted.Users[0].Name)
+	assert.Equal(config.Users[0].Sudo, converted.Users[0].Sudo)
+
+	assert.NotNil(yaml.Unmarshal([]byte(`groups: [{a: [x], b: [y]}]`), &CloudConfig{}))
+
+	config = &CloudConfig{}
+	assert.Nil(yaml.Unmarshal([]byte(`users:
+- name: jane
+  sudo: ["ALL=(ALL) NOPASSWD:/usr/bin/system-docker", "ALL=(ALL) NOPASSWD:/usr/bin/ros"]
+- name: john
+  sudo: false`), config))
+	assert.Equal(SudoRules{"ALL=(ALL) NOPASSWD:/usr/bin/system-docker", "ALL=(ALL) NOPASSWD:/usr/bin/ros"}, config.Users[0].Sudo)
+	assert.Empty(config.Users[1].Sudo)
+
+	data = map[interface{}]interface{}{}
+	assert.Nil(util.Convert(config, &data))
+	converted = &CloudConfig{}
+	assert.Nil(util.Convert(data, converted))
+	assert.Equal(config.Users[0].Sudo, converted.Users[0].Sudo)
+
+	assert.NotNil(yaml.Unmarshal([]byte(`users: [{name: jane, sudo: true}]`), &CloudConfig{}))
+}
+
+func TestDiskSetup(t *testing.T) {
+	assert := require.New(t)
+
+	config := &CloudConfig{}
+	err := yaml.Unmarshal([]byte(`disk_setup:
+  /dev/nvme1n1:
+    table_type: gpt
+    layout: true
+  /dev/nvme2n1:
+    table_type: mbr
+    layout: [[25, 82], 75]
+    overwrite: true
+fs_setup:
+- label: docker
+  filesystem: ext4
+  device: /dev/nvme1n1
+  partition: 1
+  extra_opts: [-m, "0"]
+- label: swap
+  filesystem: swap
+  device: /dev/nvme2n1
+  partition: auto`), config)
+	assert.Nil(err)
+
+	assert.Equal(DiskLayout{{Size: 100}}, config.DiskSetup["/dev/nvme1n1"].Layout)
+	assert.Equal(DiskSetup{
+		TableType: "mbr",
+		Layout:    DiskLayout{{Size: 25, Type: "82"}, {Size: 75}},
+		Overwrite: true,
+	}, config.DiskSetup["/dev/nvme2n1"])
+	assert.Equal(FsSetup{Label: "docker", Filesystem: "ext4", Device: "/dev/nvme1n1", Partition: "1", ExtraOpts: []string{"-m", "0"}}, config.FsSetup[0])
+	assert.Equal("auto", config.FsSetup[1].Partition)
+
+	data := map[interface{}]interface{}{}
+	assert.Nil(util.Convert(config, &data))
+	converted := &CloudConfig{}
+	assert.Nil(util.Convert(data, converted))
+	assert.Equal(config.DiskSetup, converted.DiskSetup)
+	assert.Equal(config.FsSetup, converted.FsSetup)
+
+	assert.NotNil(yaml.Unmarshal([]byte(`disk_setup: {/dev/sdb: {layout: [150]}}`), &CloudConfig{}))
+	assert.NotNil(yaml.Unmarshal([]byte(`disk_setup: {/dev/sdb: {layout: [[50, 82, 1]]}}`), &CloudConfig{}))
+}
+
+func TestExplain(t *testing.T) {
+	assert := require.New(t)
+
+	layers := []ConfigLayer{
+		{Name: OsConfigFile, Config: map[interface{}]interface{}{
+			"hostname": "rancher",
+			"rancher": map[interface{}]interface{}{
+				"network": map[interface{}]interface{}{
+					"interfaces": map[interface{}]interface{}{
+						"eth*": map[interface{}]interface{}{"dhcp": true},
+					},
+				},
+			},
+		}},
+		{Name: CloudConfigFile, Config: map[interface{}]interface{}{
+			"hostname": "node1",
+			"rancher": map[interface{}]interface{}{
+				"network": map[interface{}]interface{}{
+					"interfaces": map[interface{}]interface{}{
+						"eth0": map[interface{}]interface{}{"address": "10.0.0.2/24"},
+					},
+				},
+			},
+		}},
+		{Name: "kernel cmdline", Config: map[interface{}]interface{}{
+			"rancher": map[interface{}]interface{}{"debug": true},
+		}},
+		{Name: MetaDataFile, Config: map[interface{}]interface{}{"hostname": "i-1234"}},
+	}
+	rawCfg := map[interface{}]interface{}{}
+	for _, layer := range layers[:3] {
+		rawCfg = util.Merge(rawCfg, layer.Config)
+	}
+
+	explanation := explain("hostname", rawCfg, layers)
+	assert.Equal("node1", explanation.Value)
+	assert.Equal(CloudConfigFile, explanation.Winner)
+	assert.Equal([]LayerValue{
+		{Layer: OsConfigFile, Value: "rancher"},
+		{Layer: CloudConfigFile, Value: "node1"},
+		{Layer: MetaDataFile, Value: "i-1234"},
+	}, explanation.Layers)
+
+	explanation = explain("rancher.network.interfaces.eth0.address", rawCfg, layers)
+	assert.Equal("10.0.0.2/24", explanation.Value)
+	assert.Equal(CloudConfigFile, explanation.Winner)
+
+	explanation = explain("rancher.network.interfaces", rawCfg, layers)
+	assert.Equal("", explanation.Winner)
+	assert.Len(explanation.Layers, 2)
+
+	explanation = explain("rancher.missing", rawCfg, layers)
+	assert.Nil(explanation.Value)
+	assert.Empty(explanation.Layers)
+}
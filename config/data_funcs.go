@@ -1,6 +1,15 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/rancher/os/pkg/log"
 	"github.com/rancher/os/pkg/util"
 )
@@ -13,7 +22,144 @@ type CfgFuncData struct {
 
 type CfgFuncs []CfgFuncData
 
+// InitReport records when each init stage, and the init hooks around it, ran.
+// Uptime is how long the kernel had been up when init started, End is when
+// init handed over to System Docker.
+type InitReport struct {
+	Version string            `json:"version"`
+	Uptime  time.Duration     `json:"uptime"`
+	Start   time.Time         `json:"start"`
+	End     time.Time         `json:"end"`
+	Stages  []InitStageRecord `json:"stages"`
+}
+
+type InitStageRecord struct {
+	Name     string        `json:"name"`
+	Hook     string        `json:"hook,omitempty"`
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+func NewInitReport() *InitReport {
+	report := &InitReport{
+		Version: Version,
+		Start:   time.Now(),
+		Stages:  []InitStageRecord{},
+	}
+	// how long the kernel took before init was started
+	if bytes, err := ioutil.ReadFile("/proc/uptime"); err == nil {
+		fields := strings.Fields(string(bytes))
+		if len(fields) > 0 {
+			if seconds, err := strconv.ParseFloat(fields[0], 64); err == nil {
+				report.Uptime = time.Duration(seconds * float64(time.Second))
+			}
+		}
+	}
+	return report
+}
+
+func (r *InitReport) record(name, hook string, start time.Time, err error) {
+	end := time.Now()
+	record := InitStageRecord{
+		Name:     name,
+		Hook:     hook,
+		Start:    start,
+		End:      end,
+		Duration: end.Sub(start),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	r.Stages = append(r.Stages, record)
+}
+
+// Write saves the report as json, by default to InitReportFile
+func (r *InitReport) Write(filename string) error {
+	if filename == "" {
+		filename = InitReportFile
+	}
+	r.End = time.Now()
+	bytes, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(filename, bytes, 0644)
+}
+
+func ReadInitReport(filename string) (*InitReport, error) {
+	if filename == "" {
+		filename = InitReportFile
+	}
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	report := &InitReport{}
+	return report, json.Unmarshal(bytes, report)
+}
+
+// StageKey is the name of a stage as used in rancher.init_hooks,
+// "mount STATE and bootstrap" becomes "mount_state_and_bootstrap"
+func StageKey(name string) string {
+	key := []rune{}
+	sep := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if sep && len(key) > 0 {
+				key = append(key, '_')
+			}
+			key = append(key, r)
+			sep = false
+		} else {
+			sep = true
+		}
+	}
+	return string(key)
+}
+
+// hookScript resolves a relative hook path against the OEM partition
+func hookScript(script string) string {
+	if filepath.IsAbs(script) {
+		return script
+	}
+	return filepath.Join(OemDir, script)
+}
+
+func runInitHooks(cfg *CloudConfig, hook, name string, report *InitReport) {
+	if cfg == nil || report == nil {
+		return
+	}
+	hook = hook + "_" + StageKey(name)
+	for _, script := range cfg.Rancher.InitHooks[hook] {
+		script = hookScript(script)
+		log.Infof("Running %s hook %s", hook, script)
+		start := time.Now()
+		var err error
+		if util.ExistsAndExecutable(script) {
+			err = util.RunScript(script, hook)
+		} else {
+			err = fmt.Errorf("%s does not exist or is not executable", script)
+		}
+		if err != nil {
+			log.Errorf("Failed %s hook %s: %v", hook, script, err)
+		}
+		report.record(script, hook, start, err)
+	}
+}
+
 func ChainCfgFuncs(cfg *CloudConfig, cfgFuncs CfgFuncs) (*CloudConfig, error) {
+	return ChainCfgFuncsWithReport(cfg, cfgFuncs, nil)
+}
+
+// ChainCfgFuncsWithReport runs cfgFuncs like ChainCfgFuncs, recording each
+// stage into report and running the rancher.init_hooks around it.
+// Hooks are not run while there is no cfg loaded yet.
+func ChainCfgFuncsWithReport(cfg *CloudConfig, cfgFuncs CfgFuncs, report *InitReport) (*CloudConfig, error) {
 	len := len(cfgFuncs)
 	for c, d := range cfgFuncs {
 		i := c + 1
@@ -24,12 +170,19 @@ func ChainCfgFuncs(cfg *CloudConfig, cfgFuncs CfgFuncs) (*CloudConfig, error) {
 		} else {
 			log.Infof("[%d/%d] Starting %s", i, len, name)
 		}
+		runInitHooks(cfg, "before", name, report)
+		start := time.Now()
 		var err error
-		if cfg, err = cfgFunc(cfg); err != nil {
+		cfg, err = cfgFunc(cfg)
+		if report != nil {
+			report.record(name, "", start, err)
+		}
+		if err != nil {
 			log.Errorf("Failed [%d/%d] %s: %v", i, len, name, err)
 			return cfg, err
 		}
 		log.Debugf("[%d/%d] Done %s", i, len, name)
+		runInitHooks(cfg, "after", name, report)
 	}
 	return cfg, nil
 }
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStageKey(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("mount_state_and_bootstrap", StageKey("mount STATE and bootstrap"))
+	assert.Equal("cloud_init", StageKey("cloud-init"))
+	assert.Equal("load_modules2", StageKey("load modules2"))
+	assert.Equal("setupsharedroot", StageKey(" setupSharedRoot "))
+}
+
+func TestChainCfgFuncsWithReport(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "init-hooks")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+	hook := filepath.Join(dir, "hook.sh")
+	assert.NoError(ioutil.WriteFile(hook, []byte("#!/bin/sh\necho $1 >> "+out+"\n"), 0755))
+
+	cfg := &CloudConfig{}
+	cfg.Rancher.InitHooks = map[string][]string{
+		"before_first": {hook},
+		"after_first":  {hook, filepath.Join(dir, "missing.sh")},
+		"after_second": {hook},
+	}
+	funcs := CfgFuncs{
+		{"first", func(cfg *CloudConfig) (*CloudConfig, error) { return cfg, nil }},
+		{"second", func(cfg *CloudConfig) (*CloudConfig, error) { return cfg, errors.New("broken") }},
+		{"third", func(cfg *CloudConfig) (*CloudConfig, error) { return cfg, nil }},
+	}
+
+	report := NewInitReport()
+	_, err = ChainCfgFuncsWithReport(cfg, funcs, report)
+	assert.Error(err)
+
+	bytes, err := ioutil.ReadFile(out)
+	assert.NoError(err)
+	assert.Equal("before_first\nafter_first\n", string(bytes))
+
+	names := []string{}
+	for _, stage := range report.Stages {
+		names = append(names, stage.Hook+":"+filepath.Base(stage.Name))
+	}
+	assert.Equal([]string{
+		"before_first:hook.sh",
+		":first",
+		"after_first:hook.sh",
+		"after_first:missing.sh",
+		":second",
+	}, names)
+	assert.NotEmpty(report.Stages[3].Error)
+	assert.Equal("broken", report.Stages[4].Error)
+
+	filename := filepath.Join(dir, "boot", "init-report.json")
+	assert.NoError(report.Write(filename))
+	read, err := ReadInitReport(filename)
+	assert.NoError(err)
+	assert.Equal(len(report.Stages), len(read.Stages))
+	assert.Equal(report.Stages[1].Duration, read.Stages[1].Duration)
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/rancher/os/config/cloudinit/datasource"
 	"github.com/rancher/os/config/cloudinit/initialize"
 	"github.com/rancher/os/config/cmdline"
+	"github.com/rancher/os/config/secret"
 	"github.com/rancher/os/pkg/log"
 	"github.com/rancher/os/pkg/util"
 
@@ -36,59 +37,62 @@ func ReadConfig(bytes []byte, substituteMetadataVars bool, files ...string) (*Cl
 	return c, nil
 }
 
-func loadRawDiskConfig(dirPrefix string, full bool) map[interface{}]interface{} {
-	var rawCfg map[interface{}]interface{}
-	if full {
-		rawCfg, _ = readConfigs(nil, true, false, OsConfigFile, OemConfigFile)
-	}
+// ConfigLayer is one of the layers merged into the config, named after the
+// file or source it was read from
+type ConfigLayer struct {
+	Name   string
+	Config map[interface{}]interface{}
+}
 
-	files := CloudConfigDirFiles(dirPrefix)
-	files = append(files, path.Join(dirPrefix, CloudConfigFile))
-	additionalCfgs, _ := readConfigs(nil, true, false, files...)
+func loadRawConfig(dirPrefix string, full bool) map[interface{}]interface{} {
+	rawCfg, _, _ := loadRawConfigLayers(dirPrefix, full, false)
+	return rawCfg
+}
 
-	return util.Merge(rawCfg, additionalCfgs)
+func loadRawConfigWithError(dirPrefix string, full bool) (map[interface{}]interface{}, error) {
+	rawCfg, _, err := loadRawConfigLayers(dirPrefix, true, true)
+	return rawCfg, err
 }
 
-func loadRawDiskConfigWithError(dirPrefix string, full bool) (map[interface{}]interface{}, error) {
-	var rawCfg map[interface{}]interface{}
-	rawCfg, err := readConfigs(nil, true, true, OsConfigFile, OemConfigFile)
-	if err != nil {
-		return nil, err
+// loadRawConfigLayers merges the layers of the config, in order: the OS
+// and OEM config when full, cloud-config.d, cloud-config.yml, the kernel
+// cmdline, the elided cmdline, the debug flags and the metadata. The
+// layers are returned along with the merged config, to tell which layer
+// set a key.
+func loadRawConfigLayers(dirPrefix string, full, returnErr bool) (map[interface{}]interface{}, []ConfigLayer, error) {
+	files := []string{}
+	if full {
+		files = append(files, OsConfigFile, OemConfigFile)
 	}
-	files := CloudConfigDirFiles(dirPrefix)
+	files = append(files, CloudConfigDirFiles(dirPrefix)...)
 	files = append(files, path.Join(dirPrefix, CloudConfigFile))
-	additionalCfgs, err := readConfigs(nil, true, true, files...)
-	if err != nil {
-		return nil, err
-	}
-	return util.Merge(rawCfg, additionalCfgs), nil
-}
 
-func loadRawConfig(dirPrefix string, full bool) map[interface{}]interface{} {
-	rawCfg := loadRawDiskConfig(dirPrefix, full)
-	procCmdline, err := cmdline.Read(false)
-	if err != nil {
-		log.WithFields(log.Fields{"err": err}).Error("Failed to read kernel params")
+	rawCfg := map[interface{}]interface{}{}
+	layers := []ConfigLayer{}
+	addLayer := func(name string, layer map[interface{}]interface{}) {
+		layers = append(layers, ConfigLayer{Name: name, Config: layer})
+		rawCfg = util.Merge(rawCfg, layer)
 	}
-	rawCfg = util.Merge(rawCfg, procCmdline)
-	rawCfg = util.Merge(rawCfg, readElidedCmdline(rawCfg))
-	rawCfg = applyDebugFlags(rawCfg)
-	return mergeMetadata(rawCfg, readMetadata())
-}
 
-func loadRawConfigWithError(dirPrefix string, full bool) (map[interface{}]interface{}, error) {
-	rawCfg, err := loadRawDiskConfigWithError(dirPrefix, full)
-	if err != nil {
-		return nil, err
+	for _, file := range files {
+		layer, err := readConfigs(nil, true, returnErr, file)
+		if err != nil {
+			return nil, nil, err
+		}
+		addLayer(file, layer)
 	}
+
 	procCmdline, err := cmdline.Read(false)
 	if err != nil {
 		log.WithFields(log.Fields{"err": err}).Error("Failed to read kernel params")
 	}
-	rawCfg = util.Merge(rawCfg, procCmdline)
-	rawCfg = util.Merge(rawCfg, readElidedCmdline(rawCfg))
-	rawCfg = applyDebugFlags(rawCfg)
-	return mergeMetadata(rawCfg, readMetadata()), nil
+	addLayer("kernel cmdline", procCmdline)
+	addLayer("elided cmdline", readElidedCmdline(rawCfg))
+	addLayer("debug", debugFlags(rawCfg))
+
+	metadata := readMetadata()
+	layers = append(layers, ConfigLayer{Name: MetaDataFile, Config: metadataLayer(metadata)})
+	return mergeMetadata(rawCfg, metadata), layers, nil
 }
 
 func LoadConfig() *CloudConfig {
@@ -109,7 +113,7 @@ func LoadConfigWithError() (*CloudConfig, *gojsonschema.Result, error) {
 		return &CloudConfig{}, nil, err
 	}
 	cfg := &CloudConfig{}
-	if err := util.Convert(rawCfg, cfg); err != nil {
+	if err := util.Convert(decryptSecrets("", rawCfg), cfg); err != nil {
 		validationErrors, err := ValidateRawCfg(rawCfg)
 		return &CloudConfig{}, validationErrors, err
 	}
@@ -122,7 +126,7 @@ func LoadConfigWithPrefix(dirPrefix string) *CloudConfig {
 	rawCfg := loadRawConfig(dirPrefix, true)
 
 	cfg := &CloudConfig{}
-	if err := util.Convert(rawCfg, cfg); err != nil {
+	if err := util.Convert(decryptSecrets(dirPrefix, rawCfg), cfg); err != nil {
 		log.Errorf("EXITING: Failed to parse configuration: %s", err)
 		log.Debugf("Bad cfg:\n%v\n", rawCfg)
 		// no point returning {}, it'll just sit there broken
@@ -196,23 +200,26 @@ func CloudConfigDirFiles(dirPrefix string) []string {
 	return finalFiles
 }
 
-func applyDebugFlags(rawCfg map[interface{}]interface{}) map[interface{}]interface{} {
+// debugFlags are the debug settings of the docker daemons and the log,
+// which are enabled along with rancher.debug
+func debugFlags(rawCfg map[interface{}]interface{}) map[interface{}]interface{} {
 	cfg := &CloudConfig{}
 	if err := util.Convert(rawCfg, cfg); err != nil {
-		return rawCfg
+		return nil
 	}
 
 	if !cfg.Rancher.Debug {
-		return rawCfg
+		return nil
 	}
 
 	log.SetLevel(log.DebugLevel)
-	_, rawCfg = cmdline.GetOrSetVal("rancher.docker.debug", rawCfg, true)
-	_, rawCfg = cmdline.GetOrSetVal("rancher.system_docker.debug", rawCfg, true)
-	_, rawCfg = cmdline.GetOrSetVal("rancher.bootstrap_docker.debug", rawCfg, true)
-	_, rawCfg = cmdline.GetOrSetVal("rancher.log", rawCfg, true)
+	flags := map[interface{}]interface{}{}
+	_, flags = cmdline.GetOrSetVal("rancher.docker.debug", flags, true)
+	_, flags = cmdline.GetOrSetVal("rancher.system_docker.debug", flags, true)
+	_, flags = cmdline.GetOrSetVal("rancher.bootstrap_docker.debug", flags, true)
+	_, flags = cmdline.GetOrSetVal("rancher.log", flags, true)
 
-	return rawCfg
+	return flags
 }
 
 // mergeMetadata merges certain options from md (meta-data from the datasource)
@@ -260,6 +267,34 @@ func mergeMetadata(rawCfg map[interface{}]interface{}, md datasource.Metadata) m
 	return out
 }
 
+// metadataLayer is the config set by the metadata, before it is merged by
+// mergeMetadata
+func metadataLayer(md datasource.Metadata) map[interface{}]interface{} {
+	layer := map[interface{}]interface{}{}
+	if md.Hostname != "" {
+		layer["hostname"] = md.Hostname
+	}
+
+	keys := []string{}
+	for k := range md.SSHPublicKeys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	sshKeys := []interface{}{}
+	for _, k := range keys {
+		sshKeys = append(sshKeys, md.SSHPublicKeys[k])
+	}
+	if len(sshKeys) > 0 {
+		layer["ssh_authorized_keys"] = sshKeys
+	}
+
+	if md.RootDisk != "" {
+		layer["rancher"] = map[interface{}]interface{}{"resize_device": md.RootDisk}
+	}
+	return layer
+}
+
 func readMetadata() datasource.Metadata {
 	metadata := datasource.Metadata{}
 	if metaDataBytes, err := ioutil.ReadFile(MetaDataFile); err == nil {
@@ -348,6 +383,7 @@ func readConfigs(bytes []byte, substituteMetadataVars, returnErr bool, files ...
 		if len(content) == 0 {
 			continue
 		}
+		content = secret.ReplaceTags(content)
 		if substituteMetadataVars {
 			content = substituteVars(content, metadata)
 		}
@@ -380,6 +416,7 @@ func readConfigs(bytes []byte, substituteMetadataVars, returnErr bool, files ...
 	}
 
 	right := make(map[interface{}]interface{})
+	bytes = secret.ReplaceTags(bytes)
 	if substituteMetadataVars {
 		bytes = substituteVars(bytes, metadata)
 	}
@@ -0,0 +1,323 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rancher/os/pkg/log"
+	"github.com/rancher/os/pkg/util"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+const (
+	defaultHistoryRetention = 10
+	snapshotPrefix          = "cloud-config-"
+	snapshotSuffix          = ".yml"
+	snapshotTimeFormat      = "20060102T150405.000000000Z"
+	// the name of the last snapshot that booted cleanly
+	goodSnapshotFile = "good"
+)
+
+// Snapshot is a version of cloud-config.yml kept in the config history
+type Snapshot struct {
+	Name string
+	Time time.Time
+	Good bool
+}
+
+// writeCloudConfig writes cloud-config.yml and keeps a snapshot of it
+func writeCloudConfig(data map[interface{}]interface{}) error {
+	if err := snapshotExisting(""); err != nil {
+		log.Errorf("Failed to snapshot %s: %v", CloudConfigFile, err)
+	}
+
+	if err := WriteToFile(data, CloudConfigFile); err != nil {
+		return err
+	}
+
+	content, err := ioutil.ReadFile(CloudConfigFile)
+	if err != nil {
+		return err
+	}
+	if _, err := saveSnapshot("", content, historyRetention()); err != nil {
+		log.Errorf("Failed to snapshot %s: %v", CloudConfigFile, err)
+	}
+	return nil
+}
+
+// Snapshots returns the snapshots of the config history, the newest first
+func Snapshots(dirPrefix string) ([]Snapshot, error) {
+	dir := path.Join(dirPrefix, CloudConfigHistoryDir)
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []Snapshot{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	good, _ := ioutil.ReadFile(path.Join(dir, goodSnapshotFile))
+
+	snapshots := []Snapshot{}
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasPrefix(name, snapshotPrefix) || !strings.HasSuffix(name, snapshotSuffix) {
+			continue
+		}
+		t, err := time.Parse(snapshotTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, snapshotPrefix), snapshotSuffix))
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{
+			Name: name,
+			Time: t,
+			Good: name == strings.TrimSpace(string(good)),
+		})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Name > snapshots[j].Name
+	})
+	return snapshots, nil
+}
+
+// ReadSnapshot returns the content of a snapshot
+func ReadSnapshot(dirPrefix, name string) ([]byte, error) {
+	return ioutil.ReadFile(path.Join(dirPrefix, CloudConfigHistoryDir, name))
+}
+
+// Rollback restores the nth snapshot, 0 being the newest, as
+// cloud-config.yml. The restored config becomes the newest snapshot.
+func Rollback(n int) error {
+	snapshots, err := Snapshots("")
+	if err != nil {
+		return err
+	}
+	if n < 0 || n >= len(snapshots) {
+		return fmt.Errorf("No snapshot %d, the config history has %d snapshots", n, len(snapshots))
+	}
+
+	content, err := ReadSnapshot("", snapshots[n].Name)
+	if err != nil {
+		return err
+	}
+	if err := validateConfigBytes(content); err != nil {
+		return fmt.Errorf("Snapshot %d is invalid: %v", n, err)
+	}
+
+	data := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(content, &data); err != nil {
+		return err
+	}
+	return writeCloudConfig(data)
+}
+
+// SnapshotDiff returns the unified diff between two snapshots, without the
+// private keys unless private. An empty older name diffs against nothing.
+func SnapshotDiff(dirPrefix, older, newer string, private bool) (string, error) {
+	a, err := snapshotText(dirPrefix, older, private)
+	if err != nil {
+		return "", err
+	}
+	b, err := snapshotText(dirPrefix, newer, private)
+	if err != nil {
+		return "", err
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(a),
+		B:        difflib.SplitLines(b),
+		FromFile: older,
+		ToFile:   newer,
+		Context:  3,
+	})
+}
+
+func snapshotText(dirPrefix, name string, private bool) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	content, err := ReadSnapshot(dirPrefix, name)
+	if err != nil {
+		return "", err
+	}
+	if private {
+		return string(content), nil
+	}
+
+	data := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(content, &data); err != nil {
+		// show the snapshot as is, it may be why it is in the history
+		return string(content), nil
+	}
+	content, err = yaml.Marshal(filterPrivateKeys(data))
+	return string(content), err
+}
+
+// MarkConfigGood records the snapshot of the current cloud-config.yml as
+// the last one that booted cleanly. An invalid cloud-config.yml is never
+// marked, so RestoreGoodConfig can not restore it.
+func MarkConfigGood(dirPrefix string) error {
+	file := path.Join(dirPrefix, CloudConfigFile)
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := validateConfigBytes(content); err != nil {
+		return fmt.Errorf("%s is invalid: %v", file, err)
+	}
+
+	snapshots, err := Snapshots(dirPrefix)
+	if err != nil {
+		return err
+	}
+
+	name := ""
+	for _, snapshot := range snapshots {
+		snapshotContent, err := ReadSnapshot(dirPrefix, snapshot.Name)
+		if err == nil && bytes.Equal(content, snapshotContent) {
+			name = snapshot.Name
+			break
+		}
+	}
+	if name == "" {
+		// cloud-config.yml was not written by ros config
+		if name, err = saveSnapshot(dirPrefix, content, historyRetention()); err != nil {
+			return err
+		}
+	}
+
+	return util.WriteFileAtomic(path.Join(dirPrefix, CloudConfigHistoryDir, goodSnapshotFile), []byte(name), 0600)
+}
+
+// RestoreGoodConfig restores the last snapshot that booted cleanly when
+// cloud-config.yml fails the schema validation, returning whether it did
+func RestoreGoodConfig(dirPrefix string) (bool, error) {
+	file := path.Join(dirPrefix, CloudConfigFile)
+	content, err := readConfigFile(file)
+	if err != nil {
+		return false, err
+	}
+	validationErr := validateConfigBytes(content)
+	if validationErr == nil {
+		return false, nil
+	}
+
+	good, err := ioutil.ReadFile(path.Join(dirPrefix, CloudConfigHistoryDir, goodSnapshotFile))
+	if err != nil {
+		return false, fmt.Errorf("%s is invalid (%v) and no snapshot booted cleanly", file, validationErr)
+	}
+	name := strings.TrimSpace(string(good))
+	goodContent, err := ReadSnapshot(dirPrefix, name)
+	if err != nil {
+		return false, err
+	}
+
+	log.Errorf("%s is invalid (%v), restoring %s", file, validationErr, name)
+	if err := util.WriteFileAtomic(file, goodContent, 0400); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// validateConfigBytes returns an error if the config can not be parsed, or
+// fails the schema validation or that of the mounts
+func validateConfigBytes(content []byte) error {
+	if len(bytes.TrimSpace(content)) == 0 {
+		return nil
+	}
+
+	result, err := ValidateBytes(content)
+	if err != nil {
+		return err
+	}
+	if !result.Valid() {
+		errs := []string{}
+		for _, validationError := range result.Errors() {
+			errs = append(errs, validationError.String())
+		}
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+
+	data := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(content, &data); err != nil {
+		return err
+	}
+	cfg := &CloudConfig{}
+	if err := util.Convert(data, cfg); err != nil {
+		return err
+	}
+	if errs := mountErrors(cfg); len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// snapshotExisting keeps the current cloud-config.yml, which was not
+// written by ros config, when the config history is empty
+func snapshotExisting(dirPrefix string) error {
+	snapshots, err := Snapshots(dirPrefix)
+	if err != nil || len(snapshots) > 0 {
+		return err
+	}
+
+	content, err := ioutil.ReadFile(path.Join(dirPrefix, CloudConfigFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	_, err = saveSnapshot(dirPrefix, content, 0)
+	return err
+}
+
+// saveSnapshot writes a new snapshot and removes the oldest ones above the
+// retention, except the one that booted cleanly
+func saveSnapshot(dirPrefix string, content []byte, retention int) (string, error) {
+	dir := path.Join(dirPrefix, CloudConfigHistoryDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	name := snapshotPrefix + time.Now().UTC().Format(snapshotTimeFormat) + snapshotSuffix
+	if err := util.WriteFileAtomic(path.Join(dir, name), content, 0400); err != nil {
+		return "", err
+	}
+
+	if retention <= 0 {
+		return name, nil
+	}
+
+	snapshots, err := Snapshots(dirPrefix)
+	if err != nil {
+		return name, err
+	}
+	for i, snapshot := range snapshots {
+		if i < retention || snapshot.Good {
+			continue
+		}
+		if err := os.Remove(path.Join(dir, snapshot.Name)); err != nil {
+			log.Errorf("Failed to remove the snapshot %s: %v", snapshot.Name, err)
+		}
+	}
+	return name, nil
+}
+
+// historyRetention reads the retention from the config, without failing on
+// an invalid config like LoadConfig. A retention of 0 keeps every snapshot.
+func historyRetention() int {
+	cfg := &CloudConfig{}
+	if err := util.Convert(loadRawConfig("", true), cfg); err != nil {
+		return defaultHistoryRetention
+	}
+	return cfg.Rancher.ConfigHistory.Retention
+}
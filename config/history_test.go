@@ -0,0 +1,83 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshots(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	snapshots, err := Snapshots(dir)
+	assert.NoError(err)
+	assert.Empty(snapshots)
+
+	names := []string{}
+	for _, content := range []string{"hostname: a\n", "hostname: b\n", "hostname: c\n"} {
+		name, err := saveSnapshot(dir, []byte(content), 2)
+		assert.NoError(err)
+		names = append(names, name)
+	}
+
+	snapshots, err = Snapshots(dir)
+	assert.NoError(err)
+	assert.Len(snapshots, 2)
+	assert.Equal(names[2], snapshots[0].Name)
+	assert.Equal(names[1], snapshots[1].Name)
+
+	diff, err := SnapshotDiff(dir, snapshots[1].Name, snapshots[0].Name, false)
+	assert.NoError(err)
+	assert.Contains(diff, "-hostname: b\n+hostname: c\n")
+
+	_, err = saveSnapshot(dir, []byte("hostname: d\n"), 0)
+	assert.NoError(err)
+	snapshots, err = Snapshots(dir)
+	assert.NoError(err)
+	assert.Len(snapshots, 3)
+}
+
+func TestRestoreGoodConfig(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, CloudConfigFile)
+	assert.NoError(os.MkdirAll(path.Dir(file), 0700))
+	assert.NoError(ioutil.WriteFile(file, []byte("hostname: good\n"), 0600))
+	assert.NoError(MarkConfigGood(dir))
+
+	restored, err := RestoreGoodConfig(dir)
+	assert.NoError(err)
+	assert.False(restored)
+
+	assert.NoError(ioutil.WriteFile(file, []byte("rancher:\n  bad_key: true\n"), 0600))
+	restored, err = RestoreGoodConfig(dir)
+	assert.NoError(err)
+	assert.True(restored)
+
+	content, err := ioutil.ReadFile(file)
+	assert.NoError(err)
+	assert.Equal("hostname: good\n", string(content))
+
+	snapshots, err := Snapshots(dir)
+	assert.NoError(err)
+	assert.Len(snapshots, 1)
+	assert.True(snapshots[0].Good)
+
+	assert.NoError(ioutil.WriteFile(file, []byte("rancher:\n  bad_key: true\n"), 0600))
+	assert.Error(MarkConfigGood(dir))
+	snapshots, err = Snapshots(dir)
+	assert.NoError(err)
+	assert.Len(snapshots, 1)
+	assert.True(snapshots[0].Good)
+}
@@ -14,7 +14,23 @@ var schema = `{
 		"mounts": {"type": "array"},
 		"rancher": {"$ref": "#/definitions/rancher_config"},
 		"runcmd": {"type": "array"},
-		"bootcmd": {"type": "array"}
+		"bootcmd": {"type": "array"},
+		"users": {
+			"type": "array",
+			"items": {"$ref": "#/definitions/user_config"}
+		},
+		"groups": {
+			"type": "array",
+			"items": {"$ref": "#/definitions/group_config"}
+		},
+		"disk_setup": {
+			"type": "object",
+			"additionalProperties": {"$ref": "#/definitions/disk_setup_config"}
+		},
+		"fs_setup": {
+			"type": "array",
+			"items": {"$ref": "#/definitions/fs_setup_config"}
+		}
 	},
 
 	"definitions": {
@@ -47,6 +63,17 @@ var schema = `{
 				"state": {"$ref": "#/definitions/state_config"},
 				"system_docker": {"$ref": "#/definitions/docker_config"},
 				"upgrade": {"$ref": "#/definitions/upgrade_config"},
+				"time": {"$ref": "#/definitions/time_config"},
+				"mounts": {
+					"type": "object",
+					"additionalProperties": {"$ref": "#/definitions/mount_config"}
+				},
+				"registries": {
+					"type": "object",
+					"additionalProperties": {"$ref": "#/definitions/registry_config"}
+				},
+				"cgroup_mode": {"type": "string", "pattern": "^(v1|hybrid|unified)?$"},
+				"config_history": {"$ref": "#/definitions/config_history_config"},
 				"docker": {"$ref": "#/definitions/docker_config"},
 				"registry_auths": {"type": "object"},
 				"defaults": {"$ref": "#/definitions/defaults_config"},
@@ -56,7 +83,83 @@ var schema = `{
 				"hypervisor_service": {"type": "boolean"},
 				"shutdown_timeout": {"type": "integer"},
 				"http_load_retries": {"type": "integer"},
-				"preload_wait": {"type": "boolean"}
+				"preload_wait": {"type": "boolean"},
+				"init_hooks": {
+					"type": "object",
+					"additionalProperties": false,
+					"patternProperties": {
+						"^(before|after)_[a-z0-9_]+$": {"$ref": "#/definitions/list_of_strings"}
+					}
+				}
+			}
+		},
+
+		"user_config": {
+			"id": "#/definitions/user_config",
+			"type": "object",
+			"additionalProperties": false,
+			"required": ["name"],
+
+			"properties": {
+				"name": {"type": "string", "pattern": "^[a-z_][a-z0-9_.-]*$"},
+				"passwd": {"type": "string"},
+				"ssh_authorized_keys": {"$ref": "#/definitions/list_of_strings"},
+				"gecos": {"type": "string"},
+				"homedir": {"type": "string"},
+				"no_create_home": {"type": "boolean"},
+				"primary_group": {"type": "string"},
+				"groups": {"$ref": "#/definitions/list_of_strings"},
+				"no_user_group": {"type": "boolean"},
+				"system": {"type": "boolean"},
+				"no_log_init": {"type": "boolean"},
+				"shell": {"type": "string"},
+				"sudo": {"oneOf": [
+					{"type": "string"},
+					{"$ref": "#/definitions/list_of_strings"},
+					{"enum": [false]}
+				]},
+				"lock_passwd": {"type": "boolean"}
+			}
+		},
+
+		"group_config": {
+			"id": "#/definitions/group_config",
+			"oneOf": [
+				{"type": "string"},
+				{
+					"type": "object",
+					"minProperties": 1,
+					"maxProperties": 1,
+					"additionalProperties": {"$ref": "#/definitions/list_of_strings"}
+				}
+			]
+		},
+
+		"disk_setup_config": {
+			"id": "#/definitions/disk_setup_config",
+			"type": "object",
+			"additionalProperties": false,
+
+			"properties": {
+				"table_type": {"enum": ["gpt", "mbr"]},
+				"layout": {"type": ["boolean", "array"]},
+				"overwrite": {"type": "boolean"}
+			}
+		},
+
+		"fs_setup_config": {
+			"id": "#/definitions/fs_setup_config",
+			"type": "object",
+			"additionalProperties": false,
+			"required": ["device", "filesystem"],
+
+			"properties": {
+				"label": {"type": "string"},
+				"filesystem": {"type": "string", "pattern": "^[a-z0-9]+$"},
+				"device": {"type": "string"},
+				"partition": {"type": ["string", "integer"]},
+				"overwrite": {"type": "boolean"},
+				"extra_opts": {"$ref": "#/definitions/list_of_strings"}
 			}
 		},
 
@@ -90,7 +193,8 @@ var schema = `{
 				"https_proxy": {"type": "string"},
 				"no_proxy": {"type": "string"},
 				"wifi_networks": {"type": "object"},
-				"modem_networks": {"type": "object"}
+				"modem_networks": {"type": "object"},
+				"netplan": {"type": "object"}
 			}
 		},
 
@@ -103,7 +207,65 @@ var schema = `{
 				"url": {"type": "string"},
 				"image": {"type": "string"},
 				"rollback": {"type": "string"},
-				"policy": {"type": "string"}
+				"trial_boot": {"type": "boolean"},
+				"policy": {"type": "string"},
+				"public_keys": {"$ref": "#/definitions/list_of_strings"}
+			}
+		},
+
+		"time_config": {
+			"id": "#/definitions/time_config",
+			"type": "object",
+			"additionalProperties": false,
+
+			"properties": {
+				"servers": {"$ref": "#/definitions/list_of_hosts"},
+				"pools": {"$ref": "#/definitions/list_of_hosts"},
+				"timezone": {"type": "string", "pattern": "^([A-Za-z0-9_+-]+(/[A-Za-z0-9_+-]+)*)?$"},
+				"sync_timeout": {"type": "integer", "minimum": 0}
+			}
+		},
+
+		"mount_config": {
+			"id": "#/definitions/mount_config",
+			"type": "object",
+			"additionalProperties": false,
+			"required": ["what", "where"],
+
+			"properties": {
+				"what": {"type": "string"},
+				"where": {"type": "string", "pattern": "^(/.*|none)$"},
+				"type": {"type": "string"},
+				"options": {"type": "string"},
+				"required": {"type": "boolean"},
+				"wait_for_network": {"type": "boolean"},
+				"before_services": {"type": "boolean"},
+				"timeout": {"type": "integer", "minimum": 0}
+			}
+		},
+
+		"config_history_config": {
+			"id": "#/definitions/config_history_config",
+			"type": "object",
+			"additionalProperties": false,
+
+			"properties": {
+				"retention": {"type": "integer", "minimum": 0},
+				"auto_rollback": {"type": "boolean"}
+			}
+		},
+
+		"registry_config": {
+			"id": "#/definitions/registry_config",
+			"type": "object",
+			"additionalProperties": false,
+
+			"properties": {
+				"mirrors": {"$ref": "#/definitions/list_of_strings"},
+				"ca": {"type": "string"},
+				"client_cert": {"type": "string"},
+				"client_key": {"type": "string"},
+				"insecure": {"type": "boolean"}
 			}
 		},
 
@@ -180,7 +342,20 @@ var schema = `{
 				"oem_fstype": {"type": "string"},
 				"oem_dev": {"type": "string"},
 				"boot_fstype": {"type": "string"},
-				"boot_dev": {"type": "string"}
+				"boot_dev": {"type": "string"},
+				"encryption": {"$ref": "#/definitions/state_encryption_config"}
+			}
+		},
+
+		"state_encryption_config": {
+			"id": "#/definitions/state_encryption_config",
+			"type": "object",
+			"additionalProperties": false,
+
+			"properties": {
+				"keyfile": {"type": "string"},
+				"key_url": {"type": "string", "pattern": "^(https?://.*)?$"},
+				"keyring_key": {"type": "string"}
 			}
 		},
 
@@ -190,7 +365,8 @@ var schema = `{
 			"additionalProperties": false,
 
 			"properties": {
-				"datasources": {"$ref": "#/definitions/list_of_strings"}
+				"datasources": {"$ref": "#/definitions/list_of_strings"},
+				"imds_v1_fallback": {"type": "boolean"}
 			}
 		},
 
@@ -211,6 +387,12 @@ var schema = `{
 			"type": "array",
 			"items": {"type": "string"},
 			"uniqueItems": true
+		},
+
+		"list_of_hosts": {
+			"type": "array",
+			"items": {"type": "string", "pattern": "^[A-Za-z0-9.:\\[\\]_-]+$"},
+			"uniqueItems": true
 		}
 	}
 }
@@ -0,0 +1,182 @@
+// Package secret seals the secret values of the config to a host key, so
+// that they can be stored in cloud-config.yml and user-data as ENC[...]
+// values. The values are libsodium sealed boxes (crypto_box_seal) to the
+// X25519 host key, so that they can also be sealed off the host, with
+// libsodium or PyNaCl's SealedBox and the base64 public key.
+package secret
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+const (
+	prefix  = "ENC["
+	suffix  = "]"
+	keySize = 32
+)
+
+var (
+	// !secret tags are dropped by the yaml parser, so they are rewritten
+	// to ENC[...] values before parsing. Only a tag that is the value of a
+	// key or a sequence item is rewritten.
+	secretTag = regexp.MustCompile(`^([ \t]*(?:-[ \t]+)*(?:(?:"[^"]*"|'[^']*'|[^\s#'"-][^:#]*):[ \t]+)?)!secret[ \t]+(["']?)([A-Za-z0-9+/=]+)(["']?)([ \t]*(?:#.*)?)$`)
+	// a key or sequence item with a literal or folded block scalar value
+	blockScalar = regexp.MustCompile(`^[ \t]*(?:-[ \t]+)*(?:[^#]*:[ \t]+)?[|>][0-9+-]*[ \t]*(?:#.*)?$`)
+)
+
+// IsEncrypted returns whether the value is an ENC[...] value
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix) && strings.HasSuffix(value, suffix)
+}
+
+// ReplaceTags rewrites the !secret tagged values of a yaml document into
+// ENC[...] values. The content of block scalars, like the files of
+// write_files, is kept as is.
+func ReplaceTags(content []byte) []byte {
+	if !bytes.Contains(content, []byte("!secret")) {
+		return content
+	}
+
+	lines := bytes.Split(content, []byte("\n"))
+	// the indentation of the line that starts a block scalar
+	blockIndent := -1
+	for i, line := range lines {
+		indent := len(line) - len(bytes.TrimLeft(line, " "))
+		if blockIndent >= 0 {
+			if len(bytes.TrimSpace(line)) == 0 || indent > blockIndent {
+				continue
+			}
+			blockIndent = -1
+		}
+
+		if blockScalar.Match(line) {
+			blockIndent = indent
+			continue
+		}
+		lines[i] = secretTag.ReplaceAll(line, []byte("${1}"+prefix+"${3}"+suffix+"${5}"))
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
+
+// Key is an X25519 host key
+type Key struct {
+	PublicKey  [keySize]byte
+	PrivateKey [keySize]byte
+}
+
+// GenerateKey generates a host key
+func GenerateKey() (*Key, error) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{PublicKey: *pub, PrivateKey: *priv}, nil
+}
+
+// Encrypt seals the value to the public key, returning an ENC[...] value
+func Encrypt(pub *[keySize]byte, value []byte) (string, error) {
+	ephemeralPub, ephemeralPriv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	nonce, err := sealNonce(ephemeralPub, pub)
+	if err != nil {
+		return "", err
+	}
+	sealed := box.Seal(append([]byte{}, ephemeralPub[:]...), value, nonce, pub, ephemeralPriv)
+	return prefix + base64.StdEncoding.EncodeToString(sealed) + suffix, nil
+}
+
+// Decrypt opens an ENC[...] value with the host key
+func Decrypt(key *Key, value string) ([]byte, error) {
+	if !IsEncrypted(value) {
+		return nil, errors.New("not an ENC[...] value")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(value[len(prefix) : len(value)-len(suffix)])
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < keySize+box.Overhead {
+		return nil, errors.New("sealed value is too short")
+	}
+
+	var ephemeralPub [keySize]byte
+	copy(ephemeralPub[:], sealed[:keySize])
+	nonce, err := sealNonce(&ephemeralPub, &key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, ok := box.Open(nil, sealed[keySize:], nonce, &ephemeralPub, &key.PrivateKey)
+	if !ok {
+		return nil, errors.New("failed to open the sealed value, it is not sealed to this host key")
+	}
+	return plaintext, nil
+}
+
+// sealNonce returns the nonce of a sealed box, the blake2b hash of the
+// ephemeral and recipient public keys like crypto_box_seal
+func sealNonce(ephemeralPub, pub *[keySize]byte) (*[24]byte, error) {
+	h, err := blake2b.New(24, nil)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(ephemeralPub[:])
+	h.Write(pub[:])
+
+	var nonce [24]byte
+	copy(nonce[:], h.Sum(nil))
+	return &nonce, nil
+}
+
+// MarshalPrivateKey returns the base64 encoding of a host key
+func MarshalPrivateKey(key *Key) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(key.PrivateKey[:]) + "\n")
+}
+
+// ParsePrivateKey parses a base64 encoded host key
+func ParsePrivateKey(data []byte) (*Key, error) {
+	key := &Key{}
+	if err := decodeKey(data, &key.PrivateKey); err != nil {
+		return nil, err
+	}
+	curve25519.ScalarBaseMult(&key.PublicKey, &key.PrivateKey)
+	return key, nil
+}
+
+// MarshalPublicKey returns the base64 encoding of the public key of a host
+// key
+func MarshalPublicKey(pub *[keySize]byte) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(pub[:]) + "\n")
+}
+
+// ParsePublicKey parses a base64 encoded public key
+func ParsePublicKey(data []byte) (*[keySize]byte, error) {
+	var pub [keySize]byte
+	if err := decodeKey(data, &pub); err != nil {
+		return nil, err
+	}
+	return &pub, nil
+}
+
+func decodeKey(data []byte, key *[keySize]byte) error {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return err
+	}
+	if len(decoded) != keySize {
+		return fmt.Errorf("not a %d bytes X25519 key", keySize)
+	}
+	copy(key[:], decoded)
+	return nil
+}
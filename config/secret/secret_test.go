@@ -0,0 +1,97 @@
+package secret
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncrypt(t *testing.T) {
+	assert := require.New(t)
+
+	key, err := GenerateKey()
+	assert.NoError(err)
+
+	encrypted, err := Encrypt(&key.PublicKey, []byte("hunter2"))
+	assert.NoError(err)
+	assert.True(IsEncrypted(encrypted))
+
+	plaintext, err := Decrypt(key, encrypted)
+	assert.NoError(err)
+	assert.Equal("hunter2", string(plaintext))
+
+	other, err := GenerateKey()
+	assert.NoError(err)
+	_, err = Decrypt(other, encrypted)
+	assert.Error(err)
+
+	_, err = Decrypt(key, "ENC[AAAA]")
+	assert.Error(err)
+	_, err = Decrypt(key, "hunter2")
+	assert.Error(err)
+}
+
+func TestDecryptSealedBox(t *testing.T) {
+	assert := require.New(t)
+
+	// sealed by crypto_box_seal, with golang.org/x/crypto/nacl/box
+	// SealAnonymous
+	key, err := ParsePrivateKey([]byte("AQIDBAUGBwgJCgsMDQ4PEBESExQVFhcYGRobHB0eHyA=\n"))
+	assert.NoError(err)
+	assert.Equal("B6N8vBQgk8i3VdwbEOhstCY3StFqqFPtC9/AsrhtHHw=\n", string(MarshalPublicKey(&key.PublicKey)))
+
+	plaintext, err := Decrypt(key, "ENC[JooakBkSIJTvCJGxOu8FLQXgO4rgIAIdSk/NbMRDHRn8OmfG16N+zc7wzFKccCIhkk9lirAWBA==]")
+	assert.NoError(err)
+	assert.Equal("hunter2", string(plaintext))
+}
+
+func TestMarshalKeys(t *testing.T) {
+	assert := require.New(t)
+
+	key, err := GenerateKey()
+	assert.NoError(err)
+
+	parsed, err := ParsePrivateKey(MarshalPrivateKey(key))
+	assert.NoError(err)
+	assert.Equal(key, parsed)
+
+	pub, err := ParsePublicKey(MarshalPublicKey(&key.PublicKey))
+	assert.NoError(err)
+	assert.Equal(key.PublicKey, *pub)
+
+	encrypted, err := Encrypt(pub, []byte("hunter2"))
+	assert.NoError(err)
+	plaintext, err := Decrypt(key, encrypted)
+	assert.NoError(err)
+	assert.Equal("hunter2", string(plaintext))
+
+	_, err = ParsePublicKey([]byte("AAAA"))
+	assert.Error(err)
+}
+
+func TestReplaceTags(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("rancher:\n  password: ENC[AbC+/=]\n  user: rancher\n",
+		string(ReplaceTags([]byte("rancher:\n  password: !secret AbC+/=\n  user: rancher\n"))))
+	assert.Equal("password: ENC[AbC]\n", string(ReplaceTags([]byte("password: !secret \"AbC\"\n"))))
+	assert.Equal("password: hunter2\n", string(ReplaceTags([]byte("password: hunter2\n"))))
+	assert.Equal("users:\n- name: ci\n  passwd: ENC[AbC] # sealed\n- ENC[DeF]\n",
+		string(ReplaceTags([]byte("users:\n- name: ci\n  passwd: !secret AbC # sealed\n- !secret DeF\n"))))
+
+	// the literal text in scripts, files and comments is kept
+	content := `write_files:
+- path: /opt/setup.yml
+  content: |
+    password: !secret AbC
+
+    token: !secret DeF
+- path: /opt/other
+  content: !secret GhI
+runcmd:
+- echo !secret AbC
+# password: !secret AbC
+`
+	assert.Equal(strings.Replace(content, "content: !secret GhI", "content: ENC[GhI]", 1), string(ReplaceTags([]byte(content))))
+}
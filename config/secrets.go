@@ -0,0 +1,127 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/rancher/os/config/secret"
+	"github.com/rancher/os/pkg/log"
+	"github.com/rancher/os/pkg/util"
+)
+
+// HostKey returns the key the ENC[...] values of the config are sealed
+// to, from the state or else the OEM partition. With generate, a missing
+// key is generated on the state.
+func HostKey(generate bool) (*secret.Key, error) {
+	key, err := loadHostKey("")
+	if err == nil || !os.IsNotExist(err) || !generate {
+		return key, err
+	}
+
+	key, err = secret.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(path.Dir(SecretKeyFile), 0700); err != nil {
+		return nil, err
+	}
+	if err := util.WriteFileAtomic(SecretKeyFile, secret.MarshalPrivateKey(key), 0600); err != nil {
+		return nil, err
+	}
+	log.Infof("Generated the host key %s", SecretKeyFile)
+	return key, nil
+}
+
+// Encrypt seals a value to the host key, generating it if needed
+func Encrypt(value []byte) (string, error) {
+	key, err := HostKey(true)
+	if err != nil {
+		return "", err
+	}
+	return secret.Encrypt(&key.PublicKey, value)
+}
+
+// DecryptString returns the plaintext of an ENC[...] value, for the
+// consumers of a config that was not loaded by LoadConfig. Other values,
+// and the ones that can not be decrypted, are returned as is.
+func DecryptString(value string) string {
+	if !secret.IsEncrypted(value) {
+		return value
+	}
+	key, err := loadHostKey("")
+	if err != nil {
+		log.Errorf("Failed to load the host key: %v", err)
+		return value
+	}
+	plaintext, err := secret.Decrypt(key, value)
+	if err != nil {
+		log.Errorf("Failed to decrypt a secret value: %v", err)
+		return value
+	}
+	return string(plaintext)
+}
+
+func loadHostKey(dirPrefix string) (*secret.Key, error) {
+	data, err := ioutil.ReadFile(path.Join(dirPrefix, SecretKeyFile))
+	if os.IsNotExist(err) {
+		data, err = ioutil.ReadFile(OemSecretKeyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return secret.ParsePrivateKey(data)
+}
+
+// decryptSecrets replaces the ENC[...] values of the raw config with their
+// plaintext, which is only kept in memory. The host key is only loaded if
+// there are any.
+func decryptSecrets(dirPrefix string, rawCfg map[interface{}]interface{}) map[interface{}]interface{} {
+	var key *secret.Key
+	var keyErr error
+	loaded := false
+
+	var decrypt func(value interface{}) interface{}
+	decrypt = func(value interface{}) interface{} {
+		switch value := value.(type) {
+		case map[interface{}]interface{}:
+			out := map[interface{}]interface{}{}
+			for k, v := range value {
+				out[k] = decrypt(v)
+			}
+			return out
+		case []interface{}:
+			out := make([]interface{}, len(value))
+			for i, v := range value {
+				out[i] = decrypt(v)
+			}
+			return out
+		case string:
+			if !secret.IsEncrypted(value) {
+				return value
+			}
+			if !loaded {
+				key, keyErr = loadHostKey(dirPrefix)
+				loaded = true
+				if keyErr != nil {
+					log.Errorf("Failed to load the host key to decrypt the config: %v", keyErr)
+				}
+			}
+			if keyErr != nil {
+				return value
+			}
+			plaintext, err := secret.Decrypt(key, value)
+			if err != nil {
+				log.Errorf("Failed to decrypt a secret value of the config: %v", err)
+				return value
+			}
+			return string(plaintext)
+		}
+		return value
+	}
+
+	if rawCfg == nil {
+		return nil
+	}
+	return decrypt(rawCfg).(map[interface{}]interface{})
+}
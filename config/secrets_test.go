@@ -0,0 +1,48 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/rancher/os/config/secret"
+	"github.com/rancher/os/pkg/util"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecryptSecrets(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	key, err := secret.GenerateKey()
+	assert.NoError(err)
+	assert.NoError(os.MkdirAll(path.Dir(path.Join(dir, SecretKeyFile)), 0700))
+	assert.NoError(ioutil.WriteFile(path.Join(dir, SecretKeyFile), secret.MarshalPrivateKey(key), 0600))
+
+	password, err := secret.Encrypt(&key.PublicKey, []byte("hunter2"))
+	assert.NoError(err)
+	auth, err := secret.Encrypt(&key.PublicKey, []byte("dXNlcjpwYXNz"))
+	assert.NoError(err)
+
+	content := []byte("rancher:\n  registry_auths:\n    harbor.example.com:\n      auth: !secret " +
+		auth[len("ENC["):len(auth)-1] + "\n      email: " + password + "\n")
+	rawCfg, err := readConfigs(content, false, true)
+	assert.NoError(err)
+
+	cfg := &CloudConfig{}
+	assert.NoError(util.Convert(decryptSecrets(dir, rawCfg), cfg))
+	assert.Equal("dXNlcjpwYXNz", cfg.Rancher.RegistryAuths["harbor.example.com"].Auth)
+	assert.Equal("hunter2", cfg.Rancher.RegistryAuths["harbor.example.com"].Email)
+
+	// the raw config keeps the ENC[...] values
+	assert.NoError(util.Convert(rawCfg, cfg))
+	assert.Equal(password, cfg.Rancher.RegistryAuths["harbor.example.com"].Email)
+
+	os.Remove(path.Join(dir, SecretKeyFile))
+	assert.Equal(rawCfg, decryptSecrets(dir, rawCfg))
+}
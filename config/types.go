@@ -37,6 +37,7 @@ const (
 	UserDockerLabel    = "io.rancher.user_docker.name"
 	UserDockerNetLabel = "io.rancher.user_docker.net"
 	UserDockerFIPLabel = "io.rancher.user_docker.fix_ip"
+	MountsLabel        = "io.rancher.os.mounts"
 	System             = "system"
 
 	OsConfigFile           = "/usr/share/ros/os-config.yml"
@@ -48,6 +49,9 @@ const (
 	CloudConfigScriptFile  = "/var/lib/rancher/conf/cloud-config-script"
 	MetaDataFile           = "/var/lib/rancher/conf/metadata"
 	CloudConfigFile        = "/var/lib/rancher/conf/cloud-config.yml"
+	CloudConfigHistoryDir  = "/var/lib/rancher/conf/cloud-config-history"
+	SecretKeyFile          = "/var/lib/rancher/conf/secret.key"
+	OemSecretKeyFile       = OemDir + "/secret.key"
 	EtcResolvConfFile      = "/etc/resolv.conf"
 	WPAConfigFile          = "/etc/wpa_supplicant-%s.conf"
 	WPATemplateFile        = "/etc/wpa_supplicant.conf.tpl"
@@ -57,6 +61,11 @@ const (
 	MultiDockerDataDir     = "/var/lib/m-user-docker"
 	UdevRulesDir           = "/etc/udev/rules.d"
 	UdevRulesExtrasDir     = "/lib/udev/rules-extras.d"
+	InitReportFile         = "/var/log/boot/init-report.json"
+	BootEventsFile         = "/var/log/boot/events.jsonl"
+	BootingFile            = "/run/rancheros-booting"
+	NtpConfFile            = "/var/lib/rancher/conf/ntp.conf"
+	KeyringKeyFile         = "/var/lib/rancher/state-keyring.key"
 )
 
 var (
@@ -101,7 +110,8 @@ func init() {
 }
 
 type Repository struct {
-	URL string `yaml:"url,omitempty"`
+	URL        string   `yaml:"url,omitempty"`
+	PublicKeys []string `yaml:"public_keys,omitempty"`
 }
 
 type Repositories map[string]Repository
@@ -114,6 +124,10 @@ type CloudConfig struct {
 	Rancher           RancherConfig         `yaml:"rancher,omitempty"`
 	Runcmd            []yaml.StringandSlice `yaml:"runcmd,omitempty"`
 	Bootcmd           []yaml.StringandSlice `yaml:"bootcmd,omitempty"`
+	Users             []User                `yaml:"users,omitempty"`
+	Groups            []Group               `yaml:"groups,omitempty"`
+	DiskSetup         map[string]DiskSetup  `yaml:"disk_setup,omitempty"`
+	FsSetup           []FsSetup             `yaml:"fs_setup,omitempty"`
 }
 
 type File struct {
@@ -121,6 +135,188 @@ type File struct {
 	Container string `yaml:"container,omitempty"`
 }
 
+// User is a login created in the console. Like cloud-init, the password is
+// locked unless LockPasswd is false, and Sudo are sudoers rules such as
+// "ALL=(ALL) NOPASSWD:ALL".
+type User struct {
+	config.User
+	Sudo       SudoRules `yaml:"sudo,omitempty"`
+	LockPasswd *bool     `yaml:"lock_passwd,omitempty"`
+}
+
+// SudoRules is written as a single rule, a list of rules or false, as with
+// cloud-init
+type SudoRules []string
+
+func (r *SudoRules) UnmarshalYAML(tag string, value interface{}) error {
+	switch value := value.(type) {
+	case bool:
+		if !value {
+			*r = nil
+			return nil
+		}
+	case string:
+		*r = SudoRules{value}
+		return nil
+	case []interface{}:
+		var rules SudoRules
+		for _, v := range value {
+			rule, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("Invalid sudo rule %v", v)
+			}
+			rules = append(rules, rule)
+		}
+		*r = rules
+		return nil
+	}
+	return fmt.Errorf("Failed to unmarshal sudo: %#v", value)
+}
+
+func (r SudoRules) MarshalYAML() (string, interface{}, error) {
+	if len(r) == 1 {
+		return "", r[0], nil
+	}
+	return "", []string(r), nil
+}
+
+// Group is a group created in the console, written either as its name or
+// as a map of its name to its members
+type Group struct {
+	Name    string
+	Members []string
+}
+
+func (g *Group) UnmarshalYAML(tag string, value interface{}) error {
+	switch value := value.(type) {
+	case string:
+		g.Name = value
+		return nil
+	case map[interface{}]interface{}:
+		if len(value) == 1 {
+			for k, v := range value {
+				name, ok := k.(string)
+				if !ok {
+					break
+				}
+				g.Name = name
+				members, ok := v.([]interface{})
+				if !ok && v != nil {
+					break
+				}
+				for _, member := range members {
+					m, ok := member.(string)
+					if !ok {
+						return fmt.Errorf("Invalid member %v of group %s", member, name)
+					}
+					g.Members = append(g.Members, m)
+				}
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("Failed to unmarshal group: %#v", value)
+}
+
+func (g Group) MarshalYAML() (string, interface{}, error) {
+	if len(g.Members) == 0 {
+		return "", g.Name, nil
+	}
+	return "", map[string][]string{g.Name: g.Members}, nil
+}
+
+// DiskSetup is the partition table of a disk, keyed by its device in
+// disk_setup. Like cloud-init, the disk is only partitioned if it has
+// neither a partition table nor a filesystem, unless Overwrite is true.
+type DiskSetup struct {
+	TableType string     `yaml:"table_type,omitempty"`
+	Layout    DiskLayout `yaml:"layout,omitempty"`
+	Overwrite bool       `yaml:"overwrite,omitempty"`
+}
+
+// DiskLayout is written either as true, for a single partition, or as the
+// list of the partitions' sizes in percent of the disk, each optionally
+// with its partition type, such as [[25, 82], 75].
+type DiskLayout []Partition
+
+type Partition struct {
+	Size int
+	Type string
+}
+
+func (l *DiskLayout) UnmarshalYAML(tag string, value interface{}) error {
+	switch value := value.(type) {
+	case bool:
+		*l = nil
+		if value {
+			*l = DiskLayout{{Size: 100}}
+		}
+		return nil
+	case []interface{}:
+		var layout DiskLayout
+		for _, v := range value {
+			var p Partition
+			var ok bool
+			switch v := v.(type) {
+			case []interface{}:
+				if len(v) == 0 || len(v) > 2 {
+					return fmt.Errorf("Invalid partition %v", v)
+				}
+				if len(v) == 2 {
+					p.Type = fmt.Sprint(v[1])
+				}
+				p.Size, ok = toInt(v[0])
+			default:
+				p.Size, ok = toInt(v)
+			}
+			if !ok || p.Size <= 0 || p.Size > 100 {
+				return fmt.Errorf("Invalid partition size %v", v)
+			}
+			layout = append(layout, p)
+		}
+		*l = layout
+		return nil
+	}
+	return fmt.Errorf("Failed to unmarshal disk layout: %#v", value)
+}
+
+func (l DiskLayout) MarshalYAML() (string, interface{}, error) {
+	layout := []interface{}{}
+	for _, p := range l {
+		if p.Type == "" {
+			layout = append(layout, p.Size)
+		} else {
+			layout = append(layout, []interface{}{p.Size, p.Type})
+		}
+	}
+	return "", layout, nil
+}
+
+func toInt(v interface{}) (int, bool) {
+	switch v := v.(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), v == float64(int(v))
+	}
+	return 0, false
+}
+
+// FsSetup is a filesystem created on Device or one of its partitions,
+// which is either its number, "auto" for the first one, "any" for the
+// first one without a filesystem, or "none" for the whole device. Like
+// cloud-init, existing filesystems are kept unless Overwrite is true.
+type FsSetup struct {
+	Label      string   `yaml:"label,omitempty"`
+	Filesystem string   `yaml:"filesystem,omitempty"`
+	Device     string   `yaml:"device,omitempty"`
+	Partition  string   `yaml:"partition,omitempty"`
+	Overwrite  bool     `yaml:"overwrite,omitempty"`
+	ExtraOpts  []string `yaml:"extra_opts,omitempty"`
+}
+
 type RancherConfig struct {
 	Console             string                                    `yaml:"console,omitempty"`
 	Environment         map[string]string                         `yaml:"environment,omitempty"`
@@ -154,13 +350,71 @@ type RancherConfig struct {
 	ShutdownTimeout     int                                       `yaml:"shutdown_timeout,omitempty"`
 	HTTPLoadRetries     int                                       `yaml:"http_load_retries,omitempty"`
 	PreloadWait         bool                                      `yaml:"preload_wait,omitempty"`
+	InitHooks           map[string][]string                       `yaml:"init_hooks,omitempty"`
+	Time                TimeConfig                                `yaml:"time,omitempty"`
+	Mounts              map[string]MountConfig                    `yaml:"mounts,omitempty"`
+	Registries          map[string]RegistryConfig                 `yaml:"registries,omitempty"`
+	CgroupMode          string                                    `yaml:"cgroup_mode,omitempty"`
+	ConfigHistory       ConfigHistoryConfig                       `yaml:"config_history,omitempty"`
+}
+
+// ConfigHistoryConfig is the snapshots of cloud-config.yml kept by ros
+// config set and merge. Retention is how many are kept, 10 unless set and
+// every one with 0; the snapshot that booted cleanly is never removed. With
+// AutoRollback, init restores the last snapshot that booted cleanly when
+// cloud-config.yml fails the schema validation.
+type ConfigHistoryConfig struct {
+	Retention    int  `yaml:"retention,omitempty"`
+	AutoRollback bool `yaml:"auto_rollback,omitempty"`
+}
+
+// RegistryConfig is a registry of rancher.registries, keyed by its
+// host[:port], rendered into the certs.d directory and daemon.json of
+// System Docker and the user engine. CA, ClientCert and ClientKey are PEM.
+// Docker only pulls through the Mirrors of docker.io.
+type RegistryConfig struct {
+	Mirrors    []string `yaml:"mirrors,omitempty"`
+	CA         string   `yaml:"ca,omitempty"`
+	ClientCert string   `yaml:"client_cert,omitempty"`
+	ClientKey  string   `yaml:"client_key,omitempty"`
+	Insecure   bool     `yaml:"insecure,omitempty"`
 }
 
+// MountConfig is a mount of rancher.mounts. The mounts that are
+// BeforeServices are mounted by init before System Docker starts, the
+// others in the console, or by the first service that depends on them with
+// the io.rancher.os.mounts label. Timeout is how long to wait in seconds for
+// the device, or the network when WaitForNetwork is set. A Required mount
+// that fails stops the boot, or its services, instead of being logged.
+// disk_setup and fs_setup run in the console, after init, so a
+// BeforeServices mount on a disk or filesystem they set up fails validation.
+type MountConfig struct {
+	What           string `yaml:"what,omitempty"`
+	Where          string `yaml:"where,omitempty"`
+	Type           string `yaml:"type,omitempty"`
+	Options        string `yaml:"options,omitempty"`
+	Required       bool   `yaml:"required,omitempty"`
+	WaitForNetwork bool   `yaml:"wait_for_network,omitempty"`
+	BeforeServices bool   `yaml:"before_services,omitempty"`
+	Timeout        int    `yaml:"timeout,omitempty"`
+}
+
+type TimeConfig struct {
+	Servers     []string `yaml:"servers,omitempty"`
+	Pools       []string `yaml:"pools,omitempty"`
+	Timezone    string   `yaml:"timezone,omitempty"`
+	SyncTimeout int      `yaml:"sync_timeout,omitempty"`
+}
+
+// UpgradeConfig is rancher.upgrade. With TrialBoot, ros os upgrade boots the
+// new version once and falls back to the running one if it fails to boot.
 type UpgradeConfig struct {
-	URL      string `yaml:"url,omitempty"`
-	Image    string `yaml:"image,omitempty"`
-	Rollback string `yaml:"rollback,omitempty"`
-	Policy   string `yaml:"policy,omitempty"`
+	URL        string   `yaml:"url,omitempty"`
+	Image      string   `yaml:"image,omitempty"`
+	Rollback   string   `yaml:"rollback,omitempty"`
+	TrialBoot  bool     `yaml:"trial_boot,omitempty"`
+	Policy     string   `yaml:"policy,omitempty"`
+	PublicKeys []string `yaml:"public_keys,omitempty"`
 }
 
 type EngineOpts struct {
@@ -208,25 +462,39 @@ type SSHConfig struct {
 }
 
 type StateConfig struct {
-	Directory  string   `yaml:"directory,omitempty"`
-	FsType     string   `yaml:"fstype,omitempty"`
-	Dev        string   `yaml:"dev,omitempty"`
-	Wait       bool     `yaml:"wait,omitempty"`
-	Required   bool     `yaml:"required,omitempty"`
-	Autoformat []string `yaml:"autoformat,omitempty"`
-	MdadmScan  bool     `yaml:"mdadm_scan,omitempty"`
-	LvmScan    bool     `yaml:"lvm_scan,omitempty"`
-	Cryptsetup bool     `yaml:"cryptsetup,omitempty"`
-	Rngd       bool     `yaml:"rngd,omitempty"`
-	Script     string   `yaml:"script,omitempty"`
-	OemFsType  string   `yaml:"oem_fstype,omitempty"`
-	OemDev     string   `yaml:"oem_dev,omitempty"`
-	BootFsType string   `yaml:"boot_fstype,omitempty"`
-	BootDev    string   `yaml:"boot_dev,omitempty"`
+	Directory  string          `yaml:"directory,omitempty"`
+	FsType     string          `yaml:"fstype,omitempty"`
+	Dev        string          `yaml:"dev,omitempty"`
+	Wait       bool            `yaml:"wait,omitempty"`
+	Required   bool            `yaml:"required,omitempty"`
+	Autoformat []string        `yaml:"autoformat,omitempty"`
+	MdadmScan  bool            `yaml:"mdadm_scan,omitempty"`
+	LvmScan    bool            `yaml:"lvm_scan,omitempty"`
+	Cryptsetup bool            `yaml:"cryptsetup,omitempty"`
+	Rngd       bool            `yaml:"rngd,omitempty"`
+	Script     string          `yaml:"script,omitempty"`
+	OemFsType  string          `yaml:"oem_fstype,omitempty"`
+	OemDev     string          `yaml:"oem_dev,omitempty"`
+	BootFsType string          `yaml:"boot_fstype,omitempty"`
+	BootDev    string          `yaml:"boot_dev,omitempty"`
+	Encryption StateEncryption `yaml:"encryption,omitempty"`
+}
+
+// StateEncryption unlocks the LUKS state device at boot without a prompt,
+// with the first of its keys that works: Keyfile, relative to the OEM
+// partition unless absolute, the key served at KeyURL, or the user key
+// KeyringKey of the kernel keyrings of init, which init reads for the
+// bootstrap container into KeyringKeyFile. As the state is not readable
+// yet, it is set on the kernel cmdline or in the OEM config.
+type StateEncryption struct {
+	Keyfile    string `yaml:"keyfile,omitempty"`
+	KeyURL     string `yaml:"key_url,omitempty"`
+	KeyringKey string `yaml:"keyring_key,omitempty"`
 }
 
 type CloudInit struct {
-	Datasources []string `yaml:"datasources,omitempty"`
+	Datasources    []string `yaml:"datasources,omitempty"`
+	IMDSv1Fallback bool     `yaml:"imds_v1_fallback,omitempty"`
 }
 
 type Defaults struct {
@@ -1,6 +1,12 @@
 package config
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rancher/os/pkg/util"
+
 	yaml "github.com/cloudfoundry-incubator/candiedyaml"
 	"github.com/xeipuuv/gojsonschema"
 )
@@ -45,3 +51,80 @@ func ValidateRawCfg(rawCfg interface{}) (*gojsonschema.Result, error) {
 	schemaLoader := gojsonschema.NewStringLoader(schema)
 	return gojsonschema.Validate(schemaLoader, loader)
 }
+
+// ValidateMounts returns the errors of the mounts that the schema can not
+// tell, nothing if the config can not be parsed as the schema reports it
+func ValidateMounts(content []byte) []string {
+	data := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(content, &data); err != nil {
+		return []string{}
+	}
+	cfg := &CloudConfig{}
+	if err := util.Convert(data, cfg); err != nil {
+		return []string{}
+	}
+	return mountErrors(cfg)
+}
+
+// mountErrors rejects the before_services mounts on a disk of disk_setup or
+// a filesystem of fs_setup. Init mounts them before System Docker starts,
+// but disk_setup and fs_setup only run later in the console.
+func mountErrors(cfg *CloudConfig) []string {
+	names := []string{}
+	for name := range cfg.Rancher.Mounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	errs := []string{}
+	for _, name := range names {
+		m := cfg.Rancher.Mounts[name]
+		if !m.BeforeServices || m.WaitForNetwork {
+			continue
+		}
+		if setup := setupOf(cfg, m.What); setup != "" {
+			errs = append(errs, fmt.Sprintf("rancher.mounts.%s: %s is set up by %s, which runs after the before_services mounts", name, m.What, setup))
+		}
+	}
+	return errs
+}
+
+// setupOf returns disk_setup or fs_setup when it sets up what, a device,
+// one of its partitions, or the LABEL= of a filesystem
+func setupOf(cfg *CloudConfig, what string) string {
+	for _, fs := range cfg.FsSetup {
+		if fs.Label != "" && what == "LABEL="+fs.Label {
+			return "fs_setup"
+		}
+		if fs.Device != "" && onDevice(what, fs.Device) {
+			return "fs_setup"
+		}
+	}
+	for device := range cfg.DiskSetup {
+		if onDevice(what, device) {
+			return "disk_setup"
+		}
+	}
+	return ""
+}
+
+// onDevice returns whether what is the device or one of its partitions,
+// such as /dev/sdb1 or /dev/nvme0n1p1 of /dev/sdb and /dev/nvme0n1
+func onDevice(what, device string) bool {
+	if !strings.HasPrefix(what, device) {
+		return false
+	}
+	if what == device {
+		return true
+	}
+	partition := strings.TrimPrefix(strings.TrimPrefix(what, device), "p")
+	if partition == "" {
+		return false
+	}
+	for _, c := range partition {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -1,6 +1,11 @@
 #!/bin/sh
 set -ex
+CONF=/etc/ntp.conf
+# written by ros from rancher.time
+if [ -f /var/lib/rancher/conf/ntp.conf ]; then
+    CONF=/var/lib/rancher/conf/ntp.conf
+fi
 echo "starting in one shot mode to fix large time differences"
-ntpd -gq
+ntpd -gq -c $CONF
 echo "starting long running nptd"
-exec ntpd --nofork -g
+exec ntpd --nofork -g -c $CONF
@@ -0,0 +1,223 @@
+package dfs
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/rancher/os/pkg/log"
+)
+
+const (
+	cgroupRoot        = "/sys/fs/cgroup"
+	cgroup2SuperMagic = 0x63677270
+	cgroupDriverOpt   = "native.cgroupdriver"
+)
+
+// the first docker release that runs containers on cgroup v2
+var unifiedCgroupDockerVersion = [2]int{20, 10}
+
+var dockerVersionRegexp = regexp.MustCompile(`(\d+)\.(\d+)`)
+
+// CgroupMode returns the cgroup mode to mount for a rancher.cgroup_mode.
+// The unified mode falls back to hybrid when the docker binary, or the
+// user docker engine, is older than docker 20.10 and would not be able to
+// start containers on cgroup v2.
+func CgroupMode(mode, dockerBin, engine string) string {
+	if mode != "unified" {
+		return mode
+	}
+
+	versions := []string{dockerVersion(dockerBin)}
+	if engine != "" {
+		versions = append(versions, engine)
+	}
+	if err := checkUnifiedCgroup(versions...); err != nil {
+		log.Errorf("Not mounting the unified cgroup hierarchy, falling back to hybrid: %v", err)
+		return "hybrid"
+	}
+	return mode
+}
+
+// CgroupDriver returns the cgroup driver of System Docker and the user
+// engine for a cgroup mode. It is only set in the unified mode, where
+// docker picks the systemd driver on cgroup v2 when it finds
+// /run/systemd/system, which a console can create while RancherOS does
+// not run systemd. Docker uses cgroupfs on cgroup v1.
+func CgroupDriver(mode string) string {
+	if mode != "unified" {
+		return ""
+	}
+	return "cgroupfs"
+}
+
+// UnifiedCgroup returns whether the host only has the cgroup2 hierarchy,
+// which is also seen from a container
+func UnifiedCgroup() bool {
+	data, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return false
+	}
+	return unifiedCgroup(string(data))
+}
+
+// unifiedCgroup returns whether all the hierarchies of a /proc/self/cgroup
+// are the cgroup2 one, 0::/path
+func unifiedCgroup(procCgroup string) bool {
+	procCgroup = strings.TrimSpace(procCgroup)
+	if procCgroup == "" {
+		return false
+	}
+	for _, line := range strings.Split(procCgroup, "\n") {
+		if !strings.HasPrefix(line, "0::") {
+			return false
+		}
+	}
+	return true
+}
+
+// checkUnifiedCgroup returns an error if one of the docker versions, like
+// 17.06.2-ce or docker-19.03.8, does not support cgroup v2
+func checkUnifiedCgroup(versions ...string) error {
+	for _, version := range versions {
+		match := dockerVersionRegexp.FindStringSubmatch(version)
+		if match == nil {
+			return fmt.Errorf("unknown docker version %q, cgroup v2 needs docker %d.%d or later", version, unifiedCgroupDockerVersion[0], unifiedCgroupDockerVersion[1])
+		}
+		major, _ := strconv.Atoi(match[1])
+		minor, _ := strconv.Atoi(match[2])
+		if major < unifiedCgroupDockerVersion[0] || (major == unifiedCgroupDockerVersion[0] && minor < unifiedCgroupDockerVersion[1]) {
+			return fmt.Errorf("%s does not support cgroup v2, which needs docker %d.%d or later", version, unifiedCgroupDockerVersion[0], unifiedCgroupDockerVersion[1])
+		}
+	}
+	return nil
+}
+
+// dockerVersion returns the version printed by a docker binary, like
+// "Docker version 17.06.2-ce, build 3a8d1a2"
+func dockerVersion(bin string) string {
+	output, err := exec.Command(bin, "--version").Output()
+	if err != nil {
+		log.Errorf("Failed to get the version of %s: %v", bin, err)
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// mountUnifiedCgroup mounts cgroup2 on /sys/fs/cgroup and enables its
+// controllers. The cgroup v1 hierarchies mounted by an earlier stage,
+// before the mode was read from the cloud-config, are unmounted first.
+func mountUnifiedCgroup() error {
+	if isCgroup2(cgroupRoot) {
+		log.Debugf("%s is already mounted as cgroup2", cgroupRoot)
+		return nil
+	}
+
+	if err := unmountCgroups(); err != nil {
+		return err
+	}
+
+	if err := createMounts([]string{"none", cgroupRoot, "cgroup2", ""}); err != nil {
+		return err
+	}
+
+	return enableControllers(cgroupRoot)
+}
+
+// enableControllers enables the available controllers for the children of
+// a cgroup. They are enabled one by one, so that one that is still used by
+// a cgroup v1 hierarchy does not prevent the others from being enabled.
+func enableControllers(dir string) error {
+	data, err := ioutil.ReadFile(path.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return err
+	}
+
+	for _, controller := range strings.Fields(string(data)) {
+		if err := writeSubtreeControl(dir, "+"+controller); err != nil {
+			log.Errorf("Failed to enable the %s cgroup controller: %v", controller, err)
+		}
+	}
+
+	return nil
+}
+
+func writeSubtreeControl(dir, value string) error {
+	f, err := os.OpenFile(path.Join(dir, "cgroup.subtree_control"), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(value)
+	return err
+}
+
+func isCgroup2(dir string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return false
+	}
+	return st.Type == cgroup2SuperMagic
+}
+
+// unmountCgroups unmounts everything mounted on and under /sys/fs/cgroup,
+// the deepest first
+func unmountCgroups() error {
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	targets := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if fields[1] == cgroupRoot || strings.HasPrefix(fields[1], cgroupRoot+"/") {
+			targets = append(targets, fields[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	sort.Slice(targets, func(i, j int) bool {
+		return len(targets[i]) > len(targets[j])
+	})
+
+	for _, target := range targets {
+		log.Debugf("Unmounting %s", target)
+		if err := syscall.Unmount(target, 0); err != nil {
+			return fmt.Errorf("failed to unmount %s: %v", target, err)
+		}
+	}
+
+	return nil
+}
+
+// setCgroupDriver sets the native.cgroupdriver of the exec-opts of a
+// daemon.json, keeping the other options
+func setCgroupDriver(daemonConfig map[string]interface{}, driver string) {
+	opts := []interface{}{}
+	if existing, ok := daemonConfig["exec-opts"].([]interface{}); ok {
+		for _, opt := range existing {
+			if s, ok := opt.(string); ok && strings.HasPrefix(s, cgroupDriverOpt+"=") {
+				continue
+			}
+			opts = append(opts, opt)
+		}
+	}
+	daemonConfig["exec-opts"] = append(opts, cgroupDriverOpt+"="+driver)
+}
@@ -0,0 +1,85 @@
+package dfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetCgroupDriver(t *testing.T) {
+	assert := require.New(t)
+
+	daemonConfig := map[string]interface{}{}
+	setCgroupDriver(daemonConfig, "cgroupfs")
+	assert.Equal([]interface{}{"native.cgroupdriver=cgroupfs"}, daemonConfig["exec-opts"])
+
+	daemonConfig = map[string]interface{}{
+		"exec-opts": []interface{}{"native.cgroupdriver=systemd", "native.umask=normal"},
+	}
+	setCgroupDriver(daemonConfig, "cgroupfs")
+	assert.Equal([]interface{}{"native.umask=normal", "native.cgroupdriver=cgroupfs"}, daemonConfig["exec-opts"])
+}
+
+func TestCgroupDriver(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("", CgroupDriver(""))
+	assert.Equal("cgroupfs", CgroupDriver("unified"))
+	assert.Equal("", CgroupDriver("hybrid"))
+}
+
+func TestEnableControllers(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dfs")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(ioutil.WriteFile(path.Join(dir, "cgroup.controllers"), []byte("cpuset cpu io memory pids\n"), 0644))
+	assert.NoError(ioutil.WriteFile(path.Join(dir, "cgroup.subtree_control"), []byte{}, 0644))
+	assert.NoError(enableControllers(dir))
+
+	data, err := ioutil.ReadFile(path.Join(dir, "cgroup.subtree_control"))
+	assert.NoError(err)
+	assert.Equal("+cpuset+cpu+io+memory+pids", string(data))
+}
+
+func TestCheckUnifiedCgroup(t *testing.T) {
+	assert := require.New(t)
+
+	assert.NoError(checkUnifiedCgroup("Docker version 20.10.7, build f0df350", "docker-24.0.6"))
+	assert.NoError(checkUnifiedCgroup())
+
+	// the System Docker and user docker engine of this release
+	err := checkUnifiedCgroup("Docker version 17.06.2-ce, build 3a8d1a2", "docker-19.03.8")
+	assert.Error(err)
+	assert.Contains(err.Error(), "17.06.2-ce")
+
+	err = checkUnifiedCgroup("Docker version 20.10.7, build f0df350", "docker-19.03.8")
+	assert.Error(err)
+	assert.Contains(err.Error(), "docker-19.03.8")
+
+	assert.Error(checkUnifiedCgroup(""))
+}
+
+func TestCgroupMode(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("", CgroupMode("", "/nonexistent/dockerd", ""))
+	assert.Equal("v1", CgroupMode("v1", "/nonexistent/dockerd", ""))
+	assert.Equal("hybrid", CgroupMode("hybrid", "/nonexistent/dockerd", ""))
+	// the version of the docker binary can not be checked
+	assert.Equal("hybrid", CgroupMode("unified", "/nonexistent/dockerd", "docker-24.0.6"))
+}
+
+func TestUnifiedCgroup(t *testing.T) {
+	assert := require.New(t)
+
+	assert.True(unifiedCgroup("0::/init.scope\n"))
+	assert.False(unifiedCgroup("12:pids:/\n11:memory:/\n0::/\n"))
+	assert.False(unifiedCgroup("12:pids:/\n11:memory:/\n"))
+	assert.False(unifiedCgroup(""))
+}
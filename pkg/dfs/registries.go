@@ -0,0 +1,153 @@
+package dfs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	rancherConfig "github.com/rancher/os/config"
+	"github.com/rancher/os/pkg/log"
+	"github.com/rancher/os/pkg/util"
+)
+
+const (
+	certsDirectory      = "/etc/docker/certs.d"
+	defaultDaemonConfig = "/etc/docker/daemon.json"
+	dockerHub           = "docker.io"
+	managedCertsFile    = ".rancher-registries"
+)
+
+// writeRegistryCerts writes the certs.d directory of each registry with a
+// CA or client certificate, as docker expects them. The directories written
+// are listed in the managedCertsFile of dir, so the ones of registries that
+// were removed from the config are removed too, leaving the others alone.
+func writeRegistryCerts(dir string, registries map[string]rancherConfig.RegistryConfig) error {
+	previous := map[string]bool{}
+	data, err := ioutil.ReadFile(path.Join(dir, managedCertsFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, name := range strings.Fields(string(data)) {
+		previous[name] = true
+	}
+
+	names := []string{}
+	for name, registry := range registries {
+		files := []struct {
+			name    string
+			content string
+			perm    os.FileMode
+		}{
+			{"ca.crt", registry.CA, 0644},
+			{"client.cert", registry.ClientCert, 0644},
+			{"client.key", registry.ClientKey, 0600},
+		}
+		written := false
+		for _, file := range files {
+			if file.content == "" {
+				continue
+			}
+			if err := os.MkdirAll(path.Join(dir, name), 0755); err != nil {
+				return err
+			}
+			if err := util.WriteFileAtomic(path.Join(dir, name, file.name), []byte(file.content), file.perm); err != nil {
+				return err
+			}
+			written = true
+		}
+		if written {
+			names = append(names, name)
+			delete(previous, name)
+		}
+	}
+	sort.Strings(names)
+
+	for name := range previous {
+		if strings.Contains(name, "/") || name == "." || name == ".." {
+			continue
+		}
+		log.Infof("Removing the certificates of %s, it is no longer in rancher.registries", name)
+		if err := os.RemoveAll(path.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+
+	if len(names) == 0 {
+		if err := os.Remove(path.Join(dir, managedCertsFile)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return util.WriteFileAtomic(path.Join(dir, managedCertsFile), []byte(strings.Join(names, "\n")+"\n"), 0644)
+}
+
+// registryDaemonConfig returns the registry-mirrors and insecure-registries
+// of daemon.json, only the ones that are configured. Docker refuses to start
+// when an option is set both as a flag and in daemon.json, so the ones set as
+// flags are left out.
+func registryDaemonConfig(registries map[string]rancherConfig.RegistryConfig, mirrorFlag, insecureFlag bool) map[string]interface{} {
+	names := []string{}
+	for name := range registries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	mirrors := []string{}
+	insecure := []string{}
+	for _, name := range names {
+		registry := registries[name]
+		if len(registry.Mirrors) > 0 {
+			if name == dockerHub {
+				mirrors = append(mirrors, registry.Mirrors...)
+			} else {
+				log.Warnf("Ignoring the mirrors of %s, docker only supports mirrors of %s", name, dockerHub)
+			}
+		}
+		if registry.Insecure {
+			insecure = append(insecure, name)
+		}
+	}
+
+	daemonConfig := map[string]interface{}{}
+	if len(mirrors) > 0 {
+		if mirrorFlag {
+			log.Warnf("Not writing the %s mirrors to daemon.json as --registry-mirror is set", dockerHub)
+		} else {
+			daemonConfig["registry-mirrors"] = mirrors
+		}
+	}
+	if len(insecure) > 0 {
+		if insecureFlag {
+			log.Warnf("Not writing the insecure registries to daemon.json as --insecure-registry is set")
+		} else {
+			daemonConfig["insecure-registries"] = insecure
+		}
+	}
+	return daemonConfig
+}
+
+// mergeDaemonConfig updates the daemon.json, keeping the keys that are not
+// set by RancherOS
+func mergeDaemonConfig(file string, update func(map[string]interface{})) error {
+	daemonConfig := map[string]interface{}{}
+	data, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &daemonConfig); err != nil {
+			return err
+		}
+	}
+
+	update(daemonConfig)
+
+	data, err = json.MarshalIndent(daemonConfig, "", "  ")
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(file, data, 0600)
+}
@@ -0,0 +1,96 @@
+package dfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	rancherConfig "github.com/rancher/os/config"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryDaemonConfig(t *testing.T) {
+	assert := require.New(t)
+
+	registries := map[string]rancherConfig.RegistryConfig{
+		"docker.io":          {Mirrors: []string{"https://harbor.example.com"}},
+		"quay.io":            {Mirrors: []string{"https://harbor.example.com"}},
+		"registry.lab:5000":  {Insecure: true},
+		"harbor.example.com": {CA: "ca"},
+	}
+
+	assert.Equal(map[string]interface{}{
+		"registry-mirrors":    []string{"https://harbor.example.com"},
+		"insecure-registries": []string{"registry.lab:5000"},
+	}, registryDaemonConfig(registries, false, false))
+
+	assert.Equal(map[string]interface{}{
+		"insecure-registries": []string{"registry.lab:5000"},
+	}, registryDaemonConfig(registries, true, false))
+
+	assert.Equal(map[string]interface{}{}, registryDaemonConfig(map[string]rancherConfig.RegistryConfig{
+		"harbor.example.com": {CA: "ca"},
+	}, false, false))
+}
+
+func TestMergeDaemonConfig(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dfs")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "daemon.json")
+	assert.NoError(ioutil.WriteFile(file, []byte(`{"debug": true, "registry-mirrors": ["https://old.example.com"]}`), 0600))
+	assert.NoError(mergeDaemonConfig(file, func(daemonConfig map[string]interface{}) {
+		daemonConfig["registry-mirrors"] = []string{"https://harbor.example.com"}
+	}))
+
+	data, err := ioutil.ReadFile(file)
+	assert.NoError(err)
+	assert.JSONEq(`{"debug": true, "registry-mirrors": ["https://harbor.example.com"]}`, string(data))
+}
+
+func TestWriteRegistryCerts(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dfs")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(writeRegistryCerts(dir, map[string]rancherConfig.RegistryConfig{
+		"harbor.example.com": {CA: "ca", ClientCert: "cert", ClientKey: "key"},
+		"registry.lab:5000":  {Insecure: true},
+	}))
+
+	for name, content := range map[string]string{"ca.crt": "ca", "client.cert": "cert", "client.key": "key"} {
+		data, err := ioutil.ReadFile(path.Join(dir, "harbor.example.com", name))
+		assert.NoError(err)
+		assert.Equal(content, string(data))
+	}
+	info, err := os.Stat(path.Join(dir, "harbor.example.com", "client.key"))
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	_, err = os.Stat(path.Join(dir, "registry.lab:5000"))
+	assert.True(os.IsNotExist(err))
+
+	assert.NoError(os.MkdirAll(path.Join(dir, "manual.example.com"), 0755))
+	assert.NoError(writeRegistryCerts(dir, map[string]rancherConfig.RegistryConfig{
+		"quay.io": {CA: "ca"},
+	}))
+	_, err = os.Stat(path.Join(dir, "harbor.example.com"))
+	assert.True(os.IsNotExist(err))
+	_, err = os.Stat(path.Join(dir, "quay.io", "ca.crt"))
+	assert.NoError(err)
+	_, err = os.Stat(path.Join(dir, "manual.example.com"))
+	assert.NoError(err)
+
+	assert.NoError(writeRegistryCerts(dir, nil))
+	_, err = os.Stat(path.Join(dir, "quay.io"))
+	assert.True(os.IsNotExist(err))
+	_, err = os.Stat(path.Join(dir, managedCertsFile))
+	assert.True(os.IsNotExist(err))
+}
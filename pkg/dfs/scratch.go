@@ -2,6 +2,7 @@ package dfs
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -11,6 +12,7 @@ import (
 	"strings"
 	"syscall"
 
+	rancherConfig "github.com/rancher/os/config"
 	"github.com/rancher/os/config/cmdline"
 	"github.com/rancher/os/pkg/init/one"
 	"github.com/rancher/os/pkg/log"
@@ -37,7 +39,6 @@ var (
 		{"none", "/proc", "proc", ""},
 		{"none", "/run", "tmpfs", ""},
 		{"none", "/sys", "sysfs", ""},
-		{"none", "/sys/fs/cgroup", "tmpfs", ""},
 	}
 	optionalMounts = [][]string{
 		{"none", "/sys/fs/selinux", "selinuxfs", "ro"},
@@ -53,12 +54,20 @@ type Config struct {
 	BridgeAddress   string
 	BridgeMtu       int
 	CgroupHierarchy map[string]string
+	CgroupMode      string
+	CgroupDriver    string
 	LogFile         string
 	NoLog           bool
 	NoFiles         uint64
 	Environment     []string
 	GraphDirectory  string
 	DaemonConfig    string
+	Registries      map[string]rancherConfig.RegistryConfig
+	// whether the registry options are set as flags, which docker does
+	// not allow to also be set in the daemon config
+	RegistryMirrorFlag   bool
+	InsecureRegistryFlag bool
+	CgroupDriverFlag     bool
 }
 
 func createMounts(mounts ...[]string) error {
@@ -97,7 +106,23 @@ func createDirs(dirs ...string) error {
 	return nil
 }
 
-func mountCgroups(hierarchyConfig map[string]string) error {
+func mountCgroups(mode string, hierarchyConfig map[string]string) error {
+	switch mode {
+	case "unified":
+		return mountUnifiedCgroup()
+	case "", "v1", "hybrid":
+	default:
+		return fmt.Errorf("unsupported cgroup mode %s", mode)
+	}
+
+	if isCgroup2(cgroupRoot) {
+		return fmt.Errorf("%s is mounted as cgroup2, can not mount the cgroup v1 hierarchies", cgroupRoot)
+	}
+
+	if err := createMounts([]string{"none", cgroupRoot, "tmpfs", ""}); err != nil {
+		return err
+	}
+
 	f, err := os.Open("/proc/cgroups")
 	if err != nil {
 		return err
@@ -139,6 +164,12 @@ func mountCgroups(hierarchyConfig map[string]string) error {
 		return err
 	}
 
+	if mode == "hybrid" {
+		if err := createMounts([]string{"none", path.Join(cgroupRoot, "unified"), "cgroup2", ""}); err != nil {
+			return err
+		}
+	}
+
 	log.Debug("Done mouting cgroupfs")
 	return nil
 }
@@ -415,6 +446,12 @@ func ParseConfig(config *Config, args ...string) []string {
 			}
 		} else if strings.HasPrefix(arg, "-g") || strings.HasPrefix(arg, "--graph") {
 			config.GraphDirectory = GetValue(i, args)
+		} else if strings.HasPrefix(arg, "--registry-mirror") {
+			config.RegistryMirrorFlag = true
+		} else if strings.HasPrefix(arg, "--insecure-registry") {
+			config.InsecureRegistryFlag = true
+		} else if strings.HasPrefix(arg, "--exec-opt") && strings.HasPrefix(GetValue(i, args), cgroupDriverOpt) {
+			config.CgroupDriverFlag = true
 		}
 	}
 
@@ -450,7 +487,7 @@ func PrepareFs(config *Config) error {
 
 	createOptionalMounts(optionalMounts...)
 
-	if err := mountCgroups(config.CgroupHierarchy); err != nil {
+	if err := mountCgroups(config.CgroupMode, config.CgroupHierarchy); err != nil {
 		return err
 	}
 
@@ -493,19 +530,49 @@ func touchSockets(args ...string) error {
 }
 
 func createDaemonConfig(config *Config) error {
-	if config.DaemonConfig == "" {
-		return nil
+	// Before any early return, so the certificates of removed registries go too
+	if err := writeRegistryCerts(certsDirectory, config.Registries); err != nil {
+		return err
+	}
+
+	cgroupDriver := config.CgroupDriver
+	if cgroupDriver != "" && config.CgroupDriverFlag {
+		log.Warnf("Not writing the cgroup driver to daemon.json as --exec-opt %s is set", cgroupDriverOpt)
+		cgroupDriver = ""
 	}
 
-	if _, err := os.Stat(config.DaemonConfig); os.IsNotExist(err) {
-		if err := os.MkdirAll(path.Dir(config.DaemonConfig), 0755); err != nil {
+	daemonConfig := config.DaemonConfig
+	if daemonConfig == "" {
+		if len(config.Registries) == 0 && cgroupDriver == "" {
+			return nil
+		}
+		daemonConfig = defaultDaemonConfig
+	}
+
+	if _, err := os.Stat(daemonConfig); os.IsNotExist(err) {
+		if err := os.MkdirAll(path.Dir(daemonConfig), 0755); err != nil {
 			return err
 		}
 
-		return ioutil.WriteFile(config.DaemonConfig, []byte("{}"), 0600)
+		if err := ioutil.WriteFile(daemonConfig, []byte("{}"), 0600); err != nil {
+			return err
+		}
 	}
 
-	return nil
+	if len(config.Registries) == 0 && cgroupDriver == "" {
+		return nil
+	}
+
+	return mergeDaemonConfig(daemonConfig, func(daemonConfig map[string]interface{}) {
+		if len(config.Registries) > 0 {
+			for k, v := range registryDaemonConfig(config.Registries, config.RegistryMirrorFlag, config.InsecureRegistryFlag) {
+				daemonConfig[k] = v
+			}
+		}
+		if cgroupDriver != "" {
+			setCgroupDriver(daemonConfig, cgroupDriver)
+		}
+	})
 }
 
 func cleanupFiles(graphDirectory string) {
@@ -730,6 +797,16 @@ func Main() {
 
 	log.Debugf("Launch config %#v", config)
 
+	cfg := rancherConfig.LoadConfig()
+	config.CgroupMode = cfg.Rancher.CgroupMode
+	if config.CgroupMode == "unified" && !UnifiedCgroup() {
+		// init fell back to the hybrid mode for System Docker
+		config.CgroupMode = "hybrid"
+	}
+	config.CgroupDriver = CgroupDriver(config.CgroupMode)
+	// set after logging the config, as it has the client keys
+	config.Registries = cfg.Rancher.Registries
+
 	_, err := LaunchDocker(&config, os.Args[1], args...)
 	if err != nil {
 		log.Fatal(err)
@@ -74,6 +74,14 @@ func (c *ConfigAuthLookup) Lookup(repoInfo *registry.RepositoryInfo) types.AuthC
 
 func (c *ConfigAuthLookup) All() map[string]types.AuthConfig {
 	registryAuths := c.cfg.Rancher.RegistryAuths
+	for registry, authConfig := range registryAuths {
+		// the config may not have been loaded by LoadConfig, which
+		// decrypts the ENC[...] values
+		authConfig.Auth = config.DecryptString(authConfig.Auth)
+		authConfig.Password = config.DecryptString(authConfig.Password)
+		authConfig.IdentityToken = config.DecryptString(authConfig.IdentityToken)
+		registryAuths[registry] = authConfig
+	}
 	if c.dockerConfigAuthLookup != nil {
 		for registry, authConfig := range c.dockerConfigAuthLookup.All() {
 			registryAuths[registry] = authConfig
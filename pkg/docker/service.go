@@ -3,9 +3,12 @@ package docker
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/rancher/os/config"
 	"github.com/rancher/os/pkg/log"
+	"github.com/rancher/os/pkg/mounts"
+	"github.com/rancher/os/pkg/util"
 	"github.com/rancher/os/pkg/util/network"
 
 	"github.com/docker/docker/layer"
@@ -154,28 +157,56 @@ func (s *Service) shouldRebuild(ctx context.Context) (bool, error) {
 }
 
 func (s *Service) Up(ctx context.Context, options options.Up) error {
+	// services started once the console is up are not part of the boot
+	if !config.Booting(config.BootingFile) {
+		_, err := s.up(ctx, options)
+		return err
+	}
+
+	start := time.Now()
+	detail, err := s.up(ctx, options)
+	if err == project.ErrRestart {
+		config.RecordBootEvent(config.BootEventService, s.Name(), detail, start, nil)
+	} else {
+		config.RecordBootEvent(config.BootEventService, s.Name(), detail, start, err)
+	}
+	return err
+}
+
+// up returns how long it waited for the network, if it did
+func (s *Service) up(ctx context.Context, options options.Up) (string, error) {
 	labels := s.Config().Labels
+	detail := ""
 
 	// wait for networking if necessary
 	if after := labels["io.rancher.os.after"]; after == "network" {
+		start := time.Now()
 		if err := network.AllDefaultGWOK(network.DefaultRoutesCheckTimeout); err != nil {
 			log.Warnf("Timeout to wait for the networking ready: %v", err)
 		}
+		detail = fmt.Sprintf("waited %.1fs for network", time.Since(start).Seconds())
+	}
+
+	// mount the rancher.mounts it depends on, if they are not yet
+	if names := labels[config.MountsLabel]; names != "" {
+		if err := mounts.Ensure(config.LoadConfig(), util.TrimSplit(names, ",")); err != nil {
+			return detail, err
+		}
 	}
 
 	if err := s.Service.Create(ctx, options.Create); err != nil {
-		return err
+		return detail, err
 	}
 
 	shouldRebuild, err := s.shouldRebuild(ctx)
 	if err != nil {
-		return err
+		return detail, err
 	}
 	if shouldRebuild {
 		log.Infof("Rebuilding %s", s.Name())
 		cs, err := s.Service.Containers(ctx)
 		if err != nil {
-			return err
+			return detail, err
 		}
 		for _, c := range cs {
 			if _, err := c.(*docker.Container).Recreate(ctx, s.Config().Image); err != nil {
@@ -184,27 +215,27 @@ func (s *Service) Up(ctx context.Context, options options.Up) error {
 				if strings.Contains(err.Error(), layer.ErrMountNameConflict.Error()) {
 					log.Warn(err)
 				} else {
-					return err
+					return detail, err
 				}
 			}
 		}
 		if err = s.rename(ctx); err != nil {
-			return err
+			return detail, err
 		}
 	}
 	if labels[config.CreateOnlyLabel] == "true" {
-		return s.checkReload(labels)
+		return detail, s.checkReload(labels)
 	}
 	if err := s.Service.Up(ctx, options); err != nil {
-		return err
+		return detail, err
 	}
 	if labels[config.DetachLabel] == "false" {
 		if err := s.wait(ctx); err != nil {
-			return err
+			return detail, err
 		}
 	}
 
-	return s.checkReload(labels)
+	return detail, s.checkReload(labels)
 }
 
 func (s *Service) checkReload(labels map[string]string) error {
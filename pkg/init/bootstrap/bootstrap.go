@@ -1,6 +1,9 @@
 package bootstrap
 
 import (
+	"os"
+	"path/filepath"
+
 	"github.com/rancher/os/config"
 	"github.com/rancher/os/pkg/compose"
 	"github.com/rancher/os/pkg/init/docker"
@@ -20,7 +23,28 @@ func bootstrapServices(cfg *config.CloudConfig) (*config.CloudConfig, error) {
 	return cfg, err
 }
 
+// saveKeyringKey copies the keyring key of the state device for the
+// bootstrap container, which can not see the keyrings of init
+func saveKeyringKey(description, file string) error {
+	if description == "" {
+		return nil
+	}
+	key, err := util.KeyringKey(description)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(file, key, 0400)
+}
+
 func Bootstrap(cfg *config.CloudConfig) error {
+	if err := saveKeyringKey(cfg.Rancher.State.Encryption.KeyringKey, config.KeyringKeyFile); err != nil {
+		log.Errorf("Failed to read the keyring key %s: %v", cfg.Rancher.State.Encryption.KeyringKey, err)
+	}
+	defer os.Remove(config.KeyringKeyFile)
+
 	log.Info("Launching Bootstrap Docker")
 
 	c, err := docker.Start(cfg)
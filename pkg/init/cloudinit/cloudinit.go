@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/rancher/os/config"
+	"github.com/rancher/os/config/cloudinit/datasource/nocloud"
 	"github.com/rancher/os/pkg/compose"
 	"github.com/rancher/os/pkg/init/docker"
 	"github.com/rancher/os/pkg/log"
@@ -44,6 +45,10 @@ func CloudInit(cfg *config.CloudConfig) (*config.CloudConfig, error) {
 		cfg.Rancher.CloudInit.Datasources = append([]string{"proxmox"}, cfg.Rancher.CloudInit.Datasources...)
 	}
 
+	if hasNoCloud() && !hasDatasource(cfg.Rancher.CloudInit.Datasources, "nocloud") {
+		cfg.Rancher.CloudInit.Datasources = append([]string{"nocloud"}, cfg.Rancher.CloudInit.Datasources...)
+	}
+
 	if len(cfg.Rancher.CloudInit.Datasources) == 0 {
 		log.Info("No specific datasources, ignore cloudinit")
 		return cfg, nil
@@ -138,6 +143,15 @@ func onlyConfigDrive(datasources []string) bool {
 	return false
 }
 
+func hasDatasource(datasources []string, name string) bool {
+	for _, ds := range datasources {
+		if strings.SplitN(ds, ":", 2)[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
 func onlyDigitalOcean(datasources []string) bool {
 	if len(datasources) != 1 {
 		return false
@@ -168,3 +182,12 @@ func onlyProxmox() (bool, error) {
 
 	return strings.Contains(string(f), "Proxmox"), nil
 }
+
+// hasNoCloud is whether there's a NoCloud seed, on the kernel cmdline, in
+// the SMBIOS serial or on a volume labelled cidata
+func hasNoCloud() bool {
+	if _, ok := nocloud.FindSeed(); ok {
+		return true
+	}
+	return nocloud.HasConfigDrive()
+}
@@ -44,6 +44,9 @@ func GetLaunchConfig(cfg *config.CloudConfig, dockerCfg *config.DockerConfig) (*
 	launchConfig.DNSConfig.Nameservers = cfg.Rancher.Defaults.Network.DNS.Nameservers
 	launchConfig.DNSConfig.Search = cfg.Rancher.Defaults.Network.DNS.Search
 	launchConfig.Environment = dockerCfg.Environment
+	launchConfig.Registries = cfg.Rancher.Registries
+	launchConfig.CgroupMode = dfs.CgroupMode(cfg.Rancher.CgroupMode, config.SystemDockerBin, cfg.Rancher.Docker.Engine)
+	launchConfig.CgroupDriver = dfs.CgroupDriver(launchConfig.CgroupMode)
 
 	if !cfg.Rancher.Debug {
 		launchConfig.LogFile = cfg.Rancher.Defaults.SystemDockerLogs
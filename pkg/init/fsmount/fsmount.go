@@ -9,6 +9,7 @@ import (
 	"github.com/rancher/os/config/cmdline"
 	"github.com/rancher/os/pkg/init/bootstrap"
 	"github.com/rancher/os/pkg/log"
+	"github.com/rancher/os/pkg/mounts"
 	"github.com/rancher/os/pkg/util"
 )
 
@@ -126,3 +127,38 @@ func MountStateAndBootstrap(cfg *config.CloudConfig) (*config.CloudConfig, error
 	}
 	return cfg, nil
 }
+
+// RestoreConfig restores the last cloud-config.yml that booted cleanly
+// when the one on the state fails the schema validation, if
+// rancher.config_history.auto_rollback is set by the OS or OEM config or
+// the cmdline
+func RestoreConfig(cfg *config.CloudConfig) (*config.CloudConfig, error) {
+	if !cfg.Rancher.ConfigHistory.AutoRollback {
+		return cfg, nil
+	}
+
+	dirPrefix := ""
+	if ShouldSwitchRoot {
+		dirPrefix = config.StateDir
+	}
+	restored, err := config.RestoreGoodConfig(dirPrefix)
+	if err != nil {
+		log.Errorf("Failed to restore the config: %v", err)
+		return cfg, nil
+	}
+	if !restored {
+		return cfg, nil
+	}
+	log.Infof("Restored the last config that booted cleanly")
+	// cfg was loaded from the config that was just replaced
+	return config.LoadConfigWithPrefix(dirPrefix), nil
+}
+
+// MountBeforeServices mounts the rancher.mounts that are before_services,
+// a required one that fails stops the boot
+func MountBeforeServices(cfg *config.CloudConfig) (*config.CloudConfig, error) {
+	if err := mounts.Apply(cfg, mounts.BeforeServices); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/rancher/os/config"
+	"github.com/rancher/os/config/cmdline"
 	"github.com/rancher/os/pkg/dfs"
 	"github.com/rancher/os/pkg/log"
 )
@@ -21,6 +22,13 @@ var (
 )
 
 func FS(c *config.CloudConfig) (*config.CloudConfig, error) {
+	// the config is not loaded yet by the first preparefs, which only
+	// sees the cgroup mode of the kernel cmdline
+	if c != nil {
+		mountConfig.CgroupMode = dfs.CgroupMode(c.Rancher.CgroupMode, config.SystemDockerBin, c.Rancher.Docker.Engine)
+	} else if mode, ok := cmdline.GetCmdline("rancher.cgroup_mode").(string); ok {
+		mountConfig.CgroupMode = dfs.CgroupMode(mode, config.SystemDockerBin, "")
+	}
 	return c, dfs.PrepareFs(&mountConfig)
 }
 
@@ -0,0 +1,221 @@
+package mounts
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/pkg/log"
+	"github.com/rancher/os/pkg/util"
+	"github.com/rancher/os/pkg/util/network"
+)
+
+const (
+	defaultTimeout = 30
+	pollInterval   = 500 * time.Millisecond
+)
+
+var (
+	procMounts = "/proc/mounts"
+	procSwaps  = "/proc/swaps"
+)
+
+// Mount is a mount of rancher.mounts, or of the cloud-config mounts, which
+// are named mounts.0, mounts.1...
+type Mount struct {
+	Name string
+	config.MountConfig
+}
+
+// List returns the mounts of rancher.mounts, with the parents first, and
+// then the cloud-config mounts in their order
+func List(cfg *config.CloudConfig) []Mount {
+	mounts := []Mount{}
+	for name, m := range cfg.Rancher.Mounts {
+		mounts = append(mounts, Mount{Name: name, MountConfig: m})
+	}
+	sort.Slice(mounts, func(i, j int) bool {
+		di, dj := depth(mounts[i].Where), depth(mounts[j].Where)
+		if di != dj {
+			return di < dj
+		}
+		if mounts[i].Where != mounts[j].Where {
+			return mounts[i].Where < mounts[j].Where
+		}
+		return mounts[i].Name < mounts[j].Name
+	})
+
+	for i, m := range cfg.Mounts {
+		mount := Mount{Name: fmt.Sprintf("mounts.%d", i)}
+		if len(m) != 4 {
+			log.Errorf("Invalid mount %s: must specify exactly four arguments", mount.Name)
+			continue
+		}
+		mount.What, mount.Where, mount.Type, mount.Options = m[0], m[1], m[2], m[3]
+		mounts = append(mounts, mount)
+	}
+	return mounts
+}
+
+// BeforeServices returns whether the mount is mounted by init, which can
+// not wait for the network
+func BeforeServices(m Mount) bool {
+	return m.BeforeServices && !m.WaitForNetwork
+}
+
+// Apply mounts the mounts that match the filter, or all of them if it is
+// nil, skipping the ones that are already mounted. The errors of the
+// required mounts are returned, the others are only logged.
+func Apply(cfg *config.CloudConfig, filter func(Mount) bool) error {
+	var failed []string
+	for _, m := range List(cfg) {
+		if filter != nil && !filter(m) {
+			continue
+		}
+		if err := mount(m); err != nil {
+			log.Errorf("Failed to mount %s on %s: %v", m.What, m.Where, err)
+			if m.Required {
+				failed = append(failed, m.Name)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("Failed to mount the required mounts %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// Ensure mounts the named mounts that are not mounted yet, for the
+// services that depend on them. Any error is returned, unless the mount
+// is not required.
+func Ensure(cfg *config.CloudConfig, names []string) error {
+	mounts := map[string]Mount{}
+	for _, m := range List(cfg) {
+		mounts[m.Name] = m
+	}
+	for _, name := range names {
+		m, ok := mounts[name]
+		if !ok {
+			return fmt.Errorf("Unknown mount %s", name)
+		}
+		if err := mount(m); err != nil {
+			if m.Required {
+				return fmt.Errorf("Failed to mount %s on %s: %v", m.What, m.Where, err)
+			}
+			log.Warnf("Failed to mount %s on %s: %v", m.What, m.Where, err)
+		}
+	}
+	return nil
+}
+
+// Mounted returns whether the mount is mounted, or for swap, enabled
+func Mounted(m Mount) bool {
+	if m.Type == "swap" {
+		device := util.ResolveDevice(m.What)
+		return device != "" && fieldMatches(procSwaps, 0, device)
+	}
+	return fieldMatches(procMounts, 1, path.Clean(m.Where))
+}
+
+func mount(m Mount) error {
+	if Mounted(m) {
+		log.Debugf("Skipped mounting %s because %s is mounted", m.Name, m.Where)
+		return nil
+	}
+
+	timeout := m.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+
+	if m.WaitForNetwork {
+		if err := network.AllDefaultGWOK(timeout * 1000); err != nil {
+			return fmt.Errorf("network not ready: %v", err)
+		}
+	}
+
+	if m.Type == "nfs" || m.Type == "nfs4" {
+		if err := os.MkdirAll(m.Where, 0755); err != nil {
+			return err
+		}
+		args := []string{m.What, m.Where, "-t", m.Type}
+		if m.Options != "" {
+			args = append(args, "-o", m.Options)
+		}
+		return run("mount", args...)
+	}
+
+	device, err := waitForDevice(m.What, time.Duration(timeout)*time.Second)
+	if err != nil {
+		return err
+	}
+
+	if m.Type == "swap" {
+		return run("swapon", device)
+	}
+
+	log.Infof("Mounting %s on %s", device, m.Where)
+	return util.Mount(device, m.Where, m.Type, m.Options)
+}
+
+// waitForDevice resolves LABEL= and UUID= specs, waiting for the device to
+// appear. Anything else, like tmpfs or the source of a bind mount, is
+// returned as is.
+func waitForDevice(what string, timeout time.Duration) (string, error) {
+	isSpec := strings.HasPrefix(what, "LABEL=") || strings.HasPrefix(what, "UUID=") ||
+		strings.HasPrefix(what, "PARTLABEL=") || strings.HasPrefix(what, "PARTUUID=")
+	if !isSpec && !strings.HasPrefix(what, "/dev/") {
+		return what, nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		device := what
+		if isSpec {
+			device = util.ResolveDevice(what)
+		}
+		if device != "" {
+			if _, err := os.Stat(device); err == nil {
+				return device, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("%s not found after %v", what, timeout)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+func fieldMatches(file string, field int, value string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) > field && fields[field] == value {
+			return true
+		}
+	}
+	return false
+}
+
+func depth(where string) int {
+	return strings.Count(path.Clean(where), "/")
+}
+
+func run(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
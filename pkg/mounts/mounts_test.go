@@ -0,0 +1,75 @@
+package mounts
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rancher/os/config"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestList(t *testing.T) {
+	assert := require.New(t)
+
+	cfg := &config.CloudConfig{
+		Mounts: [][]string{
+			{"/dev/vdb", "/mnt/legacy", "ext4", ""},
+			{"/dev/vdc", "/mnt/invalid"},
+		},
+	}
+	cfg.Rancher.Mounts = map[string]config.MountConfig{
+		"logs":   {What: "LABEL=logs", Where: "/mnt/data/logs", Type: "ext4"},
+		"data":   {What: "LABEL=data", Where: "/mnt/data", Type: "ext4", Required: true, BeforeServices: true},
+		"backup": {What: "nas:/backup", Where: "/mnt/backup", Type: "nfs", WaitForNetwork: true, BeforeServices: true},
+	}
+
+	list := List(cfg)
+	names := []string{}
+	for _, m := range list {
+		names = append(names, m.Name)
+	}
+	assert.Equal([]string{"backup", "data", "logs", "mounts.0"}, names)
+	assert.Equal(config.MountConfig{What: "/dev/vdb", Where: "/mnt/legacy", Type: "ext4"}, list[3].MountConfig)
+
+	assert.False(BeforeServices(list[0]))
+	assert.True(BeforeServices(list[1]))
+	assert.False(BeforeServices(list[2]))
+
+	assert.Error(Ensure(cfg, []string{"missing"}))
+}
+
+func TestMounted(t *testing.T) {
+	assert := require.New(t)
+
+	f, err := ioutil.TempFile("", "mounts")
+	assert.NoError(err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("/dev/vda1 / ext4 rw 0 0\n/dev/vdb /mnt/data ext4 rw 0 0\n")
+	assert.NoError(err)
+	assert.NoError(f.Close())
+
+	defer func(orig string) { procMounts = orig }(procMounts)
+	procMounts = f.Name()
+
+	assert.True(Mounted(Mount{MountConfig: config.MountConfig{Where: "/mnt/data/"}}))
+	assert.False(Mounted(Mount{MountConfig: config.MountConfig{Where: "/mnt/data/logs"}}))
+
+	// already mounted, so neither the device nor the network is waited for
+	assert.NoError(Ensure(&config.CloudConfig{Rancher: config.RancherConfig{Mounts: map[string]config.MountConfig{
+		"data": {What: "LABEL=missing", Where: "/mnt/data", Required: true, WaitForNetwork: true},
+	}}}, []string{"data"}))
+}
+
+func TestWaitForDevice(t *testing.T) {
+	assert := require.New(t)
+
+	device, err := waitForDevice("tmpfs", time.Second)
+	assert.NoError(err)
+	assert.Equal("tmpfs", device)
+
+	_, err = waitForDevice("/dev/does-not-exist", time.Millisecond)
+	assert.Error(err)
+}
@@ -0,0 +1,179 @@
+package netconf
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"github.com/rancher/os/pkg/log"
+
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	IPv6SLAAC  = "slaac"
+	IPv6DHCP   = "dhcpv6"
+	IPv6Static = "static"
+
+	ipv6ConfDir = "/proc/sys/net/ipv6/conf"
+)
+
+var (
+	// dhcpcd for both IPv4 and IPv6, or only IPv6
+	dualStackDhcpArgs = "dhcpcd -MA"
+	dhcpv6Args        = "dhcpcd -MA6"
+)
+
+// IPv6Nameservers returns the nameservers of all the interfaces' ipv6 configs
+func IPv6Nameservers(netCfg *NetworkConfig) []string {
+	names := []string{}
+	for name := range netCfg.Interfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	nameservers := []string{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		for _, ns := range netCfg.Interfaces[name].IPv6.Nameservers {
+			if !seen[ns] {
+				seen[ns] = true
+				nameservers = append(nameservers, ns)
+			}
+		}
+	}
+	return nameservers
+}
+
+// ipv6Sysctls returns the /proc/sys/net/ipv6/conf/<iface> settings for cfg
+func ipv6Sysctls(cfg IPv6Config) map[string]string {
+	sysctls := map[string]string{}
+	switch cfg.Mode {
+	case IPv6SLAAC, IPv6DHCP:
+		// 2 accepts router advertisements even with forwarding enabled, which docker does
+		sysctls["accept_ra"] = "2"
+		sysctls["autoconf"] = "1"
+	case IPv6Static:
+		sysctls["accept_ra"] = "0"
+		sysctls["autoconf"] = "0"
+	}
+	if cfg.AcceptRA != nil {
+		if *cfg.AcceptRA {
+			sysctls["accept_ra"] = "2"
+		} else {
+			sysctls["accept_ra"] = "0"
+		}
+	}
+	if cfg.Mode != "" || cfg.Privacy {
+		if cfg.Privacy {
+			sysctls["use_tempaddr"] = "2"
+		} else {
+			sysctls["use_tempaddr"] = "0"
+		}
+	}
+	return sysctls
+}
+
+func validateIPv6Config(cfg IPv6Config) error {
+	switch cfg.Mode {
+	case "", IPv6SLAAC, IPv6DHCP, IPv6Static:
+	default:
+		return fmt.Errorf("unknown ipv6 mode %q", cfg.Mode)
+	}
+	for _, address := range cfg.Addresses {
+		ip, _, err := net.ParseCIDR(address)
+		if err != nil {
+			return err
+		}
+		if ip.To4() != nil {
+			return fmt.Errorf("%s is not an IPv6 address", address)
+		}
+	}
+	return nil
+}
+
+// applyIPv6Config sets the sysctls, static addresses and gateway of the ipv6
+// config. Addresses dhcpcd or the kernel manage are left alone, its routes
+// are applied with the interface's other routes by applyRoutes.
+func applyIPv6Config(link netlink.Link, cfg IPv6Config) error {
+	if err := validateIPv6Config(cfg); err != nil {
+		return err
+	}
+	linkName := link.Attrs().Name
+
+	for key, value := range ipv6Sysctls(cfg) {
+		path := filepath.Join(ipv6ConfDir, linkName, key)
+		if err := ioutil.WriteFile(path, []byte(value), 0644); err != nil {
+			log.Errorf("Failed to set %s to %s: %v", path, value, err)
+		}
+	}
+
+	for _, address := range cfg.Addresses {
+		log.Infof("Applying %s to %s", address, linkName)
+		addr, err := netlink.ParseAddr(address)
+		if err != nil {
+			log.Errorf("Failed to parse address %s: %v", address, err)
+			continue
+		}
+		if err := netlink.AddrAdd(link, addr); err != nil && err != syscall.EEXIST {
+			log.Errorf("Failed to apply address %s to %s: %v", address, linkName, err)
+		}
+	}
+
+	if err := netlink.LinkSetUp(link); err != nil {
+		return err
+	}
+
+	if err := setGateway(cfg.Gateway, true); err != nil {
+		log.Errorf("Fail to set gateway %s", cfg.Gateway)
+	}
+	return nil
+}
+
+func netlinkRoute(link netlink.Link, route RouteConfig) (*netlink.Route, error) {
+	r := &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Priority:  route.Metric,
+		Protocol:  RouteProtocol,
+		Table:     route.Table,
+	}
+	if r.Table <= 0 {
+		r.Table = syscall.RT_TABLE_MAIN
+	}
+	if route.Destination != "" && route.Destination != "default" {
+		_, dst, err := net.ParseCIDR(route.Destination)
+		if err != nil {
+			return nil, err
+		}
+		r.Dst = dst
+	}
+	if route.Gateway != "" {
+		r.Gw = net.ParseIP(route.Gateway)
+		if r.Gw == nil {
+			return nil, errors.New("Invalid gateway address " + route.Gateway)
+		}
+	}
+	if r.Dst == nil && r.Gw == nil {
+		return nil, errors.New("route needs a destination or a gateway")
+	}
+	if r.Gw == nil {
+		r.Scope = netlink.SCOPE_LINK
+	}
+	return r, nil
+}
+
+// keepDynamicIPv6 is whether addr was configured by the kernel or dhcpcd,
+// rather than by us, for an interface with ipv6 enabled
+func keepDynamicIPv6(addr netlink.Addr, cfg IPv6Config) bool {
+	if cfg.Mode == "" || addr.IP.To4() != nil {
+		return false
+	}
+	if addr.IP.IsLinkLocalUnicast() {
+		return true
+	}
+	return cfg.Mode != IPv6Static && addr.Flags&syscall.IFA_F_PERMANENT == 0
+}
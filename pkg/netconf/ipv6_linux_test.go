@@ -0,0 +1,95 @@
+package netconf
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+)
+
+func TestIPv6Sysctls(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal(map[string]string{}, ipv6Sysctls(IPv6Config{}))
+	assert.Equal(map[string]string{
+		"accept_ra":    "2",
+		"autoconf":     "1",
+		"use_tempaddr": "2",
+	}, ipv6Sysctls(IPv6Config{Mode: IPv6SLAAC, Privacy: true}))
+
+	acceptRA := true
+	assert.Equal(map[string]string{
+		"accept_ra":    "2",
+		"autoconf":     "0",
+		"use_tempaddr": "0",
+	}, ipv6Sysctls(IPv6Config{Mode: IPv6Static, AcceptRA: &acceptRA}))
+}
+
+func TestValidateIPv6Config(t *testing.T) {
+	assert := require.New(t)
+
+	assert.NoError(validateIPv6Config(IPv6Config{Mode: IPv6Static, Addresses: []string{"2001:db8::10/64"}}))
+	assert.Error(validateIPv6Config(IPv6Config{Mode: "auto"}))
+	assert.Error(validateIPv6Config(IPv6Config{Addresses: []string{"10.0.0.1/24"}}))
+}
+
+func TestNetlinkRoute(t *testing.T) {
+	assert := require.New(t)
+
+	linkAttrs := netlink.NewLinkAttrs()
+	linkAttrs.Index = 3
+	link := mockLink{attrs: linkAttrs}
+
+	route, err := netlinkRoute(link, RouteConfig{Destination: "2001:db8:1::/48", Gateway: "fe80::1", Metric: 100})
+	assert.NoError(err)
+	assert.Equal(3, route.LinkIndex)
+	assert.Equal(100, route.Priority)
+	assert.Equal("2001:db8:1::/48", route.Dst.String())
+	assert.Equal("fe80::1", route.Gw.String())
+
+	route, err = netlinkRoute(link, RouteConfig{Destination: "default", Gateway: "2001:db8::1"})
+	assert.NoError(err)
+	assert.Nil(route.Dst)
+
+	route, err = netlinkRoute(link, RouteConfig{Destination: "2001:db8:2::/64"})
+	assert.NoError(err)
+	assert.Equal(netlink.SCOPE_LINK, route.Scope)
+
+	_, err = netlinkRoute(link, RouteConfig{})
+	assert.Error(err)
+	_, err = netlinkRoute(link, RouteConfig{Gateway: "nope"})
+	assert.Error(err)
+}
+
+func TestKeepDynamicIPv6(t *testing.T) {
+	assert := require.New(t)
+
+	addr := func(s string, flags int) netlink.Addr {
+		ip, ipNet, _ := net.ParseCIDR(s)
+		ipNet.IP = ip
+		return netlink.Addr{IPNet: ipNet, Flags: flags}
+	}
+
+	slaac := IPv6Config{Mode: IPv6SLAAC}
+	static := IPv6Config{Mode: IPv6Static}
+	assert.True(keepDynamicIPv6(addr("fe80::1/64", syscall.IFA_F_PERMANENT), static))
+	assert.True(keepDynamicIPv6(addr("2001:db8::1234/64", 0), slaac))
+	assert.False(keepDynamicIPv6(addr("2001:db8::1234/64", 0), static))
+	assert.False(keepDynamicIPv6(addr("2001:db8::1/64", syscall.IFA_F_PERMANENT), slaac))
+	assert.False(keepDynamicIPv6(addr("10.0.0.1/24", 0), slaac))
+	assert.False(keepDynamicIPv6(addr("fe80::1/64", 0), IPv6Config{}))
+}
+
+func TestIPv6Nameservers(t *testing.T) {
+	assert := require.New(t)
+
+	netCfg := &NetworkConfig{
+		Interfaces: map[string]InterfaceConfig{
+			"eth1": {IPv6: IPv6Config{Nameservers: []string{"2001:db8::53", "2001:db8::54"}}},
+			"eth0": {IPv6: IPv6Config{Nameservers: []string{"2001:db8::54", "2001:db8::1"}}},
+		},
+	}
+	assert.Equal([]string{"2001:db8::54", "2001:db8::1", "2001:db8::53"}, IPv6Nameservers(netCfg))
+}
@@ -176,6 +176,9 @@ func ApplyNetworkConfigs(netCfg *NetworkConfig, userSetHostname, userSetDNS bool
 	runCmds(netCfg.PreCmds, "")
 	defer runCmds(netCfg.PostCmds, "")
 
+	state := ReadState(StateFile)
+	reconcile(netCfg, state)
+
 	createInterfaces(netCfg)
 	createSlaveInterfaces(netCfg)
 
@@ -203,6 +206,12 @@ func ApplyNetworkConfigs(netCfg *NetworkConfig, userSetHostname, userSetDNS bool
 	}
 	wg.Wait()
 
+	applyRules(netCfg, &state)
+	recordState(netCfg, &state)
+	if err := writeState(StateFile, state); err != nil {
+		log.Errorf("Failed to write %s: %v", StateFile, err)
+	}
+
 	// make sure there was a DHCP set dns - or tell ros to write 8.8.8.8,8.8.8.4
 	log.Infof("Checking to see if DNS was set by DHCP")
 	dnsSet := false
@@ -244,7 +253,20 @@ func applyOuter(link netlink.Link, netCfg *NetworkConfig, wg *sync.WaitGroup, us
 		}
 	}
 
-	if !match.DHCP && !hasDhcp(linkName) {
+	enslaved := match.Bond != "" || (match.Bridge != "" && match.Bridge != "true")
+	if !enslaved && (match.IPv6.Mode != "" || len(match.IPv6.Addresses) > 0) {
+		if err := applyIPv6Config(link, match.IPv6); err != nil {
+			log.Errorf("Failed to apply ipv6 settings to %s : %v", linkName, err)
+		}
+	}
+	dhcpv6 := !enslaved && match.IPv6.Mode == IPv6DHCP
+
+	// routes through a gateway need the address dhcp assigns first
+	if !enslaved && !match.DHCP {
+		applyRoutes(link, match)
+	}
+
+	if !match.DHCP && !dhcpv6 && !hasDhcp(linkName) {
 		log.Debugf("Skipping(%s): DHCP=false && no DHCP lease yet", linkName)
 		return
 	}
@@ -255,8 +277,17 @@ func applyOuter(link netlink.Link, netCfg *NetworkConfig, wg *sync.WaitGroup, us
 			if match.WifiNetwork != "" {
 				runWifiDhcp(netCfg, link, match.WifiNetwork, !userSetHostname, !userSetDNS)
 			} else {
-				runDhcp(netCfg, link.Attrs().Name, match.DHCPArgs, !userSetHostname, !userSetDNS)
+				dhcpArgs := match.DHCPArgs
+				if dhcpArgs == "" && dhcpv6 {
+					dhcpArgs = dualStackDhcpArgs
+				}
+				runDhcp(netCfg, link.Attrs().Name, dhcpArgs, !userSetHostname, !userSetDNS)
 			}
+			if !enslaved {
+				applyRoutes(link, match)
+			}
+		} else if dhcpv6 {
+			runDhcp(netCfg, link.Attrs().Name, dhcpv6Args, false, !userSetDNS)
 		} else {
 			log.Infof("dhcp release %s", link.Attrs().Name)
 			runDhcp(netCfg, link.Attrs().Name, dhcpReleaseCmd, false, true)
@@ -491,9 +522,12 @@ func applyInterfaceConfig(link netlink.Link, netConf InterfaceConfig) error {
 	for _, address := range addresses {
 		addrMap[address] = true
 	}
+	for _, address := range netConf.IPv6.Addresses {
+		addrMap[address] = true
+	}
 	for _, addr := range existingAddrs {
 		if _, ok := addrMap[addr.IPNet.String()]; !ok {
-			if netConf.DHCP || netConf.IPV4LL {
+			if netConf.DHCP || netConf.IPV4LL || keepDynamicIPv6(addr, netConf.IPv6) {
 				// let the dhcpcd take care of it
 				log.Infof("leaving  %s from %s", addr.String(), link.Attrs().Name)
 			} else {
@@ -0,0 +1,558 @@
+package netconf
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/os/pkg/log"
+	"github.com/rancher/os/pkg/util"
+
+	yaml "github.com/cloudfoundry-incubator/candiedyaml"
+)
+
+// netplan is the cloud-init network-config, in either version, see
+// https://cloudinit.readthedocs.io/en/latest/topics/network-config.html
+type netplan struct {
+	Version int `yaml:"version"`
+
+	// version 1
+	Config []netplanV1Entry `yaml:"config"`
+
+	// version 2
+	Ethernets map[string]netplanV2Device `yaml:"ethernets"`
+	Bonds     map[string]netplanV2Device `yaml:"bonds"`
+	Bridges   map[string]netplanV2Device `yaml:"bridges"`
+	Vlans     map[string]netplanV2Device `yaml:"vlans"`
+}
+
+type netplanV1Entry struct {
+	Type             string                 `yaml:"type"`
+	Name             string                 `yaml:"name"`
+	MacAddress       string                 `yaml:"mac_address"`
+	MTU              int                    `yaml:"mtu"`
+	Subnets          []netplanV1Subnet      `yaml:"subnets"`
+	BondInterfaces   []string               `yaml:"bond_interfaces"`
+	BridgeInterfaces []string               `yaml:"bridge_interfaces"`
+	Params           map[string]interface{} `yaml:"params"`
+	VlanLink         string                 `yaml:"vlan_link"`
+	VlanID           int                    `yaml:"vlan_id"`
+
+	// type nameserver
+	Address stringList `yaml:"address"`
+	Search  stringList `yaml:"search"`
+
+	// type route
+	Destination string `yaml:"destination"`
+	Network     string `yaml:"network"`
+	Netmask     string `yaml:"netmask"`
+	Gateway     string `yaml:"gateway"`
+	Metric      int    `yaml:"metric"`
+}
+
+type netplanV1Subnet struct {
+	Type           string           `yaml:"type"`
+	Address        string           `yaml:"address"`
+	Netmask        string           `yaml:"netmask"`
+	Gateway        string           `yaml:"gateway"`
+	DNSNameservers []string         `yaml:"dns_nameservers"`
+	DNSSearch      []string         `yaml:"dns_search"`
+	Routes         []netplanV1Entry `yaml:"routes"`
+}
+
+type netplanV2Device struct {
+	Match         netplanV2Match         `yaml:"match"`
+	SetName       string                 `yaml:"set-name"`
+	DHCP4         bool                   `yaml:"dhcp4"`
+	DHCP6         bool                   `yaml:"dhcp6"`
+	AcceptRA      *bool                  `yaml:"accept-ra"`
+	Addresses     []string               `yaml:"addresses"`
+	Gateway4      string                 `yaml:"gateway4"`
+	Gateway6      string                 `yaml:"gateway6"`
+	Nameservers   netplanV2Nameservers   `yaml:"nameservers"`
+	Routes        []netplanV2Route       `yaml:"routes"`
+	RoutingPolicy []netplanV2Rule        `yaml:"routing-policy"`
+	MTU           int                    `yaml:"mtu"`
+	Interfaces    []string               `yaml:"interfaces"`
+	Parameters    map[string]interface{} `yaml:"parameters"`
+	ID            int                    `yaml:"id"`
+	Link          string                 `yaml:"link"`
+}
+
+type netplanV2Match struct {
+	MacAddress string `yaml:"macaddress"`
+	Name       string `yaml:"name"`
+}
+
+type netplanV2Nameservers struct {
+	Addresses []string `yaml:"addresses"`
+	Search    []string `yaml:"search"`
+}
+
+type netplanV2Route struct {
+	To     string `yaml:"to"`
+	Via    string `yaml:"via"`
+	Metric int    `yaml:"metric"`
+	Table  int    `yaml:"table"`
+}
+
+type netplanV2Rule struct {
+	From     string `yaml:"from"`
+	To       string `yaml:"to"`
+	Table    int    `yaml:"table"`
+	Priority int    `yaml:"priority"`
+	Mark     int    `yaml:"mark"`
+}
+
+// stringList is a list, that can be written as a single string
+type stringList []string
+
+func (s *stringList) UnmarshalYAML(tag string, value interface{}) error {
+	switch value := value.(type) {
+	case string:
+		*s = strings.Fields(strings.Replace(value, ",", " ", -1))
+	case []interface{}:
+		for _, v := range value {
+			*s = append(*s, fmt.Sprint(v))
+		}
+	default:
+		return fmt.Errorf("Failed to unmarshal %#v as a list of strings", value)
+	}
+	return nil
+}
+
+// netplan v2 bond parameters that are named differently in sysfs
+var netplanBondParameters = map[string]string{
+	"mii-monitor-interval":  "miimon",
+	"transmit-hash-policy":  "xmit_hash_policy",
+	"up-delay":              "updelay",
+	"down-delay":            "downdelay",
+	"fail-over-mac-policy":  "fail_over_mac",
+	"gratuitous-arp":        "num_grat_arp",
+	"gratuitious-arp":       "num_grat_arp",
+	"arp-ip-targets":        "arp_ip_target",
+	"learn-packet-interval": "lp_interval",
+}
+
+// ParseNetplan translates the cloud-init network-config, version 1 or 2
+// (netplan), into a NetworkConfig. The config can be under a network key.
+func ParseNetplan(data []byte) (NetworkConfig, error) {
+	var wrapped struct {
+		Network netplan `yaml:"network"`
+	}
+	if err := yaml.Unmarshal(data, &wrapped); err != nil {
+		return NetworkConfig{}, err
+	}
+	n := wrapped.Network
+	if n.Version == 0 {
+		if err := yaml.Unmarshal(data, &n); err != nil {
+			return NetworkConfig{}, err
+		}
+	}
+
+	switch n.Version {
+	case 1:
+		return n.v1()
+	case 2:
+		return n.v2()
+	}
+	return NetworkConfig{}, fmt.Errorf("unsupported network-config version %d", n.Version)
+}
+
+// MergeNetplan adds the interfaces and nameservers of the netplan field to
+// the config. Interfaces that are configured directly are kept as they are.
+func MergeNetplan(netCfg *NetworkConfig) error {
+	if len(netCfg.Netplan) == 0 {
+		return nil
+	}
+	data, err := yaml.Marshal(netCfg.Netplan)
+	if err != nil {
+		return err
+	}
+	translated, err := ParseNetplan(data)
+	if err != nil {
+		return err
+	}
+	netCfg.Netplan = nil
+
+	if netCfg.Interfaces == nil {
+		netCfg.Interfaces = map[string]InterfaceConfig{}
+	}
+	for name, iface := range translated.Interfaces {
+		if _, ok := netCfg.Interfaces[name]; ok {
+			log.Infof("Not using the netplan config of %s, it is configured already", name)
+			continue
+		}
+		netCfg.Interfaces[name] = iface
+	}
+	netCfg.DNS.Nameservers = appendUnique(netCfg.DNS.Nameservers, translated.DNS.Nameservers...)
+	netCfg.DNS.Search = appendUnique(netCfg.DNS.Search, translated.DNS.Search...)
+	return nil
+}
+
+func (n netplan) v1() (NetworkConfig, error) {
+	cfg := NetworkConfig{
+		Interfaces: map[string]InterfaceConfig{},
+	}
+	routes := []netplanV1Entry{}
+
+	for _, entry := range n.Config {
+		if entry.Type == "nameserver" {
+			cfg.DNS.Nameservers = appendUnique(cfg.DNS.Nameservers, entry.Address...)
+			cfg.DNS.Search = appendUnique(cfg.DNS.Search, entry.Search...)
+			continue
+		}
+		if entry.Type == "route" {
+			routes = append(routes, entry)
+			continue
+		}
+		if entry.Name == "" {
+			return cfg, fmt.Errorf("%s without a name", entry.Type)
+		}
+
+		iface := cfg.Interfaces[entry.Name]
+		iface.MTU = entry.MTU
+		switch entry.Type {
+		case "physical":
+			if entry.MacAddress != "" {
+				iface.Match = "mac=" + entry.MacAddress
+			}
+		case "bond":
+			iface.BondOpts = bondOpts(entry.Params, "bond-")
+			for _, slave := range entry.BondInterfaces {
+				slaveIface := cfg.Interfaces[slave]
+				slaveIface.Bond = entry.Name
+				cfg.Interfaces[slave] = slaveIface
+			}
+		case "bridge":
+			iface.Bridge = "true"
+			for _, port := range entry.BridgeInterfaces {
+				portIface := cfg.Interfaces[port]
+				portIface.Bridge = entry.Name
+				cfg.Interfaces[port] = portIface
+			}
+		case "vlan":
+			parent := cfg.Interfaces[entry.VlanLink]
+			parent.Vlans = addVlan(parent.Vlans, entry.VlanID, entry.Name)
+			cfg.Interfaces[entry.VlanLink] = parent
+		default:
+			log.Warnf("Ignoring network-config %s %s, the type isn't supported", entry.Type, entry.Name)
+			continue
+		}
+
+		for _, subnet := range entry.Subnets {
+			if err := applyV1Subnet(&iface, subnet); err != nil {
+				return cfg, fmt.Errorf("%s: %v", entry.Name, err)
+			}
+			cfg.DNS.Nameservers = appendUnique(cfg.DNS.Nameservers, subnet.DNSNameservers...)
+			cfg.DNS.Search = appendUnique(cfg.DNS.Search, subnet.DNSSearch...)
+		}
+		cfg.Interfaces[entry.Name] = iface
+	}
+
+	// global routes go through the interface with the gateway in its subnet
+	for _, entry := range routes {
+		route, err := v1Route(entry)
+		if err != nil {
+			return cfg, err
+		}
+		name := interfaceOnLink(cfg, net.ParseIP(entry.Gateway))
+		if name == "" {
+			log.Warnf("Ignoring route %s via %s, no interface has the gateway in its subnet", route.Destination, route.Gateway)
+			continue
+		}
+		iface := cfg.Interfaces[name]
+		if isIPv6(entry.Gateway) {
+			iface.IPv6.Routes = append(iface.IPv6.Routes, route)
+		} else {
+			iface.Routes = append(iface.Routes, route)
+		}
+		cfg.Interfaces[name] = iface
+	}
+	return cfg, nil
+}
+
+func applyV1Subnet(iface *InterfaceConfig, subnet netplanV1Subnet) error {
+	switch subnet.Type {
+	case "dhcp", "dhcp4":
+		iface.DHCP = true
+		return nil
+	case "dhcp6", "ipv6_dhcpv6-stateful":
+		iface.IPv6.Mode = IPv6DHCP
+		return nil
+	case "ipv6_slaac", "ipv6_dhcpv6-stateless":
+		iface.IPv6.Mode = IPv6SLAAC
+		return nil
+	case "manual":
+		return nil
+	case "static", "static6":
+	default:
+		return fmt.Errorf("unsupported subnet type %s", subnet.Type)
+	}
+
+	address, err := joinNetmask(subnet.Address, subnet.Netmask)
+	if err != nil {
+		return err
+	}
+	ipv6 := isIPv6(address)
+	if ipv6 {
+		iface.IPv6.Mode = IPv6Static
+		iface.IPv6.Addresses = append(iface.IPv6.Addresses, address)
+		if subnet.Gateway != "" {
+			iface.IPv6.Gateway = subnet.Gateway
+		}
+	} else {
+		iface.Addresses = append(iface.Addresses, address)
+		if subnet.Gateway != "" {
+			iface.Gateway = subnet.Gateway
+		}
+	}
+
+	for _, entry := range subnet.Routes {
+		route, err := v1Route(entry)
+		if err != nil {
+			return err
+		}
+		if ipv6 {
+			iface.IPv6.Routes = append(iface.IPv6.Routes, route)
+		} else {
+			iface.Routes = append(iface.Routes, route)
+		}
+	}
+	return nil
+}
+
+func v1Route(entry netplanV1Entry) (RouteConfig, error) {
+	destination := entry.Destination
+	if destination == "" {
+		destination = entry.Network
+	}
+	destination, err := joinNetmask(destination, entry.Netmask)
+	if err != nil {
+		return RouteConfig{}, err
+	}
+	return RouteConfig{Destination: destination, Gateway: entry.Gateway, Metric: entry.Metric}, nil
+}
+
+func (n netplan) v2() (NetworkConfig, error) {
+	cfg := NetworkConfig{
+		Interfaces: map[string]InterfaceConfig{},
+	}
+
+	for _, devices := range []map[string]netplanV2Device{n.Ethernets, n.Bonds, n.Bridges, n.Vlans} {
+		for _, name := range sortedDevices(devices) {
+			device := devices[name]
+			iface := cfg.Interfaces[name]
+			if err := applyV2Device(&iface, device); err != nil {
+				return cfg, fmt.Errorf("%s: %v", name, err)
+			}
+			cfg.Interfaces[name] = iface
+			cfg.DNS.Nameservers = appendUnique(cfg.DNS.Nameservers, device.Nameservers.Addresses...)
+			cfg.DNS.Search = appendUnique(cfg.DNS.Search, device.Nameservers.Search...)
+		}
+	}
+
+	for _, name := range sortedDevices(n.Ethernets) {
+		device := n.Ethernets[name]
+		iface := cfg.Interfaces[name]
+		if device.Match.MacAddress != "" {
+			iface.Match = "mac=" + device.Match.MacAddress
+		} else if device.Match.Name != "" {
+			iface.Match = device.Match.Name
+		}
+		if device.SetName != "" {
+			log.Warnf("Ignoring set-name of %s, renaming interfaces isn't supported", name)
+		}
+		cfg.Interfaces[name] = iface
+	}
+	for _, name := range sortedDevices(n.Bonds) {
+		bond := cfg.Interfaces[name]
+		bond.BondOpts = bondOpts(n.Bonds[name].Parameters, "")
+		cfg.Interfaces[name] = bond
+		for _, slave := range n.Bonds[name].Interfaces {
+			slaveIface := cfg.Interfaces[slave]
+			slaveIface.Bond = name
+			cfg.Interfaces[slave] = slaveIface
+		}
+	}
+	for _, name := range sortedDevices(n.Bridges) {
+		bridge := cfg.Interfaces[name]
+		bridge.Bridge = "true"
+		cfg.Interfaces[name] = bridge
+		for _, port := range n.Bridges[name].Interfaces {
+			portIface := cfg.Interfaces[port]
+			portIface.Bridge = name
+			cfg.Interfaces[port] = portIface
+		}
+	}
+	for _, name := range sortedDevices(n.Vlans) {
+		vlan := n.Vlans[name]
+		if vlan.Link == "" {
+			return cfg, fmt.Errorf("%s: vlan without a link", name)
+		}
+		parent := cfg.Interfaces[vlan.Link]
+		parent.Vlans = addVlan(parent.Vlans, vlan.ID, name)
+		cfg.Interfaces[vlan.Link] = parent
+	}
+	return cfg, nil
+}
+
+func applyV2Device(iface *InterfaceConfig, device netplanV2Device) error {
+	iface.DHCP = device.DHCP4
+	iface.MTU = device.MTU
+	iface.Gateway = device.Gateway4
+	iface.IPv6.Gateway = device.Gateway6
+	iface.IPv6.AcceptRA = device.AcceptRA
+
+	for _, address := range device.Addresses {
+		if _, _, err := net.ParseCIDR(address); err != nil {
+			return err
+		}
+		if isIPv6(address) {
+			iface.IPv6.Addresses = append(iface.IPv6.Addresses, address)
+		} else {
+			iface.Addresses = append(iface.Addresses, address)
+		}
+	}
+	switch {
+	case device.DHCP6:
+		iface.IPv6.Mode = IPv6DHCP
+	case len(iface.IPv6.Addresses) > 0:
+		iface.IPv6.Mode = IPv6Static
+	case device.AcceptRA != nil && *device.AcceptRA:
+		iface.IPv6.Mode = IPv6SLAAC
+	}
+
+	for _, r := range device.Routes {
+		route := RouteConfig{Destination: r.To, Gateway: r.Via, Metric: r.Metric, Table: r.Table}
+		if isIPv6(r.Via) || (r.Via == "" && isIPv6(r.To)) {
+			iface.IPv6.Routes = append(iface.IPv6.Routes, route)
+		} else {
+			iface.Routes = append(iface.Routes, route)
+		}
+	}
+	for _, r := range device.RoutingPolicy {
+		iface.Rules = append(iface.Rules, RuleConfig{From: r.From, To: r.To, FwMark: r.Mark, Table: r.Table, Priority: r.Priority})
+	}
+	return nil
+}
+
+// bondOpts returns the bond options in their sysfs names, version 1 params
+// have a bond- prefix and version 2 parameters are named like netplan's
+func bondOpts(params map[string]interface{}, prefix string) map[string]string {
+	if len(params) == 0 {
+		return nil
+	}
+	opts := map[string]string{}
+	for key, value := range params {
+		if prefix != "" {
+			if !strings.HasPrefix(key, prefix) || key == prefix+"slaves" {
+				continue
+			}
+			key = strings.TrimPrefix(key, prefix)
+		} else if name, ok := netplanBondParameters[key]; ok {
+			key = name
+		}
+		switch value := value.(type) {
+		case []interface{}:
+			values := []string{}
+			for _, v := range value {
+				values = append(values, fmt.Sprint(v))
+			}
+			opts[strings.Replace(key, "-", "_", -1)] = strings.Join(values, ",")
+		default:
+			opts[strings.Replace(key, "-", "_", -1)] = fmt.Sprint(value)
+		}
+	}
+	return opts
+}
+
+func addVlan(vlans string, id int, name string) string {
+	vlan := fmt.Sprintf("%d:%s", id, name)
+	if vlans == "" {
+		return vlan
+	}
+	return vlans + "," + vlan
+}
+
+// joinNetmask adds the netmask, dotted or a prefix length, to an address
+// that doesn't have one
+func joinNetmask(address, netmask string) (string, error) {
+	if address == "default" {
+		return address, nil
+	}
+	if strings.Contains(address, "/") {
+		_, _, err := net.ParseCIDR(address)
+		return address, err
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return "", fmt.Errorf("invalid address %q", address)
+	}
+	if netmask == "" {
+		if ip.To4() != nil {
+			return ip.String() + "/32", nil
+		}
+		return ip.String() + "/128", nil
+	}
+	if prefix, err := strconv.Atoi(strings.TrimPrefix(netmask, "/")); err == nil {
+		return fmt.Sprintf("%s/%d", ip, prefix), nil
+	}
+	mask := net.ParseIP(netmask)
+	if mask == nil {
+		return "", fmt.Errorf("invalid netmask %q", netmask)
+	}
+	if ip.To4() != nil {
+		mask = mask.To4()
+	}
+	ones, bits := net.IPMask(mask).Size()
+	if bits == 0 {
+		return "", fmt.Errorf("invalid netmask %q", netmask)
+	}
+	return fmt.Sprintf("%s/%d", ip, ones), nil
+}
+
+func isIPv6(address string) bool {
+	return strings.Contains(address, ":")
+}
+
+// interfaceOnLink returns the interface with a static address in the same subnet as ip
+func interfaceOnLink(cfg NetworkConfig, ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	names := []string{}
+	for name := range cfg.Interfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		iface := cfg.Interfaces[name]
+		for _, address := range append(append([]string{}, iface.Addresses...), iface.IPv6.Addresses...) {
+			if _, ipNet, err := net.ParseCIDR(address); err == nil && ipNet.Contains(ip) {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+func sortedDevices(devices map[string]netplanV2Device) []string {
+	names := []string{}
+	for name := range devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func appendUnique(values []string, more ...string) []string {
+	for _, value := range more {
+		if !util.Contains(values, value) {
+			values = append(values, value)
+		}
+	}
+	return values
+}
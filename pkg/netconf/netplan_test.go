@@ -0,0 +1,180 @@
+package netconf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNetplanV1(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, err := ParseNetplan([]byte(`network:
+  version: 1
+  config:
+  - type: physical
+    name: eth0
+    mac_address: "52:54:00:12:34:00"
+    subnets:
+    - type: static
+      address: 192.168.1.10
+      netmask: 255.255.255.0
+      gateway: 192.168.1.1
+      dns_nameservers: [192.168.1.2]
+      routes:
+      - network: 10.1.0.0
+        netmask: 255.255.0.0
+        gateway: 192.168.1.254
+    - type: static6
+      address: 2001:db8::10/64
+  - type: physical
+    name: eth1
+  - type: physical
+    name: eth2
+  - type: bond
+    name: bond0
+    bond_interfaces: [eth1, eth2]
+    params:
+      bond-mode: 802.3ad
+      bond-xmit-hash-policy: layer3+4
+    subnets:
+    - type: dhcp
+  - type: vlan
+    name: bond0.100
+    vlan_link: bond0
+    vlan_id: 100
+  - type: bridge
+    name: br0
+    bridge_interfaces: [bond0.100]
+    subnets:
+    - type: ipv6_slaac
+  - type: nameserver
+    address: 8.8.8.8
+    search: [example.com]
+  - type: route
+    destination: 10.2.0.0/16
+    gateway: 192.168.1.253
+    metric: 100
+`))
+	assert.NoError(err)
+
+	assert.Equal([]string{"192.168.1.2", "8.8.8.8"}, cfg.DNS.Nameservers)
+	assert.Equal([]string{"example.com"}, cfg.DNS.Search)
+	assert.Equal(InterfaceConfig{
+		Match:     "mac=52:54:00:12:34:00",
+		Addresses: []string{"192.168.1.10/24"},
+		Gateway:   "192.168.1.1",
+		Routes: []RouteConfig{
+			{Destination: "10.1.0.0/16", Gateway: "192.168.1.254"},
+			{Destination: "10.2.0.0/16", Gateway: "192.168.1.253", Metric: 100},
+		},
+		IPv6: IPv6Config{Mode: IPv6Static, Addresses: []string{"2001:db8::10/64"}},
+	}, cfg.Interfaces["eth0"])
+	assert.Equal(InterfaceConfig{Bond: "bond0"}, cfg.Interfaces["eth1"])
+	assert.Equal(InterfaceConfig{Bond: "bond0"}, cfg.Interfaces["eth2"])
+	assert.Equal(InterfaceConfig{
+		DHCP:     true,
+		BondOpts: map[string]string{"mode": "802.3ad", "xmit_hash_policy": "layer3+4"},
+		Vlans:    "100:bond0.100",
+	}, cfg.Interfaces["bond0"])
+	assert.Equal(InterfaceConfig{Bridge: "br0"}, cfg.Interfaces["bond0.100"])
+	assert.Equal(InterfaceConfig{Bridge: "true", IPv6: IPv6Config{Mode: IPv6SLAAC}}, cfg.Interfaces["br0"])
+}
+
+func TestParseNetplanV2(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, err := ParseNetplan([]byte(`version: 2
+ethernets:
+  id0:
+    match:
+      macaddress: "52:54:00:12:34:00"
+    addresses: [192.168.1.10/24, "2001:db8::10/64"]
+    gateway4: 192.168.1.1
+    gateway6: 2001:db8::1
+    mtu: 9000
+    nameservers:
+      addresses: [192.168.1.2]
+      search: [example.com]
+    routes:
+    - to: 10.1.0.0/16
+      via: 192.168.1.254
+      metric: 100
+      table: 200
+    routing-policy:
+    - from: 192.168.1.0/24
+      table: 200
+  en:
+    match:
+      name: "en*"
+    dhcp4: true
+    dhcp6: true
+bonds:
+  bond0:
+    interfaces: [id0]
+    parameters:
+      mode: active-backup
+      mii-monitor-interval: 100
+bridges:
+  br0:
+    interfaces: [vlan10]
+    accept-ra: true
+vlans:
+  vlan10:
+    id: 10
+    link: bond0
+`))
+	assert.NoError(err)
+
+	assert.Equal([]string{"192.168.1.2"}, cfg.DNS.Nameservers)
+	assert.Equal([]string{"example.com"}, cfg.DNS.Search)
+	assert.Equal(InterfaceConfig{
+		Match:     "mac=52:54:00:12:34:00",
+		Addresses: []string{"192.168.1.10/24"},
+		Gateway:   "192.168.1.1",
+		MTU:       9000,
+		Bond:      "bond0",
+		Routes:    []RouteConfig{{Destination: "10.1.0.0/16", Gateway: "192.168.1.254", Metric: 100, Table: 200}},
+		Rules:     []RuleConfig{{From: "192.168.1.0/24", Table: 200}},
+		IPv6:      IPv6Config{Mode: IPv6Static, Addresses: []string{"2001:db8::10/64"}, Gateway: "2001:db8::1"},
+	}, cfg.Interfaces["id0"])
+	assert.Equal(InterfaceConfig{Match: "en*", DHCP: true, IPv6: IPv6Config{Mode: IPv6DHCP}}, cfg.Interfaces["en"])
+	assert.Equal(InterfaceConfig{
+		BondOpts: map[string]string{"mode": "active-backup", "miimon": "100"},
+		Vlans:    "10:vlan10",
+	}, cfg.Interfaces["bond0"])
+	assert.Equal(InterfaceConfig{Bridge: "br0"}, cfg.Interfaces["vlan10"])
+	acceptRA := true
+	assert.Equal(InterfaceConfig{Bridge: "true", IPv6: IPv6Config{Mode: IPv6SLAAC, AcceptRA: &acceptRA}}, cfg.Interfaces["br0"])
+
+	_, err = ParseNetplan([]byte("version: 3\n"))
+	assert.Error(err)
+}
+
+func TestMergeNetplan(t *testing.T) {
+	assert := require.New(t)
+
+	netCfg := NetworkConfig{
+		DNS: DNSConfig{Nameservers: []string{"8.8.8.8"}},
+		Interfaces: map[string]InterfaceConfig{
+			"eth0": {DHCP: true},
+		},
+		Netplan: map[interface{}]interface{}{
+			"version": 2,
+			"ethernets": map[interface{}]interface{}{
+				"eth0": map[interface{}]interface{}{"addresses": []interface{}{"10.0.0.2/24"}},
+				"eth1": map[interface{}]interface{}{
+					"addresses":   []interface{}{"10.0.1.2/24"},
+					"nameservers": map[interface{}]interface{}{"addresses": []interface{}{"8.8.8.8", "10.0.1.1"}},
+				},
+			},
+		},
+	}
+	assert.NoError(MergeNetplan(&netCfg))
+	assert.Nil(netCfg.Netplan)
+	assert.Equal([]string{"8.8.8.8", "10.0.1.1"}, netCfg.DNS.Nameservers)
+	assert.Equal(map[string]InterfaceConfig{
+		"eth0": {DHCP: true},
+		"eth1": {Addresses: []string{"10.0.1.2/24"}},
+	}, netCfg.Interfaces)
+}
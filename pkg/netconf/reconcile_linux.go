@@ -0,0 +1,438 @@
+package netconf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"github.com/rancher/os/pkg/log"
+	"github.com/rancher/os/pkg/util"
+
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	ActionAdd    = "add"
+	ActionRemove = "remove"
+
+	KindBridge  = "bridge"
+	KindBond    = "bond"
+	KindVlan    = "vlan"
+	KindSlave   = "slave"
+	KindAddress = "address"
+	KindRoute   = "route"
+	KindRule    = "rule"
+
+	// what netconf has applied, so that it can be removed once it's not
+	// configured anymore. In /run as none of it survives a reboot.
+	StateFile = "/run/netconf/state.json"
+)
+
+// State is what netconf applied, the rest is left alone
+type State struct {
+	// bridges, bonds and vlans created, by name
+	Links map[string]string `json:"links,omitempty"`
+	// static addresses, by link name
+	Addresses map[string][]string `json:"addresses,omitempty"`
+	// rules can't be tagged like routes are
+	Rules []RuleConfig `json:"rules,omitempty"`
+}
+
+// Change is one step of making the network match the config
+type Change struct {
+	Action string `json:"action"`
+	Kind   string `json:"kind"`
+	Link   string `json:"link,omitempty"`
+	Value  string `json:"value,omitempty"`
+
+	addr  *netlink.Addr
+	route *netlink.Route
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case KindBridge, KindBond, KindVlan:
+		return fmt.Sprintf("%s %s %s", c.Action, c.Kind, c.Link)
+	case KindSlave:
+		if c.Action == ActionAdd {
+			return fmt.Sprintf("add %s to %s", c.Link, c.Value)
+		}
+		return fmt.Sprintf("remove %s from %s", c.Link, c.Value)
+	case KindRule:
+		return fmt.Sprintf("%s rule %s", c.Action, c.Value)
+	}
+	return fmt.Sprintf("%s %s %s on %s", c.Action, c.Kind, c.Value, c.Link)
+}
+
+// linkState is what netlink reports for a link
+type linkState struct {
+	link      netlink.Link
+	master    string
+	addresses []netlink.Addr
+	routes    []netlink.Route
+}
+
+// Plan returns the changes ApplyNetworkConfigs would make, without making them
+func Plan(netCfg *NetworkConfig) ([]Change, error) {
+	populateDefault(netCfg)
+	links, err := getLinkStates()
+	if err != nil {
+		return nil, err
+	}
+	return diff(netCfg, links, ReadState(StateFile), currentRules(netCfg)), nil
+}
+
+func getLinkStates() ([]linkState, error) {
+	links, err := GetValidLinkList()
+	if err != nil {
+		return nil, err
+	}
+	names := map[int]string{}
+	for _, link := range links {
+		names[link.Attrs().Index] = link.Attrs().Name
+	}
+
+	states := []linkState{}
+	for _, link := range links {
+		state := linkState{
+			link:   link,
+			master: names[link.Attrs().MasterIndex],
+		}
+		if state.addresses, err = getLinkAddrs(link); err != nil {
+			return nil, err
+		}
+		if state.routes, err = managedRoutes(link); err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// currentRules returns which of the configured rules are in place already
+func currentRules(netCfg *NetworkConfig) map[string]bool {
+	existing := map[string]bool{}
+	for _, rule := range configuredRules(netCfg) {
+		r, err := netlinkRule(rule)
+		if err != nil {
+			continue
+		}
+		if ok, err := ruleExists(r); err == nil && ok {
+			existing[ruleKey(rule)] = true
+		}
+	}
+	return existing
+}
+
+// desiredLinks returns the bridges, bonds and vlans netCfg declares, by name
+func desiredLinks(netCfg *NetworkConfig, links []linkState) map[string]string {
+	desired := map[string]string{}
+	for name, iface := range netCfg.Interfaces {
+		if iface.Bridge == "true" {
+			desired[name] = KindBridge
+		} else if iface.Bridge != "" {
+			desired[iface.Bridge] = KindBridge
+		} else if iface.Bond != "" {
+			desired[iface.Bond] = KindBond
+		}
+	}
+	for _, state := range links {
+		match, ok := findMatch(state.link, netCfg)
+		if !ok {
+			continue
+		}
+		vlanDefs, err := ParseVlanDefinitions(match.Vlans)
+		if err != nil {
+			continue
+		}
+		for _, vlanDef := range vlanDefs {
+			name := vlanDef.Name
+			if name == "" {
+				name = fmt.Sprintf("%s.%d", state.link.Attrs().Name, vlanDef.ID)
+			}
+			desired[name] = KindVlan
+		}
+	}
+	return desired
+}
+
+// staticAddresses returns the addresses netconf applies to a link
+func staticAddresses(match InterfaceConfig) []string {
+	addresses := []string{}
+	if match.Bond != "" || (match.Bridge != "" && match.Bridge != "true") {
+		return addresses
+	}
+	if !match.DHCP && match.WifiNetwork == "" {
+		if match.Address != "" {
+			addresses = append(addresses, match.Address)
+		}
+		addresses = append(addresses, match.Addresses...)
+	}
+	return append(addresses, match.IPv6.Addresses...)
+}
+
+// normalizeAddress returns address the way netlink reports it
+func normalizeAddress(address string) string {
+	addr, err := netlink.ParseAddr(address)
+	if err != nil {
+		return address
+	}
+	return addr.IPNet.String()
+}
+
+// diff compares netCfg to the links and to what was applied before. Only
+// what netconf manages is removed: the links, addresses and rules in state,
+// routes it tagged, and the addresses of static interfaces.
+func diff(netCfg *NetworkConfig, links []linkState, state State, existingRules map[string]bool) []Change {
+	changes := []Change{}
+	current := map[string]linkState{}
+	for _, l := range links {
+		current[l.link.Attrs().Name] = l
+	}
+
+	desired := desiredLinks(netCfg, links)
+	for _, name := range sortedLinks(desired) {
+		if _, ok := current[name]; !ok {
+			changes = append(changes, Change{Action: ActionAdd, Kind: desired[name], Link: name})
+		}
+	}
+
+	for _, l := range links {
+		name := l.link.Attrs().Name
+		match, matched := findMatch(l.link, netCfg)
+
+		// slaves
+		if l.master != "" && state.Links[l.master] != "" {
+			if !matched || (match.Bond != l.master && match.Bridge != l.master) {
+				changes = append(changes, Change{Action: ActionRemove, Kind: KindSlave, Link: name, Value: l.master})
+			}
+		}
+		if matched {
+			master := match.Bond
+			if master == "" && match.Bridge != "true" {
+				master = match.Bridge
+			}
+			if master != "" && master != l.master {
+				changes = append(changes, Change{Action: ActionAdd, Kind: KindSlave, Link: name, Value: master})
+			}
+		}
+
+		// addresses
+		declared := map[string]bool{}
+		if matched {
+			for _, address := range staticAddresses(match) {
+				declared[normalizeAddress(address)] = true
+			}
+		}
+		applied := map[string]bool{}
+		for _, address := range state.Addresses[name] {
+			applied[normalizeAddress(address)] = true
+		}
+		static := matched && !match.DHCP && !match.IPV4LL && match.WifiNetwork == "" &&
+			match.Bond == "" && (match.Bridge == "" || match.Bridge == "true")
+		existing := map[string]bool{}
+		for _, addr := range l.addresses {
+			address := addr.IPNet.String()
+			existing[address] = true
+			if declared[address] {
+				continue
+			}
+			if applied[address] || (static && !keepDynamicIPv6(addr, match.IPv6)) {
+				addr := addr
+				changes = append(changes, Change{Action: ActionRemove, Kind: KindAddress, Link: name, Value: address, addr: &addr})
+			}
+		}
+		for _, address := range sortedKeys(declared) {
+			if !existing[address] {
+				changes = append(changes, Change{Action: ActionAdd, Kind: KindAddress, Link: name, Value: address})
+			}
+		}
+
+		// routes
+		desiredRoutes := map[string]bool{}
+		if matched {
+			for _, route := range interfaceRoutes(match) {
+				if r, err := netlinkRoute(l.link, route); err == nil {
+					desiredRoutes[routeKey(*r)] = true
+				}
+			}
+		}
+		existingRoutes := map[string]bool{}
+		for _, r := range l.routes {
+			key := routeKey(r)
+			existingRoutes[key] = true
+			if !desiredRoutes[key] {
+				r := r
+				changes = append(changes, Change{Action: ActionRemove, Kind: KindRoute, Link: name, Value: key, route: &r})
+			}
+		}
+		for _, key := range sortedKeys(desiredRoutes) {
+			if !existingRoutes[key] {
+				changes = append(changes, Change{Action: ActionAdd, Kind: KindRoute, Link: name, Value: key})
+			}
+		}
+	}
+
+	// links go after what is on them, vlans before the bonds they can be on
+	for _, kind := range []string{KindVlan, KindBond, KindBridge} {
+		for _, name := range sortedLinks(state.Links) {
+			if state.Links[name] != kind || desired[name] == kind {
+				continue
+			}
+			if _, ok := current[name]; ok {
+				changes = append(changes, Change{Action: ActionRemove, Kind: kind, Link: name})
+			}
+		}
+	}
+
+	desiredRules := map[string]bool{}
+	for _, rule := range configuredRules(netCfg) {
+		desiredRules[ruleKey(rule)] = true
+		if !existingRules[ruleKey(rule)] {
+			changes = append(changes, Change{Action: ActionAdd, Kind: KindRule, Value: ruleKey(rule)})
+		}
+	}
+	for _, rule := range state.Rules {
+		if !desiredRules[ruleKey(rule)] {
+			changes = append(changes, Change{Action: ActionRemove, Kind: KindRule, Value: ruleKey(rule)})
+		}
+	}
+	return changes
+}
+
+// reconcile removes what is not configured anymore, adding is left to the
+// rest of ApplyNetworkConfigs
+func reconcile(netCfg *NetworkConfig, state State) {
+	links, err := getLinkStates()
+	if err != nil {
+		log.Errorf("Failed to get the state of the links: %v", err)
+		return
+	}
+	for _, change := range diff(netCfg, links, state, map[string]bool{}) {
+		if change.Action != ActionRemove || change.Kind == KindRule {
+			// rules are removed by applyRules
+			continue
+		}
+		if err := removeChange(change); err != nil {
+			log.Errorf("Failed to %s: %v", change, err)
+		} else {
+			log.Infof("Reconcile: %s", change)
+		}
+	}
+}
+
+func removeChange(change Change) error {
+	switch change.Kind {
+	case KindAddress:
+		link, err := netlink.LinkByName(change.Link)
+		if err != nil {
+			return err
+		}
+		if err := netlink.AddrDel(link, change.addr); err != nil && err != syscall.EADDRNOTAVAIL {
+			return err
+		}
+	case KindRoute:
+		if err := netlink.RouteDel(change.route); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	case KindSlave:
+		link, err := netlink.LinkByName(change.Link)
+		if err != nil {
+			return err
+		}
+		if master, err := netlink.LinkByName(change.Value); err == nil {
+			if _, ok := master.(*netlink.Bond); ok {
+				return (&Bonding{name: change.Value}).RemoveSlave(change.Link)
+			}
+		}
+		return netlink.LinkSetNoMaster(link)
+	case KindBond:
+		link, err := netlink.LinkByName(change.Link)
+		if err != nil {
+			return err
+		}
+		netlink.LinkSetDown(link)
+		return ioutil.WriteFile(bondingMasters, []byte("-"+change.Link), 0644)
+	case KindBridge, KindVlan:
+		link, err := netlink.LinkByName(change.Link)
+		if err != nil {
+			return err
+		}
+		return netlink.LinkDel(link)
+	}
+	return nil
+}
+
+// recordState stores what ApplyNetworkConfigs has just applied
+func recordState(netCfg *NetworkConfig, state *State) {
+	links, err := getLinkStates()
+	if err != nil {
+		log.Errorf("Failed to get the state of the links: %v", err)
+		return
+	}
+	state.Links = map[string]string{}
+	for name, kind := range desiredLinks(netCfg, links) {
+		for _, l := range links {
+			if l.link.Attrs().Name == name {
+				state.Links[name] = kind
+			}
+		}
+	}
+	state.Addresses = map[string][]string{}
+	for _, l := range links {
+		if match, ok := findMatch(l.link, netCfg); ok {
+			if addresses := staticAddresses(match); len(addresses) > 0 {
+				state.Addresses[l.link.Attrs().Name] = addresses
+			}
+		}
+	}
+}
+
+// ReadState reads what netconf applied before, if anything
+func ReadState(stateFile string) State {
+	state := State{}
+	bytes, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("Failed to read %s: %v", stateFile, err)
+		}
+		return state
+	}
+	if err := json.Unmarshal(bytes, &state); err != nil {
+		log.Errorf("Failed to parse %s: %v", stateFile, err)
+	}
+	return state
+}
+
+func writeState(stateFile string, state State) error {
+	bytes, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(stateFile), 0755); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(stateFile, bytes, 0644)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedLinks(m map[string]string) []string {
+	names := []string{}
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
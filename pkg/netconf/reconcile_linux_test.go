@@ -0,0 +1,115 @@
+package netconf
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+)
+
+func testLinkState(index int, name, t, master string, addresses ...string) linkState {
+	linkAttrs := netlink.NewLinkAttrs()
+	linkAttrs.Index = index
+	linkAttrs.Name = name
+	state := linkState{
+		link:   mockLink{attrs: linkAttrs, t: t},
+		master: master,
+	}
+	for _, address := range addresses {
+		addr, _ := netlink.ParseAddr(address)
+		addr.Flags = 0x80 // IFA_F_PERMANENT
+		state.addresses = append(state.addresses, *addr)
+	}
+	return state
+}
+
+func TestDiff(t *testing.T) {
+	assert := require.New(t)
+
+	netCfg := &NetworkConfig{
+		Interfaces: map[string]InterfaceConfig{
+			"eth0": {
+				Address: "10.0.0.2/24",
+				Routes:  []RouteConfig{{Destination: "10.1.0.0/16", Gateway: "10.0.0.1"}},
+				Rules:   []RuleConfig{{FwMark: 1, Table: 100}},
+			},
+			"eth1":  {Bond: "bond0"},
+			"bond0": {Addresses: []string{"10.0.1.2/24"}},
+		},
+	}
+
+	eth0 := testLinkState(2, "eth0", "device", "", "10.0.0.2/24", "10.0.0.5/24")
+	_, dst, _ := net.ParseCIDR("10.2.0.0/16")
+	eth0.routes = []netlink.Route{{LinkIndex: 2, Dst: dst, Gw: net.ParseIP("10.0.0.1"), Table: 254, Protocol: RouteProtocol}}
+	links := []linkState{
+		eth0,
+		testLinkState(3, "eth1", "device", "br0"),
+		testLinkState(4, "eth2", "device", "", "192.168.1.5/24", "192.168.1.9/24"),
+		testLinkState(5, "eth2.100", "vlan", ""),
+		testLinkState(6, "br0", "bridge", ""),
+	}
+	state := State{
+		Links:     map[string]string{"br0": KindBridge, "eth2.100": KindVlan},
+		Addresses: map[string][]string{"eth2": {"192.168.1.5/24"}},
+		Rules:     []RuleConfig{{From: "10.0.9.0/24", Table: 200}},
+	}
+
+	changes := []string{}
+	for _, change := range diff(netCfg, links, state, map[string]bool{}) {
+		changes = append(changes, change.String())
+	}
+	assert.Equal([]string{
+		"add bond bond0",
+		"remove address 10.0.0.5/24 on eth0",
+		"remove route 10.2.0.0/16 via 10.0.0.1 metric 0 table 254 on eth0",
+		"add route 10.1.0.0/16 via 10.0.0.1 metric 0 table 254 on eth0",
+		"remove eth1 from br0",
+		"add eth1 to bond0",
+		"remove address 192.168.1.5/24 on eth2",
+		"remove vlan eth2.100",
+		"remove bridge br0",
+		"add rule from all fwmark 1 lookup 100",
+		"remove rule from 10.0.9.0/24 lookup 200",
+	}, changes)
+}
+
+func TestDiffNoChanges(t *testing.T) {
+	assert := require.New(t)
+
+	netCfg := &NetworkConfig{
+		Interfaces: map[string]InterfaceConfig{
+			"eth0": {DHCP: true, Vlans: "100"},
+		},
+	}
+	links := []linkState{
+		testLinkState(2, "eth0", "device", "", "192.168.1.5/24"),
+		testLinkState(3, "eth0.100", "vlan", ""),
+	}
+	state := State{
+		Links: map[string]string{"eth0.100": KindVlan},
+	}
+	assert.Empty(diff(netCfg, links, state, map[string]bool{}))
+}
+
+func TestState(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "netconf")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "netconf", "state.json")
+
+	assert.Equal(State{}, ReadState(stateFile))
+
+	state := State{
+		Links:     map[string]string{"br0": KindBridge},
+		Addresses: map[string][]string{"eth0": {"10.0.0.2/24"}},
+		Rules:     []RuleConfig{{From: "10.0.1.0/24", Table: 100}},
+	}
+	assert.NoError(writeState(stateFile, state))
+	assert.Equal(state, ReadState(stateFile))
+}
@@ -0,0 +1,261 @@
+package netconf
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"syscall"
+
+	"github.com/rancher/os/pkg/log"
+
+	"github.com/vishvananda/netlink"
+)
+
+// routes added from the config are tagged with this protocol, so the ones
+// that are no longer configured can be told from everything else
+const RouteProtocol = 0x52
+
+// interfaceRoutes returns all the routes configured for an interface
+func interfaceRoutes(netConf InterfaceConfig) []RouteConfig {
+	return append(append([]RouteConfig{}, netConf.Routes...), netConf.IPv6.Routes...)
+}
+
+func routeKey(r netlink.Route) string {
+	dst := "default"
+	if r.Dst != nil {
+		dst = r.Dst.String()
+	}
+	metric := r.Priority
+	if metric == 0 && isIPv6Route(r) {
+		// the kernel's default metric for ipv6 routes
+		metric = 1024
+	}
+	return fmt.Sprintf("%s via %s metric %d table %d", dst, r.Gw, metric, r.Table)
+}
+
+func isIPv6Route(r netlink.Route) bool {
+	if r.Dst != nil {
+		return r.Dst.IP.To4() == nil
+	}
+	return r.Gw != nil && r.Gw.To4() == nil
+}
+
+// applyRoutes makes the routes we manage on link match the config,
+// removing the ones that are not configured anymore
+func applyRoutes(link netlink.Link, netConf InterfaceConfig) {
+	linkName := link.Attrs().Name
+
+	desired := map[string]*netlink.Route{}
+	for _, route := range interfaceRoutes(netConf) {
+		r, err := netlinkRoute(link, route)
+		if err != nil {
+			log.Errorf("Invalid route %s via %s on %s: %v", route.Destination, route.Gateway, linkName, err)
+			continue
+		}
+		desired[routeKey(*r)] = r
+	}
+
+	current, err := managedRoutes(link)
+	if err != nil {
+		log.Errorf("Failed to list routes on %s: %v", linkName, err)
+	}
+	for _, r := range current {
+		if _, ok := desired[routeKey(r)]; ok {
+			continue
+		}
+		r := r
+		if err := netlink.RouteDel(&r); err != nil && err != syscall.ESRCH {
+			log.Errorf("Failed to remove route %s from %s: %v", routeKey(r), linkName, err)
+		} else {
+			log.Infof("Removed route %s from %s", routeKey(r), linkName)
+		}
+	}
+
+	keys := []string{}
+	for key := range desired {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := netlink.RouteReplace(desired[key]); err != nil {
+			log.Errorf("Failed to add route %s on %s: %v", key, linkName, err)
+		} else {
+			log.Infof("Added route %s on %s", key, linkName)
+		}
+	}
+}
+
+func managedRoutes(link netlink.Link) ([]netlink.Route, error) {
+	filter := &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Protocol:  RouteProtocol,
+		Table:     syscall.RT_TABLE_UNSPEC,
+	}
+	return netlink.RouteListFiltered(netlink.FAMILY_ALL, filter,
+		netlink.RT_FILTER_OIF|netlink.RT_FILTER_PROTOCOL|netlink.RT_FILTER_TABLE)
+}
+
+func netlinkRule(rule RuleConfig) (*netlink.Rule, error) {
+	if rule.Table <= 0 {
+		return nil, fmt.Errorf("rule needs a table")
+	}
+	r := netlink.NewRule()
+	r.Table = rule.Table
+	if rule.Priority > 0 {
+		r.Priority = rule.Priority
+	}
+	if rule.FwMark > 0 {
+		r.Mark = rule.FwMark
+	}
+	var err error
+	if r.Src, err = parseRuleNet(rule.From); err != nil {
+		return nil, err
+	}
+	if r.Dst, err = parseRuleNet(rule.To); err != nil {
+		return nil, err
+	}
+	if r.Src != nil && r.Dst != nil && (r.Src.IP.To4() == nil) != (r.Dst.IP.To4() == nil) {
+		return nil, fmt.Errorf("from %s and to %s are not the same IP family", rule.From, rule.To)
+	}
+	return r, nil
+}
+
+// parseRuleNet accepts a CIDR or a single address
+func parseRuleNet(s string) (*net.IPNet, error) {
+	if s == "" || s == "all" {
+		return nil, nil
+	}
+	if _, ipNet, err := net.ParseCIDR(s); err == nil {
+		return ipNet, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %s", s)
+	}
+	if ip.To4() != nil {
+		return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// ruleKey describes rule the way ip rule does
+func ruleKey(rule RuleConfig) string {
+	key := "from all"
+	if rule.From != "" {
+		key = "from " + rule.From
+	}
+	if rule.To != "" {
+		key += " to " + rule.To
+	}
+	if rule.FwMark > 0 {
+		key += fmt.Sprintf(" fwmark %d", rule.FwMark)
+	}
+	key += fmt.Sprintf(" lookup %d", rule.Table)
+	if rule.Priority > 0 {
+		key += fmt.Sprintf(" priority %d", rule.Priority)
+	}
+	return key
+}
+
+// configuredRules returns the rules of all the interfaces, without duplicates
+func configuredRules(netCfg *NetworkConfig) []RuleConfig {
+	names := []string{}
+	for name := range netCfg.Interfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rules := []RuleConfig{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		for _, rule := range netCfg.Interfaces[name].Rules {
+			if key := ruleKey(rule); !seen[key] {
+				seen[key] = true
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules
+}
+
+// applyRules adds the configured policy routing rules and removes the ones
+// we added before that are not configured anymore, they are kept in state
+// as rules can't be tagged
+func applyRules(netCfg *NetworkConfig, state *State) {
+	desired := configuredRules(netCfg)
+	desiredKeys := map[string]bool{}
+	for _, rule := range desired {
+		desiredKeys[ruleKey(rule)] = true
+	}
+
+	for _, rule := range state.Rules {
+		if desiredKeys[ruleKey(rule)] {
+			continue
+		}
+		r, err := netlinkRule(rule)
+		if err != nil {
+			continue
+		}
+		if err := netlink.RuleDel(r); err != nil && err != syscall.ENOENT {
+			log.Errorf("Failed to remove rule %s: %v", ruleKey(rule), err)
+		} else {
+			log.Infof("Removed rule %s", ruleKey(rule))
+		}
+	}
+
+	applied := []RuleConfig{}
+	for _, rule := range desired {
+		r, err := netlinkRule(rule)
+		if err != nil {
+			log.Errorf("Invalid rule %s: %v", ruleKey(rule), err)
+			continue
+		}
+		if exists, err := ruleExists(r); err != nil {
+			log.Errorf("Failed to list rules: %v", err)
+		} else if !exists {
+			if err := netlink.RuleAdd(r); err != nil && err != syscall.EEXIST {
+				log.Errorf("Failed to add rule %s: %v", ruleKey(rule), err)
+				continue
+			}
+			log.Infof("Added rule %s", ruleKey(rule))
+		}
+		applied = append(applied, rule)
+	}
+
+	state.Rules = applied
+}
+
+// ruleExists checks for the rule first, as older kernels don't refuse duplicates
+func ruleExists(rule *netlink.Rule) (bool, error) {
+	family := netlink.FAMILY_V4
+	if (rule.Src != nil && rule.Src.IP.To4() == nil) || (rule.Dst != nil && rule.Dst.IP.To4() == nil) {
+		family = netlink.FAMILY_V6
+	}
+	rules, err := netlink.RuleList(family)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range rules {
+		if sameRule(r, *rule) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func sameRule(a, b netlink.Rule) bool {
+	if a.Table != b.Table || a.Mark != b.Mark {
+		return false
+	}
+	if b.Priority >= 0 && a.Priority != b.Priority {
+		return false
+	}
+	return sameNet(a.Src, b.Src) && sameNet(a.Dst, b.Dst)
+}
+
+func sameNet(a, b *net.IPNet) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.String() == b.String()
+}
@@ -0,0 +1,70 @@
+package netconf
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+)
+
+func TestNetlinkRouteTable(t *testing.T) {
+	assert := require.New(t)
+
+	linkAttrs := netlink.NewLinkAttrs()
+	linkAttrs.Index = 2
+	link := mockLink{attrs: linkAttrs}
+
+	route, err := netlinkRoute(link, RouteConfig{Destination: "10.1.0.0/16", Gateway: "10.0.0.1"})
+	assert.NoError(err)
+	assert.Equal(syscall.RT_TABLE_MAIN, route.Table)
+	assert.Equal(RouteProtocol, route.Protocol)
+	assert.Equal("10.1.0.0/16 via 10.0.0.1 metric 0 table 254", routeKey(*route))
+
+	route, err = netlinkRoute(link, RouteConfig{Gateway: "10.0.0.1", Metric: 10, Table: 100})
+	assert.NoError(err)
+	assert.Equal(100, route.Table)
+	assert.Equal("default via 10.0.0.1 metric 10 table 100", routeKey(*route))
+
+	route, err = netlinkRoute(link, RouteConfig{Destination: "2001:db8::/64"})
+	assert.NoError(err)
+	assert.Equal("2001:db8::/64 via <nil> metric 1024 table 254", routeKey(*route))
+}
+
+func TestNetlinkRule(t *testing.T) {
+	assert := require.New(t)
+
+	rule, err := netlinkRule(RuleConfig{From: "10.0.1.0/24", Table: 100})
+	assert.NoError(err)
+	assert.Equal("10.0.1.0/24", rule.Src.String())
+	assert.Nil(rule.Dst)
+	assert.Equal(100, rule.Table)
+	assert.Equal(-1, rule.Priority)
+	assert.Equal(-1, rule.Mark)
+
+	rule, err = netlinkRule(RuleConfig{To: "2001:db8::1", FwMark: 3, Table: 200, Priority: 1000})
+	assert.NoError(err)
+	assert.Equal("2001:db8::1/128", rule.Dst.String())
+	assert.Equal(3, rule.Mark)
+	assert.Equal(1000, rule.Priority)
+
+	_, err = netlinkRule(RuleConfig{From: "10.0.1.0/24"})
+	assert.Error(err)
+	_, err = netlinkRule(RuleConfig{From: "10.0.1.0/24", To: "2001:db8::/64", Table: 100})
+	assert.Error(err)
+	_, err = netlinkRule(RuleConfig{From: "nonsense", Table: 100})
+	assert.Error(err)
+}
+
+func TestConfiguredRules(t *testing.T) {
+	assert := require.New(t)
+
+	rule := RuleConfig{From: "10.0.1.0/24", Table: 100}
+	netCfg := &NetworkConfig{
+		Interfaces: map[string]InterfaceConfig{
+			"eth1": {Rules: []RuleConfig{rule, {FwMark: 1, Table: 101}}},
+			"eth0": {Rules: []RuleConfig{rule}},
+		},
+	}
+	assert.Equal([]RuleConfig{rule, {FwMark: 1, Table: 101}}, configuredRules(netCfg))
+}
@@ -11,6 +11,9 @@ type NetworkConfig struct {
 	NoProxy       string                        `yaml:"no_proxy,omitempty"`
 	WifiNetworks  map[string]WifiNetworkConfig  `yaml:"wifi_networks,omitempty"`
 	ModemNetworks map[string]ModemNetworkConfig `yaml:"modem_networks,omitempty"`
+	// Netplan is a cloud-init network-config, version 1 or 2, merged into
+	// Interfaces and DNS by MergeNetplan
+	Netplan map[interface{}]interface{} `yaml:"netplan,omitempty"`
 }
 
 type InterfaceConfig struct {
@@ -30,6 +33,41 @@ type InterfaceConfig struct {
 	PreUp       []string          `yaml:"pre_up,omitempty"`
 	Vlans       string            `yaml:"vlans,omitempty"`
 	WifiNetwork string            `yaml:"wifi_network,omitempty"`
+	IPv6        IPv6Config        `yaml:"ipv6,omitempty"`
+	Routes      []RouteConfig     `yaml:"routes,omitempty"`
+	Rules       []RuleConfig      `yaml:"rules,omitempty"`
+}
+
+// IPv6Config configures how an interface gets its IPv6 addresses: from
+// router advertisements (slaac), from dhcpcd (dhcpv6), or only the static
+// Addresses. AcceptRA overrides the accept_ra sysctl the mode implies.
+type IPv6Config struct {
+	Mode        string        `yaml:"mode,omitempty"`
+	AcceptRA    *bool         `yaml:"accept_ra,omitempty"`
+	Privacy     bool          `yaml:"privacy,omitempty"`
+	Addresses   []string      `yaml:"addresses,omitempty"`
+	Gateway     string        `yaml:"gateway,omitempty"`
+	Routes      []RouteConfig `yaml:"routes,omitempty"`
+	Nameservers []string      `yaml:"nameservers,flow,omitempty"`
+}
+
+// RouteConfig is a route through the interface, in the main table unless
+// Table is set. A Destination of "default" or none is the default route.
+type RouteConfig struct {
+	Destination string `yaml:"destination,omitempty"`
+	Gateway     string `yaml:"gateway,omitempty"`
+	Metric      int    `yaml:"metric,omitempty"`
+	Table       int    `yaml:"table,omitempty"`
+}
+
+// RuleConfig is a policy routing rule looking up Table for the traffic
+// matching From, To and FwMark
+type RuleConfig struct {
+	From     string `yaml:"from,omitempty"`
+	To       string `yaml:"to,omitempty"`
+	FwMark   int    `yaml:"fwmark,omitempty"`
+	Table    int    `yaml:"table,omitempty"`
+	Priority int    `yaml:"priority,omitempty"`
 }
 
 type DNSConfig struct {
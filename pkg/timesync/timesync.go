@@ -0,0 +1,183 @@
+package timesync
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/rancher/os/config"
+	"github.com/rancher/os/pkg/log"
+	"github.com/rancher/os/pkg/util"
+)
+
+const (
+	ZoneInfoDir = "/usr/share/zoneinfo"
+
+	defaultSyncTimeout = 10
+	// offsets smaller than this are left for ntpd to slew away
+	stepThreshold = 128 * time.Millisecond
+	// seconds between the ntp epoch (1900) and the unix epoch (1970)
+	ntpEpochOffset = 2208988800
+)
+
+var ErrNoServers = errors.New("No rancher.time servers or pools configured")
+
+// SyncClock steps the clock from the configured servers, so that TLS
+// certificates can be checked. Nothing is done when rancher.time has no
+// servers or pools.
+func SyncClock(cfg config.TimeConfig) {
+	if len(cfg.Servers) == 0 && len(cfg.Pools) == 0 {
+		return
+	}
+
+	timeout := cfg.SyncTimeout
+	if timeout == 0 {
+		timeout = defaultSyncTimeout
+	}
+	offset, err := Sync(cfg, time.Duration(timeout)*time.Second)
+	if err != nil {
+		log.Errorf("Failed to sync the clock: %v", err)
+		return
+	}
+	log.Infof("Clock was off by %v", offset)
+}
+
+// GenerateNtpConf renders an ntpd config using the configured servers and pools
+func GenerateNtpConf(cfg config.TimeConfig) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("# generated from rancher.time\n")
+	buf.WriteString("restrict default nomodify nopeer noquery limited kod\n")
+	buf.WriteString("restrict 127.0.0.1\n")
+	buf.WriteString("restrict ::1\n")
+	for _, server := range cfg.Servers {
+		fmt.Fprintf(&buf, "server %s iburst\n", server)
+	}
+	for _, pool := range cfg.Pools {
+		fmt.Fprintf(&buf, "pool %s iburst\n", pool)
+	}
+	return buf.Bytes()
+}
+
+// WriteNtpConf writes the ntpd config to filename, or removes it when there
+// are no servers or pools so that ntpd falls back to its own config
+func WriteNtpConf(filename string, cfg config.TimeConfig) error {
+	if len(cfg.Servers) == 0 && len(cfg.Pools) == 0 {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(filename, GenerateNtpConf(cfg), 0644)
+}
+
+// Sync asks the servers, then the pools, for the time with SNTP and steps
+// the clock with the first answer. It returns how far the clock was off.
+func Sync(cfg config.TimeConfig, timeout time.Duration) (time.Duration, error) {
+	hosts := append(append([]string{}, cfg.Servers...), cfg.Pools...)
+	if len(hosts) == 0 {
+		return 0, ErrNoServers
+	}
+
+	deadline := time.Now().Add(timeout)
+	var lastErr error
+	for {
+		for _, host := range hosts {
+			offset, err := Query(host, 2*time.Second)
+			if err != nil {
+				log.Debugf("Failed to query %s: %v", host, err)
+				lastErr = err
+				continue
+			}
+			if offset > stepThreshold || offset < -stepThreshold {
+				if err := step(offset); err != nil {
+					return offset, err
+				}
+			}
+			return offset, nil
+		}
+		if time.Now().After(deadline) {
+			return 0, lastErr
+		}
+		// the network may still be coming up
+		time.Sleep(time.Second)
+	}
+}
+
+// Query returns the offset of the local clock from host, using SNTP
+func Query(host string, timeout time.Duration) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "123")
+	}
+	conn, err := net.DialTimeout("udp", host, timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	request := make([]byte, 48)
+	// no leap warning, version 3, client mode
+	request[0] = 0<<6 | 3<<3 | 3
+
+	sent := time.Now()
+	if _, err := conn.Write(request); err != nil {
+		return 0, err
+	}
+	response := make([]byte, 48)
+	n, err := conn.Read(response)
+	if err != nil {
+		return 0, err
+	}
+	received := time.Now()
+
+	if n < 48 {
+		return 0, fmt.Errorf("short response from %s", host)
+	}
+	if mode := response[0] & 0x7; mode != 4 {
+		return 0, fmt.Errorf("unexpected mode %d in response from %s", mode, host)
+	}
+	if stratum := response[1]; stratum == 0 || stratum > 15 {
+		return 0, fmt.Errorf("%s is not synchronized (stratum %d)", host, stratum)
+	}
+
+	serverReceived := ntpTime(response[32:40])
+	serverSent := ntpTime(response[40:48])
+	return (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2, nil
+}
+
+func ntpTime(b []byte) time.Time {
+	seconds := int64(binary.BigEndian.Uint32(b[0:4])) - ntpEpochOffset
+	fraction := int64(binary.BigEndian.Uint32(b[4:8]))
+	return time.Unix(seconds, fraction*1e9>>32)
+}
+
+func step(offset time.Duration) error {
+	now := time.Now().Add(offset)
+	tv := syscall.NsecToTimeval(now.UnixNano())
+	return syscall.Settimeofday(&tv)
+}
+
+// SetTimezone points /etc/localtime at the zoneinfo of timezone
+func SetTimezone(timezone string) error {
+	if timezone == "" {
+		return nil
+	}
+	zoneinfo := filepath.Join(ZoneInfoDir, timezone)
+	if _, err := os.Stat(zoneinfo); err != nil {
+		return fmt.Errorf("unknown timezone %s: %v", timezone, err)
+	}
+	os.Remove("/etc/localtime")
+	if err := os.Symlink(zoneinfo, "/etc/localtime"); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic("/etc/timezone", []byte(timezone+"\n"), 0644)
+}
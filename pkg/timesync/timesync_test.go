@@ -0,0 +1,59 @@
+package timesync
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rancher/os/config"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateNtpConf(t *testing.T) {
+	assert := require.New(t)
+
+	conf := string(GenerateNtpConf(config.TimeConfig{
+		Servers: []string{"10.0.0.1", "time.example.com"},
+		Pools:   []string{"pool.ntp.org"},
+	}))
+	assert.Contains(conf, "server 10.0.0.1 iburst\n")
+	assert.Contains(conf, "server time.example.com iburst\n")
+	assert.Contains(conf, "pool pool.ntp.org iburst\n")
+}
+
+func putNtpTime(b []byte, t time.Time) {
+	binary.BigEndian.PutUint32(b[0:4], uint32(t.Unix()+ntpEpochOffset))
+	binary.BigEndian.PutUint32(b[4:8], uint32((int64(t.Nanosecond())<<32)/1e9))
+}
+
+func TestQuery(t *testing.T) {
+	assert := require.New(t)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(err)
+	defer conn.Close()
+
+	// a server that is an hour ahead
+	go func() {
+		request := make([]byte, 48)
+		_, addr, err := conn.ReadFrom(request)
+		if err != nil {
+			return
+		}
+		response := make([]byte, 48)
+		response[0] = 3<<3 | 4
+		response[1] = 2
+		now := time.Now().Add(time.Hour)
+		putNtpTime(response[32:40], now)
+		putNtpTime(response[40:48], now)
+		conn.WriteTo(response, addr)
+	}()
+
+	offset, err := Query(conn.LocalAddr().String(), time.Second)
+	assert.NoError(err)
+	assert.InDelta(float64(time.Hour), float64(offset), float64(time.Second))
+
+	assert.Equal(time.Unix(0, 0).UTC(), ntpTime([]byte{0x83, 0xaa, 0x7e, 0x80, 0, 0, 0, 0}).UTC())
+}
@@ -0,0 +1,42 @@
+// +build linux
+
+package util
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// KEYCTL_READ of linux/keyctl.h
+const keyctlRead = 11
+
+// KeyringKey reads the user key with the description, as keyctl pipe would.
+// request_key only searches the thread, process and session keyrings of
+// the caller, so the key has to be in one of them. Containers get a new
+// session keyring, so keys of init are not found from a container.
+func KeyringKey(description string) ([]byte, error) {
+	keyType, err := syscall.BytePtrFromString("user")
+	if err != nil {
+		return nil, err
+	}
+	desc, err := syscall.BytePtrFromString(description)
+	if err != nil {
+		return nil, err
+	}
+	id, _, errno := syscall.Syscall6(syscall.SYS_REQUEST_KEY, uintptr(unsafe.Pointer(keyType)), uintptr(unsafe.Pointer(desc)), 0, 0, 0, 0)
+	if errno != 0 {
+		return nil, errno
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		n, _, errno := syscall.Syscall6(syscall.SYS_KEYCTL, keyctlRead, id, uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), 0, 0)
+		if errno != 0 {
+			return nil, errno
+		}
+		if int(n) <= len(buf) {
+			return buf[:n], nil
+		}
+		buf = make([]byte, n)
+	}
+}
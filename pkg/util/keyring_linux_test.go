@@ -0,0 +1,47 @@
+// +build linux
+
+package util
+
+import (
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+)
+
+// KEY_SPEC_PROCESS_KEYRING of linux/keyctl.h
+const keySpecProcessKeyring = -2
+
+// addProcessKey adds a user key to the process keyring, as keyctl padd
+// would
+func addProcessKey(description string, payload []byte) error {
+	keyType, err := syscall.BytePtrFromString("user")
+	if err != nil {
+		return err
+	}
+	desc, err := syscall.BytePtrFromString(description)
+	if err != nil {
+		return err
+	}
+	keyring := keySpecProcessKeyring
+	_, _, errno := syscall.Syscall6(syscall.SYS_ADD_KEY, uintptr(unsafe.Pointer(keyType)), uintptr(unsafe.Pointer(desc)), uintptr(unsafe.Pointer(&payload[0])), uintptr(len(payload)), uintptr(keyring), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func TestKeyringKey(t *testing.T) {
+	assert := require.New(t)
+
+	if err := addProcessKey("rancher:test-state", []byte("keyring-key")); err != nil {
+		t.Skipf("can not add a key: %v", err)
+	}
+	key, err := KeyringKey("rancher:test-state")
+	assert.NoError(err)
+	assert.Equal("keyring-key", string(key))
+
+	_, err = KeyringKey("rancher:missing")
+	assert.Error(err)
+}
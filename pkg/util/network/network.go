@@ -21,24 +21,27 @@ var (
 	ErrNotFound  = errors.New("Failed to find resource")
 )
 
-func GetServices(urls []string) ([]string, error) {
-	return getServices(urls, "services")
+func GetServices(repos config.Repositories) ([]string, error) {
+	return getServices(repos, "services")
 }
 
-func GetConsoles(urls []string) ([]string, error) {
-	return getServices(urls, "consoles")
+func GetConsoles(repos config.Repositories) ([]string, error) {
+	return getServices(repos, "consoles")
 }
 
-func GetEngines(urls []string) ([]string, error) {
-	return getServices(urls, "engines")
+func GetEngines(repos config.Repositories) ([]string, error) {
+	return getServices(repos, "engines")
 }
 
-func getServices(urls []string, key string) ([]string, error) {
+func getServices(repos config.Repositories, key string) ([]string, error) {
 	result := []string{}
 
-	for _, url := range urls {
-		indexURL := fmt.Sprintf("%s/index.yml", url)
-		content, err := LoadResource(indexURL, true)
+	for _, repo := range repos {
+		if repo.URL == "" {
+			continue
+		}
+		indexURL := fmt.Sprintf("%s/index.yml", repo.URL)
+		content, err := LoadSignedResource(indexURL, true, repo.PublicKeys)
 		if err != nil {
 			log.Errorf("Failed to load %s: %v", indexURL, err)
 			continue
@@ -152,10 +155,12 @@ func LoadServiceResource(name string, useNetwork bool, cfg *config.CloudConfig)
 		return nil, ErrNoNetwork
 	}
 
-	urls := cfg.Rancher.Repositories.ToArray()
-	for _, url := range urls {
-		serviceURL := serviceURL(url, name)
-		bytes, err = LoadResource(serviceURL, useNetwork)
+	for _, repo := range cfg.Rancher.Repositories {
+		if repo.URL == "" {
+			continue
+		}
+		serviceURL := serviceURL(repo.URL, name)
+		bytes, err = LoadSignedResource(serviceURL, useNetwork, repo.PublicKeys)
 		if err == nil {
 			log.Debugf("Loaded %s from %s", name, serviceURL)
 			return bytes, nil
@@ -0,0 +1,187 @@
+package network
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"strings"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+var (
+	ErrNoSignature  = errors.New("Resource is not signed")
+	ErrBadSignature = errors.New("Resource signature does not match any trusted public key")
+
+	// id-Ed25519 of RFC 8410
+	oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+)
+
+// SignatureLocation returns where the detached signature of location is
+// published: the same path with a .sig suffix, keeping any query string.
+func SignatureLocation(location string) string {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme == "" {
+		return location + ".sig"
+	}
+	u.Path += ".sig"
+	return u.String()
+}
+
+// ParsePublicKeys parses PEM encoded PKIX public keys, as written by
+// `openssl ec -pubout`, `openssl rsa -pubout` or `openssl pkey -pubout`
+// for Ed25519. Other key types are rejected, as they can't verify anything.
+func ParsePublicKeys(pems []string) ([]crypto.PublicKey, error) {
+	keys := []crypto.PublicKey{}
+	for _, p := range pems {
+		rest := []byte(p)
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			key, err := parsePublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("No PEM encoded public keys found")
+	}
+	return keys, nil
+}
+
+// parsePublicKey parses Ed25519 keys itself, as crypto/x509 doesn't
+func parsePublicKey(der []byte) (crypto.PublicKey, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(der, &spki); err == nil && spki.Algorithm.Algorithm.Equal(oidEd25519) {
+		if len(spki.PublicKey.Bytes) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("Invalid Ed25519 public key of %d bytes", len(spki.PublicKey.Bytes))
+		}
+		return ed25519.PublicKey(spki.PublicKey.Bytes), nil
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("Unsupported public key type %T, only ECDSA, RSA and Ed25519 keys are supported", key)
+}
+
+// VerifySignature checks a signature of the SHA-256 digest of content, as made
+// by `openssl dgst -sha256 -sign key.pem`, or for Ed25519 keys of content
+// itself, as made by `openssl pkeyutl -sign -rawin -inkey key.pem`. The
+// signature is either raw or base64 encoded.
+func VerifySignature(content, signature []byte, keys []crypto.PublicKey) error {
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature))); err == nil {
+		signature = decoded
+	}
+	digest := sha256.Sum256(content)
+
+	for _, key := range keys {
+		switch key := key.(type) {
+		case *ecdsa.PublicKey:
+			var sig struct {
+				R, S *big.Int
+			}
+			if _, err := asn1.Unmarshal(signature, &sig); err != nil {
+				continue
+			}
+			if ecdsa.Verify(key, digest[:], sig.R, sig.S) {
+				return nil
+			}
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+				return nil
+			}
+		case ed25519.PublicKey:
+			if len(signature) == ed25519.SignatureSize && ed25519.Verify(key, content, signature) {
+				return nil
+			}
+		}
+	}
+	return ErrBadSignature
+}
+
+// VerifyResource checks content loaded from location against its detached
+// signature. Nothing is checked when there are no trusted publicKeys.
+func VerifyResource(location string, content []byte, network bool, publicKeys []string) error {
+	if len(publicKeys) == 0 {
+		return nil
+	}
+	keys, err := ParsePublicKeys(publicKeys)
+	if err != nil {
+		return err
+	}
+
+	sigLocation := SignatureLocation(location)
+	signature, err := loadSignature(sigLocation, network)
+	if err != nil || len(signature) == 0 {
+		return fmt.Errorf("%v: failed to load %s: %v", ErrNoSignature, sigLocation, err)
+	}
+
+	if err := VerifySignature(content, signature, keys); err != nil {
+		return fmt.Errorf("%s: %v", location, err)
+	}
+	return nil
+}
+
+// LoadSignedResource is LoadResource for content that must be signed by one
+// of publicKeys, if any are given.
+func LoadSignedResource(location string, network bool, publicKeys []string) ([]byte, error) {
+	bytes, err := LoadResource(location, network)
+	if err != nil {
+		return nil, err
+	}
+	err = VerifyResource(location, bytes, network, publicKeys)
+	if err != nil && isHTTP(location) && network {
+		// the cached copy may be older than the published signature
+		if bytes, err = LoadFromNetwork(location); err != nil {
+			return nil, err
+		}
+		err = VerifyResource(location, bytes, network, publicKeys)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes, nil
+}
+
+// signatures are never read from the cache, so they can't go stale
+func loadSignature(location string, network bool) ([]byte, error) {
+	if isHTTP(location) {
+		if !network {
+			return nil, ErrNoNetwork
+		}
+		return LoadFromNetwork(location)
+	} else if strings.HasPrefix(location, "/") {
+		return ioutil.ReadFile(location)
+	}
+
+	return nil, ErrNotFound
+}
+
+func isHTTP(location string) bool {
+	return strings.HasPrefix(location, "http:/") || strings.HasPrefix(location, "https:/")
+}
@@ -0,0 +1,146 @@
+package network
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+func publicKeyPEM(t *testing.T, key crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// spkiPEM encodes a raw public key with its algorithm, as openssl pkey -pubout
+func spkiPEM(t *testing.T, algorithm asn1.ObjectIdentifier, key []byte) string {
+	der, err := asn1.Marshal(struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: algorithm},
+		PublicKey: asn1.BitString{Bytes: key, BitLength: 8 * len(key)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestSignatureLocation(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("/var/releases.yml.sig", SignatureLocation("/var/releases.yml"))
+	assert.Equal("https://releases.rancher.com/os/releases.yml.sig?current=v1.5.0",
+		SignatureLocation("https://releases.rancher.com/os/releases.yml?current=v1.5.0"))
+}
+
+func TestLoadSignedResource(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "signature")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+
+	content := []byte("current: rancher/os:v1.5.0\n")
+	digest := sha256.Sum256(content)
+	r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+	assert.NoError(err)
+	signature, err := asn1.Marshal(struct{ R, S interface{} }{r, s})
+	assert.NoError(err)
+
+	index := filepath.Join(dir, "releases.yml")
+	assert.NoError(ioutil.WriteFile(index, content, 0644))
+
+	trusted := []string{publicKeyPEM(t, &ecKey.PublicKey)}
+	untrusted := []string{publicKeyPEM(t, &otherKey.PublicKey)}
+
+	// no trusted keys configured, nothing to check
+	bytes, err := LoadSignedResource(index, false, nil)
+	assert.NoError(err)
+	assert.Equal(content, bytes)
+
+	// unsigned
+	_, err = LoadSignedResource(index, false, trusted)
+	assert.Error(err)
+
+	assert.NoError(ioutil.WriteFile(index+".sig", []byte(base64.StdEncoding.EncodeToString(signature)), 0644))
+	bytes, err = LoadSignedResource(index, false, trusted)
+	assert.NoError(err)
+	assert.Equal(content, bytes)
+
+	_, err = LoadSignedResource(index, false, untrusted)
+	assert.Error(err)
+
+	// tampered
+	assert.NoError(ioutil.WriteFile(index, []byte("current: evil/os:v1.5.0\n"), 0644))
+	_, err = LoadSignedResource(index, false, trusted)
+	assert.Error(err)
+}
+
+func TestVerifyRSASignature(t *testing.T) {
+	assert := require.New(t)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(err)
+	keys, err := ParsePublicKeys([]string{publicKeyPEM(t, &key.PublicKey)})
+	assert.NoError(err)
+
+	content := []byte("services:\n- kernel-headers\n")
+	digest := sha256.Sum256(content)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	assert.NoError(err)
+
+	// openssl writes the raw signature
+	assert.NoError(VerifySignature(content, signature, keys))
+	assert.Equal(ErrBadSignature, VerifySignature([]byte("services: []\n"), signature, keys))
+}
+
+func TestVerifyEd25519Signature(t *testing.T) {
+	assert := require.New(t)
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(err)
+	keys, err := ParsePublicKeys([]string{spkiPEM(t, oidEd25519, public)})
+	assert.NoError(err)
+	assert.Equal([]crypto.PublicKey{public}, keys)
+
+	content := []byte("services:\n- kernel-headers\n")
+	signature := ed25519.Sign(private, content)
+	assert.NoError(VerifySignature(content, []byte(base64.StdEncoding.EncodeToString(signature)), keys))
+	assert.Equal(ErrBadSignature, VerifySignature([]byte("services: []\n"), signature, keys))
+	assert.Equal(ErrBadSignature, VerifySignature(content, signature[:32], keys))
+
+	_, err = ParsePublicKeys([]string{spkiPEM(t, oidEd25519, public[:16])})
+	assert.Error(err)
+}
+
+func TestParseUnsupportedPublicKey(t *testing.T) {
+	assert := require.New(t)
+
+	// id-X25519 keys can't sign
+	_, err := ParsePublicKeys([]string{spkiPEM(t, asn1.ObjectIdentifier{1, 3, 101, 110}, make([]byte, 32))})
+	assert.Error(err)
+}